@@ -1,6 +1,6 @@
 #!/bin/bash
 set -e
-VERSION=$(grep 'const VERSION' cmd/main.go | sed -E 's/.*"([^"]+)".*/\1/')
+VERSION=$(grep 'const VERSION' gobackup/gobackup.go | sed -E 's/.*"([^"]+)".*/\1/')
 BIN_NAME="gobackup"
 
 # Platforms you want to build for
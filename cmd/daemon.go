@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/zaldre/gobackup/gobackup"
+)
+
+// runDaemon implements `gobackup daemon [flags] [library.json]`: it runs the
+// scheduled entries until SIGINT or SIGTERM and reloads the library on SIGHUP
+func runDaemon(args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	opts := gobackup.DefaultRunOptions()
+	fs.IntVar(&opts.Jobs, "jobs", 2, "maximum number of entries to run concurrently")
+	fs.IntVar(&opts.PerDestination, "per-destination", opts.PerDestination, "maximum concurrent entries writing to the same destination disk (0 = unlimited)")
+	fs.IntVar(&opts.PerHost, "per-host", opts.PerHost, "maximum concurrent rsync entries pulling from the same remote host (0 = unlimited)")
+	jitter := fs.Duration("jitter", time.Minute, "maximum random delay added before each scheduled run")
+	logFormat, logLevel := logFlags(fs)
+	fs.Parse(args)
+	if err := setupLogging(*logFormat, *logLevel, os.Stderr); err != nil {
+		return err
+	}
+	opts.LogFormat = *logFormat
+	libraryFile := "library.json"
+	if fs.NArg() >= 1 {
+		libraryFile = fs.Arg(0)
+	}
+
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+	// Hangups arriving while a reload is pending are folded into it
+	reload := make(chan struct{}, 1)
+	go func() {
+		for range hangups {
+			select {
+			case reload <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	ctx, stop := signalContext()
+	defer stop()
+	return gobackup.RunDaemon(ctx, gobackup.DaemonOptions{
+		LibraryFile: libraryFile,
+		Run:         opts,
+		Jitter:      *jitter,
+		Reload:      reload,
+	})
+}
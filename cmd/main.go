@@ -1,23 +1,136 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/zaldre/gobackup/gobackup"
 )
 
-const VERSION = "0.1.1"
+// logFlags adds the flags choosing how the command logs
+func logFlags(fs *flag.FlagSet) (format, level *string) {
+	format = fs.String("log-format", "text", "log format: text or json")
+	level = fs.String("log-level", "info", "minimum log level: debug, info, warn or error")
+	return format, level
+}
+
+// setupLogging makes the default logger write records in format to w
+func setupLogging(format, level string, w io.Writer) error {
+	handler, err := gobackup.NewLogHandler(format, level, w)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// signalContext is cancelled by SIGINT or SIGTERM, which cancels the run,
+// killing running entries and cleaning up after them. A second signal exits
+// at once.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+	return ctx, stop
+}
+
+// fatal logs err and exits with the code for it
+func fatal(err error) {
+	code := gobackup.ExitCode(err)
+	if code == gobackup.ExitWarnings {
+		slog.Warn(err.Error())
+	} else {
+		slog.Error(err.Error())
+	}
+	os.Exit(code)
+}
 
 func main() {
+	//Subcommands
+	if len(os.Args) >= 2 && os.Args[1] == "daemon" {
+		if err := runDaemon(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if len(os.Args) >= 2 && os.Args[1] == "systemd" {
+		if err := runSystemd(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if len(os.Args) >= 2 && os.Args[1] == "status" {
+		if err := runStatus(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if len(os.Args) >= 2 && os.Args[1] == "notify" {
+		if err := runNotify(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
 	//Setup logic, cmdline args
-	if len(os.Args) < 2 {
-		log.Fatal("Usage: backup nameoflibrary [library.json]")
+	opts := gobackup.DefaultRunOptions()
+	flag.IntVar(&opts.Jobs, "jobs", opts.Jobs, "maximum number of entries to run concurrently")
+	flag.IntVar(&opts.PerDestination, "per-destination", opts.PerDestination, "maximum concurrent entries writing to the same destination disk (0 = unlimited)")
+	flag.IntVar(&opts.PerHost, "per-host", opts.PerHost, "maximum concurrent rsync entries pulling from the same remote host (0 = unlimited)")
+	flag.DurationVar(&opts.LockWait, "wait", opts.LockWait, "how long to wait for an entry locked by another run before failing (0 = fail fast)")
+	flag.BoolVar(&opts.DryRun, "dry-run", false, "print the commands, files and retention deletions of each entry without running anything")
+	summaryJSON := flag.String("summary-json", "", "write the end-of-run summary of every entry to this file as JSON")
+	logFormat, logLevel := logFlags(flag.CommandLine)
+	flag.Parse()
+	// On a terminal, progress is drawn as a bar below the log
+	stderr := io.Writer(os.Stderr)
+	if *logFormat == "text" && gobackup.IsTerminal(os.Stderr) {
+		opts.Bar = gobackup.NewProgressBar(os.Stderr)
+		stderr = opts.Bar
+	}
+	if err := setupLogging(*logFormat, *logLevel, stderr); err != nil {
+		fatal(err)
+	}
+	opts.LogFormat = *logFormat
+	if flag.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "Usage: backup daemon [flags] [library.json]\n       backup systemd generate [--user] [--write] [entries]\n       backup notify test [--library library.json]\n       backup status [--library library.json] [--max-age duration] [entries]\n       backup [--jobs N] [--per-destination N] [--per-host N] [--wait duration] [--dry-run] [--summary-json file] [--log-format text|json] [--log-level level] nameoflibrary [library.json]")
+		os.Exit(gobackup.ExitConfigError)
 	}
 	LibraryFile := "library.json"
-	if len(os.Args) >= 3 {
-		LibraryFile = os.Args[2]
+	if flag.NArg() >= 2 {
+		LibraryFile = flag.Arg(1)
+	}
+	// Problems with entries that are not run are left for the runner to
+	// ignore
+	library, err := gobackup.LoadLibrary(LibraryFile)
+	var invalid *gobackup.LibraryValidationError
+	if err != nil && !errors.As(err, &invalid) {
+		fatal(err)
+	}
+	entries := strings.Split(flag.Arg(0), ",")
+	ctx, stop := signalContext()
+	defer stop()
+	//Begin the run
+	runner := gobackup.NewRunner(library, opts)
+	runner.Output = os.Stdout
+	result, err := runner.Run(ctx, entries)
+	if !opts.DryRun && len(result.Entries) > 0 {
+		// Print how every entry went
+		gobackup.WriteSummary(os.Stdout, result)
+		if *summaryJSON != "" {
+			if jsonErr := gobackup.WriteSummaryJSON(*summaryJSON, result); jsonErr != nil {
+				slog.Error(jsonErr.Error())
+			}
+		}
 	}
-	//Begin Logic call
-	if err := Logic(LibraryFile); err != nil {
-		log.Fatal(err)
+	if err != nil {
+		fatal(err)
 	}
 }
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/zaldre/gobackup/gobackup"
+)
+
+// runNotify implements `gobackup notify test`, which sends a sample report to
+// the configured notification targets
+func runNotify(args []string) error {
+	if len(args) < 1 || args[0] != "test" {
+		return fmt.Errorf("Usage: backup notify test [--library library.json] [--target n]")
+	}
+	fs := flag.NewFlagSet("notify test", flag.ExitOnError)
+	libraryFile := fs.String("library", "library.json", "library file holding the notification settings")
+	only := fs.Int("target", 0, "only test the nth notification target (1-based)")
+	fs.Parse(args[1:])
+
+	library, err := gobackup.LoadLibrary(*libraryFile)
+	if err != nil {
+		return err
+	}
+	results, err := gobackup.SendTestNotifications(library, *only)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Printf("Notification %d (%s): failed: %v\n", result.Target, result.Type, result.Err)
+			failed++
+			continue
+		}
+		fmt.Printf("Notification %d (%s): sent\n", result.Target, result.Type)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d test notification(s) failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zaldre/gobackup/gobackup"
+)
+
+// runStatus implements `gobackup status [flags] [entry1,entry2,...]`. It fails
+// when any entry is overdue or misconfigured, so it can serve as a
+// monitoring check.
+func runStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	libraryFile := fs.String("library", "library.json", "library file listing the entries")
+	var opts gobackup.StatusOptions
+	fs.DurationVar(&opts.MaxAge, "max-age", 0, "how old the last success of entries without MaxAge or Schedule may be (0 = not checked)")
+	fs.DurationVar(&opts.Grace, "grace", time.Hour, "how long a scheduled entry may take to succeed after its scheduled time")
+	fs.Parse(args)
+
+	// Invalid entries are still listed, with the problem status can see
+	library, err := gobackup.LoadLibrary(*libraryFile)
+	var invalid *gobackup.LibraryValidationError
+	if err != nil && !errors.As(err, &invalid) {
+		return err
+	}
+	return gobackup.Status(os.Stdout, library, splitEntries(fs.Args()), opts)
+}
+
+// splitEntries returns the entries named by args, each of which may list
+// several separated by commas as on the main command line
+func splitEntries(args []string) []string {
+	var entries []string
+	for _, arg := range args {
+		for _, entry := range strings.Split(arg, ",") {
+			if entry != "" {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zaldre/gobackup/gobackup"
+)
+
+// runSystemd implements `gobackup systemd generate [flags] [entry,entry...]`,
+// printing the units or writing them into the systemd unit directory
+func runSystemd(args []string) error {
+	if len(args) < 1 || args[0] != "generate" {
+		return fmt.Errorf("Usage: backup systemd generate [--user] [--write] [flags] [entry,entry...]")
+	}
+
+	fs := flag.NewFlagSet("systemd generate", flag.ExitOnError)
+	var cfg gobackup.UnitConfig
+	fs.BoolVar(&cfg.User, "user", false, "generate user units instead of system units")
+	fs.StringVar(&cfg.LibraryFile, "library", "library.json", "library file the units should run against")
+	fs.StringVar(&cfg.Binary, "binary", "", "path to the gobackup binary (default: this executable)")
+	fs.BoolVar(&cfg.Group, "group", false, "generate one service and timer per schedule instead of per entry")
+	fs.DurationVar(&cfg.Jitter, "jitter", 0, "RandomizedDelaySec for the timers")
+	fs.IntVar(&cfg.Nice, "nice", 10, "Nice= level for the services")
+	fs.IntVar(&cfg.CPUWeight, "cpu-weight", 20, "CPUWeight= for the services (0 to omit)")
+	fs.IntVar(&cfg.IOWeight, "io-weight", 20, "IOWeight= for the services (0 to omit)")
+	fs.StringVar(&cfg.MemoryMax, "memory-max", "", "MemoryMax= for the services, e.g. 2G")
+	fs.StringVar(&cfg.OnFailureCommand, "on-failure-command", `logger -t gobackup -p user.err "systemd unit %i failed"`, "command run by "+gobackup.FailureUnitName+" when a backup service fails")
+	write := fs.Bool("write", false, "write the units into the systemd unit directory instead of printing them")
+	dir := fs.String("dir", "", "unit directory to write to (default: /etc/systemd/system, or ~/.config/systemd/user with --user)")
+	fs.Parse(args[1:])
+
+	if cfg.Binary == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("unable to determine gobackup binary path, pass --binary: %w", err)
+		}
+		cfg.Binary = exe
+	}
+	libraryFile, err := filepath.Abs(cfg.LibraryFile)
+	if err != nil {
+		return fmt.Errorf("unable to resolve library path: %w", err)
+	}
+	cfg.LibraryFile = libraryFile
+
+	lib, err := gobackup.LoadLibrary(cfg.LibraryFile)
+	if err != nil {
+		return err
+	}
+	var entries []string
+	if fs.NArg() >= 1 {
+		entries = strings.Split(fs.Arg(0), ",")
+	}
+
+	units, err := gobackup.GenerateUnits(lib.Entries, entries, cfg)
+	if err != nil {
+		return err
+	}
+
+	if !*write {
+		for _, unit := range units {
+			fmt.Printf("# %s\n%s\n", unit.Name, unit.Content)
+		}
+		return nil
+	}
+
+	unitDir := *dir
+	if unitDir == "" {
+		unitDir = "/etc/systemd/system"
+		if cfg.User {
+			configDir, err := os.UserConfigDir()
+			if err != nil {
+				return fmt.Errorf("unable to determine user config directory: %w", err)
+			}
+			unitDir = filepath.Join(configDir, "systemd", "user")
+		}
+	}
+	written, err := gobackup.WriteUnits(unitDir, units)
+	for _, path := range written {
+		fmt.Println("Wrote", path)
+	}
+	if err != nil {
+		return err
+	}
+	systemctl := "systemctl"
+	if cfg.User {
+		systemctl = "systemctl --user"
+	}
+	fmt.Printf("Run '%s daemon-reload' and enable the timers with '%s enable --now <name>.timer'\n", systemctl, systemctl)
+	return nil
+}
@@ -1,3 +1,3 @@
-module backup
+module github.com/zaldre/gobackup
 
 go 1.25.2
@@ -0,0 +1,355 @@
+package gobackup
+
+import (
+	archivetar "archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterBackupType("command", commandType{})
+}
+
+// commandType stores the output of a command, such as a database dump
+type commandType struct{}
+
+func (commandType) Validate(backup *Backup) []error {
+	var problems []error
+	if strings.TrimSpace(backup.Command) == "" {
+		problems = append(problems, fmt.Errorf("command backup requires a Command"))
+	}
+	if backup.Destination == "" {
+		problems = append(problems, fmt.Errorf("Destination is required"))
+	}
+	if _, _, err := commandCompression(backup); err != nil {
+		problems = append(problems, err)
+	}
+	// The output is stored and restored under this name, which must not
+	// lead anywhere else
+	if name := backup.CommandFileName; name != "" && (strings.Contains(name, "/") || name == "." || name == "..") {
+		problems = append(problems, fmt.Errorf("CommandFileName %q must be a plain file name", name))
+	}
+	return problems
+}
+
+func (commandType) Plan(backup *Backup, plan *Plan) {
+	plan.command(backup)
+}
+
+func (commandType) Run(ctx context.Context, backup *Backup, log *slog.Logger, progress ProgressFunc) (*BackupResult, error) {
+	return command(ctx, backup, log, progress)
+}
+
+func (commandType) Snapshots(backup *Backup) ([]Snapshot, error) {
+	return archiveSnapshots(backup)
+}
+
+// Restore unpacks an archive written with CommandArchive, or decompresses
+// the stored output into target under its CommandFileName
+func (commandType) Restore(ctx context.Context, backup *Backup, snapshot Snapshot, target string, log *slog.Logger) error {
+	if backup.CommandArchive {
+		return extractArchive(ctx, snapshot.Path, target, log)
+	}
+	for _, compressor := range compressors {
+		if strings.HasSuffix(snapshot.Path, "."+compressor.extension) {
+			program := compressor.args[0]
+			dst := filepath.Join(target, commandFileName(backup))
+			return runRestoreCommand(ctx, program, fmt.Sprintf("%s -dc %s > %s", program, shellQuote(snapshot.Path), shellQuote(dst)), log)
+		}
+	}
+	return fmt.Errorf("unknown compression of %s", snapshot.Path)
+}
+
+// compressors maps a CompressionType to the program that compresses a
+// stream and the file extension it produces
+var compressors = map[string]struct {
+	args      []string
+	extension string
+}{
+	"gzip":  {[]string{"gzip", "-c"}, "gz"},
+	"bzip2": {[]string{"bzip2", "-c"}, "bz2"},
+	"xz":    {[]string{"xz", "-c"}, "xz"},
+	"zstd":  {[]string{"zstd", "-c", "-q"}, "zst"},
+}
+
+// commandFileName returns the name the command output is stored under
+func commandFileName(backup *Backup) string {
+	if backup.CommandFileName != "" {
+		return backup.CommandFileName
+	}
+	return backup.Name + ".out"
+}
+
+// commandCompression returns the compressor command for the entry's
+// CompressionType and the extension of the file it produces
+func commandCompression(backup *Backup) ([]string, string, error) {
+	compressionType := backup.CompressionType
+	if compressionType == "" {
+		compressionType = "gzip" // default to gzip
+	}
+	compressor, ok := compressors[compressionType]
+	if !ok {
+		return nil, "", fmt.Errorf("invalid compression type: %s (supported: gzip, bzip2, xz, zstd)", compressionType)
+	}
+	// Keep the dump's own extension, e.g. all.sql becomes <Name>_<timestamp>.sql.gz
+	fileExtension := compressor.extension
+	if ext := strings.TrimPrefix(filepath.Ext(commandFileName(backup)), "."); ext != "" {
+		fileExtension = ext + "." + compressor.extension
+	}
+	return compressor.args, fileExtension, nil
+}
+
+// command runs backup.Command and stores its stdout in Destination, either as
+// a single compressed file or, with CommandArchive, as a tar archive holding
+// one file. The entry fails if the command exits non-zero, even if it
+// produced output.
+func command(ctx context.Context, backup *Backup, log *slog.Logger, progress ProgressFunc) (*BackupResult, error) {
+	log = log.With("phase", "command")
+	if strings.TrimSpace(backup.Command) == "" {
+		return nil, fmt.Errorf("command backup requires a Command")
+	}
+	if backup.CommandArchive {
+		return commandArchive(ctx, backup, log, progress)
+	}
+
+	compressor, fileExtension, err := commandCompression(backup)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkDestination(backup.Destination); err != nil {
+		return nil, err
+	}
+
+	timestamp := time.Now().Format("2006.01.02_15.04.05")
+	var scratch string = GetEnv("SCRATCH", "/tmp")
+	tempFile, err := os.CreateTemp(scratch, fmt.Sprintf("gobackup_%s_%s_*.%s", backup.Name, timestamp, fileExtension))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tempFilePath := tempFile.Name()
+	defer removeIfExists(tempFilePath)
+
+	log.Info("Running command", "command", backup.Command)
+	log.Info("Compressing output to temporary file", "compression", compressor[0], "temp", tempFilePath)
+	// There is no telling how much a command will write, so the ETA is
+	// based on the size of the previous archive
+	meter := startProgress(progress, backup.Name, "command", tempFilePath)
+	if progress != nil {
+		meter.totalWritten.Store(lastArchiveSize(backup))
+	}
+	_, err = pipeCommand(ctx, backup.Command, compressor, tempFile, log)
+	meter.finish()
+	if closeErr := tempFile.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write temporary file: %w", closeErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Command completed")
+
+	finalPath := filepath.Join(backup.Destination, fmt.Sprintf("%s_%s.%s", backup.Name, timestamp, fileExtension))
+	if err := moveIntoPlace(tempFilePath, finalPath, log); err != nil {
+		return nil, err
+	}
+	removed, err := cleanupOldBackups(backup, fileExtension, log)
+	if err != nil {
+		return nil, err
+	}
+	return &BackupResult{ArchivePath: finalPath, Removed: removed}, nil
+}
+
+// pipeCommand runs shell command with its stdout fed through the compressor
+// into dst, logging what either writes to stderr, and returns how many bytes
+// the command wrote. Both processes must succeed and both are killed if ctx
+// is cancelled.
+func pipeCommand(ctx context.Context, command string, compressor []string, dst io.Writer, log *slog.Logger) (int64, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, fmt.Errorf("failed to create pipe: %w", err)
+	}
+
+	tail := newTailBuffer(outputTailLines)
+	out := newLineWriter(func(line string) {
+		tail.addLine(line)
+		log.Info(line)
+	})
+	defer out.Flush()
+	stdout := &countingWriter{w: w}
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdout = stdout
+	cmd.Stderr = out
+	killGroupOnCancel(cmd)
+	comp := exec.CommandContext(ctx, compressor[0], compressor[1:]...)
+	comp.Stdin = r
+	comp.Stdout = dst
+	comp.Stderr = out
+
+	if err := comp.Start(); err != nil {
+		r.Close()
+		w.Close()
+		return 0, fmt.Errorf("failed to start %s: %w", compressor[0], err)
+	}
+	r.Close()
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		comp.Wait()
+		return 0, fmt.Errorf("failed to start command: %w", err)
+	}
+
+	// The output is copied through stdout until the command exits
+	cmdErr := cmd.Wait()
+	w.Close()
+	compErr := comp.Wait()
+	out.Flush()
+	if (cmdErr != nil || compErr != nil) && ctx.Err() != nil {
+		return 0, fmt.Errorf("command interrupted: %w", ctx.Err())
+	}
+	if cmdErr != nil {
+		return 0, withOutput(fmt.Errorf("command failed: %w", cmdErr), tail)
+	}
+	if compErr != nil {
+		return 0, withOutput(fmt.Errorf("%s failed: %w", compressor[0], compErr), tail)
+	}
+	return stdout.n, nil
+}
+
+// commandArchive stores the command output as the only member of a tar
+// archive, so it gets the same compression and retention. tar needs the
+// size of a member before its data, so the output is compressed into scratch
+// as it arrives and the archive is put together from that once the size is
+// known: the compressed header, the output and the compressed end of the
+// archive. Each of the compressors reads such concatenated streams as one.
+func commandArchive(ctx context.Context, backup *Backup, log *slog.Logger, progress ProgressFunc) (*BackupResult, error) {
+	compressor, fileExtension, err := commandArchiveCompression(backup)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDestination(backup.Destination); err != nil {
+		return nil, err
+	}
+
+	timestamp := time.Now().Format("2006.01.02_15.04.05")
+	var scratch string = GetEnv("SCRATCH", "/tmp")
+	spool, err := os.CreateTemp(scratch, fmt.Sprintf("gobackup_%s_%s_*.out", backup.Name, timestamp))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer removeIfExists(spool.Name())
+	defer spool.Close()
+
+	log.Info("Running command", "command", backup.Command)
+	log.Info("Compressing output to temporary file", "compression", compressor[0], "temp", spool.Name())
+	meter := startProgress(progress, backup.Name, "command", spool.Name())
+	if progress != nil {
+		meter.totalWritten.Store(lastArchiveSize(backup))
+	}
+	size, err := pipeCommand(ctx, backup.Command, compressor, spool, log)
+	meter.finish()
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Command completed, writing archive", "size", size)
+
+	tempFile, err := os.CreateTemp(scratch, fmt.Sprintf("gobackup_%s_%s_*.%s", backup.Name, timestamp, fileExtension))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tempFilePath := tempFile.Name()
+	defer removeIfExists(tempFilePath)
+	err = writeCommandArchive(ctx, backup, compressor, spool, size, tempFile)
+	if closeErr := tempFile.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write temporary file: %w", closeErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	finalPath := filepath.Join(backup.Destination, fmt.Sprintf("%s_%s.%s", backup.Name, timestamp, fileExtension))
+	if err := moveIntoPlace(tempFilePath, finalPath, log); err != nil {
+		return nil, err
+	}
+	removed, err := cleanupOldBackups(backup, fileExtension, log)
+	if err != nil {
+		return nil, err
+	}
+	return &BackupResult{ArchivePath: finalPath, Removed: removed}, nil
+}
+
+// commandArchiveCompression returns the compressor command for the entry's
+// CompressionType and the extension of the archive, as tar would name it
+func commandArchiveCompression(backup *Backup) ([]string, string, error) {
+	_, fileExtension, err := tarCompression(backup)
+	if err != nil {
+		return nil, "", err
+	}
+	compressor, _, err := commandCompression(backup)
+	return compressor, fileExtension, err
+}
+
+// writeCommandArchive writes to dst a compressed tar archive whose only
+// member is the size bytes of command output compressed into spool
+func writeCommandArchive(ctx context.Context, backup *Backup, compressor []string, spool *os.File, size int64, dst io.Writer) error {
+	var header bytes.Buffer
+	err := archivetar.NewWriter(&header).WriteHeader(&archivetar.Header{
+		Typeflag: archivetar.TypeReg,
+		Name:     "./" + commandFileName(backup),
+		Mode:     0644,
+		Size:     size,
+		ModTime:  time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write archive header: %w", err)
+	}
+	// The data is padded to whole blocks and the archive ends with two
+	// empty ones
+	padding := (archiveBlockSize - size%archiveBlockSize) % archiveBlockSize
+	trailer := make([]byte, padding+2*archiveBlockSize)
+
+	if err := compressBytes(ctx, compressor, header.Bytes(), dst); err != nil {
+		return err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read temporary file: %w", err)
+	}
+	if _, err := io.Copy(dst, spool); err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	return compressBytes(ctx, compressor, trailer, dst)
+}
+
+// archiveBlockSize is the size of a tar block
+const archiveBlockSize = 512
+
+// compressBytes writes data through the compressor to dst
+func compressBytes(ctx context.Context, compressor []string, data []byte, dst io.Writer) error {
+	comp := exec.CommandContext(ctx, compressor[0], compressor[1:]...)
+	comp.Stdin = bytes.NewReader(data)
+	comp.Stdout = dst
+	var stderr bytes.Buffer
+	comp.Stderr = &stderr
+	if err := comp.Run(); err != nil {
+		return fmt.Errorf("%s failed: %w: %s", compressor[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
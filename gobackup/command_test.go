@@ -0,0 +1,153 @@
+package gobackup
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCommandSingleFile(t *testing.T) {
+	t.Setenv("SCRATCH", t.TempDir())
+	dest := t.TempDir()
+	backup := Backup{
+		Name:            "pgall",
+		Type:            "command",
+		Destination:     dest,
+		Retain:          1,
+		Command:         "echo 'CREATE TABLE t;'",
+		CommandFileName: "all.sql",
+	}
+
+	result, err := command(context.Background(), &backup, discardLog, nil)
+	if err != nil {
+		t.Fatalf("command() failed: %v", err)
+	}
+	if !strings.HasPrefix(filepath.Base(result.ArchivePath), "pgall_") || !strings.HasSuffix(result.ArchivePath, ".sql.gz") {
+		t.Errorf("archive path = %s, want pgall_<timestamp>.sql.gz", result.ArchivePath)
+	}
+
+	f, err := os.Open(result.ArchivePath)
+	if err != nil {
+		t.Fatalf("failed to open archive: %v", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("archive is not gzip compressed: %v", err)
+	}
+	data, _ := io.ReadAll(zr)
+	if string(data) != "CREATE TABLE t;\n" {
+		t.Errorf("archive content = %q", data)
+	}
+
+	// Retention applies to the single-file backups
+	old := filepath.Join(dest, "pgall_2000.01.01_00.00.00.sql.gz")
+	os.WriteFile(old, nil, 0644)
+	if _, err := command(context.Background(), &backup, discardLog, nil); err != nil {
+		t.Fatalf("second command() failed: %v", err)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Error("old backup was not removed by retention")
+	}
+}
+
+func TestCommandFailsOnNonZeroExit(t *testing.T) {
+	scratch := t.TempDir()
+	t.Setenv("SCRATCH", scratch)
+	dest := t.TempDir()
+	backup := Backup{
+		Name:        "partial",
+		Destination: dest,
+		Retain:      1,
+		Command:     "echo half a dump; exit 2",
+	}
+
+	if _, err := command(context.Background(), &backup, discardLog, nil); err == nil {
+		t.Fatal("command() succeeded although the command exited non-zero")
+	}
+	for _, dir := range []string{dest, scratch} {
+		entries, _ := os.ReadDir(dir)
+		if len(entries) != 0 {
+			t.Errorf("%s is not empty after a failed command: %v", dir, entries)
+		}
+	}
+}
+
+func TestCommandArchive(t *testing.T) {
+	t.Setenv("SCRATCH", t.TempDir())
+	backup := Backup{
+		Name:            "etcd",
+		Destination:     t.TempDir(),
+		Retain:          1,
+		Command:         "printf snapshot",
+		CommandFileName: "etcd.db",
+		CommandArchive:  true,
+	}
+
+	result, err := command(context.Background(), &backup, discardLog, nil)
+	if err != nil {
+		t.Fatalf("command() failed: %v", err)
+	}
+	content, err := exec.Command("tar", "-xOf", result.ArchivePath, "./etcd.db").Output()
+	if err != nil {
+		t.Fatalf("archive does not contain etcd.db: %v", err)
+	}
+	if string(content) != "snapshot" {
+		t.Errorf("etcd.db content = %q, want %q", content, "snapshot")
+	}
+}
+
+func TestCommandRequiresCommand(t *testing.T) {
+	backup := Backup{Name: "empty", Destination: t.TempDir()}
+	if _, err := command(context.Background(), &backup, discardLog, nil); err == nil {
+		t.Error("command() accepted an entry without a Command")
+	}
+}
+
+func TestCommandArchiveStreams(t *testing.T) {
+	scratch := t.TempDir()
+	t.Setenv("SCRATCH", scratch)
+	for _, compression := range []string{"gzip", "xz"} {
+		backup := Backup{
+			Name:            "dump",
+			Destination:     t.TempDir(),
+			Retain:          1,
+			CompressionType: compression,
+			// Not a whole number of tar blocks, so the member is padded
+			Command:         "head -c 1300 /dev/zero | tr '\\0' x",
+			CommandFileName: "all.sql",
+			CommandArchive:  true,
+		}
+		result, err := command(context.Background(), &backup, discardLog, nil)
+		if err != nil {
+			t.Fatalf("command(%s) failed: %v", compression, err)
+		}
+		content, err := exec.Command("tar", "-xOf", result.ArchivePath, "./all.sql").Output()
+		if err != nil {
+			t.Fatalf("%s archive does not contain all.sql: %v", compression, err)
+		}
+		if string(content) != strings.Repeat("x", 1300) {
+			t.Errorf("%s all.sql has %d bytes, want 1300", compression, len(content))
+		}
+		if list, _ := exec.Command("tar", "-tf", result.ArchivePath).Output(); string(list) != "./all.sql\n" {
+			t.Errorf("%s archive lists %q, want only ./all.sql", compression, list)
+		}
+	}
+	if entries, _ := os.ReadDir(scratch); len(entries) != 0 {
+		t.Errorf("scratch is not empty: %v", entries)
+	}
+}
+
+func TestCommandValidateFileName(t *testing.T) {
+	for name, valid := range map[string]bool{"all.sql": true, "../all.sql": false, "dumps/all.sql": false, "..": false} {
+		backup := Backup{Type: "command", Command: "true", Destination: "/backups", CommandFileName: name}
+		if problems := (commandType{}).Validate(&backup); (len(problems) == 0) != valid {
+			t.Errorf("Validate() with CommandFileName %q = %v", name, problems)
+		}
+	}
+}
@@ -0,0 +1,382 @@
+package gobackup
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxDaemonSleep bounds how long the daemon sleeps between checks, so clock
+// jumps and suspend/resume are noticed reasonably quickly
+const maxDaemonSleep = time.Minute
+
+// daemon runs library entries on their Schedule until stopped
+type daemon struct {
+	libraryFile string
+	opts        RunOptions
+	jitter      time.Duration
+	statePath   string
+	base        *slog.Logger    // logger given to RunDaemon
+	reload      <-chan struct{} // reloads the library
+
+	library   map[string]Backup
+	metrics   *metricsCollector
+	progress  *progressTracker
+	listen    string
+	schedules map[string]*cronSchedule
+	next      map[string]time.Time
+	state     scheduleState
+
+	mu      sync.Mutex
+	gen     *generation  // observers and sinks of the library loaded last
+	log     *slog.Logger // base with the sinks of the library added
+	running map[string]bool
+	wg      sync.WaitGroup
+	ctx     context.Context // cancelled to stop the daemon
+	cancel  context.CancelFunc
+}
+
+// generation is what one load of the library set up for runs to report to.
+// Batches keep the generation they started with; once a reload has replaced
+// it, it is closed when the last of them finishes.
+type generation struct {
+	observers []runObserver
+	sinks     *logSinks
+	runs      int  // batches reporting to it
+	retired   bool // replaced by a reload
+}
+
+// close lets the observers deliver what they still hold, then disconnects
+// from the log sinks
+func (g *generation) close() {
+	closeObservers(g.observers)
+	g.sinks.Close()
+}
+
+// DaemonOptions configures RunDaemon
+type DaemonOptions struct {
+	LibraryFile string
+	Run         RunOptions    // limits shared by every scheduled run
+	Jitter      time.Duration // maximum random delay added before each scheduled run
+	// Logger is what the daemon and its entries log to, slog.Default() if
+	// nil. The journald and syslog sinks of the library are added to it.
+	Logger *slog.Logger
+	// Reload, if set, makes the daemon read the library again every time it
+	// receives, keeping the previous one if that fails
+	Reload <-chan struct{}
+}
+
+// RunDaemon runs the entries of the library on their Schedule until ctx is
+// cancelled, which cancels the running entries and waits for them
+func RunDaemon(ctx context.Context, opts DaemonOptions) error {
+	runOpts := opts.Run
+	// Batches due at different times run side by side within the same limits
+	runOpts.limiter = newLimiter(runOpts.Jobs)
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	d := &daemon{
+		libraryFile: opts.LibraryFile,
+		opts:        runOpts,
+		jitter:      opts.Jitter,
+		base:        logger,
+		reload:      opts.Reload,
+		progress:    newProgressTracker(),
+		statePath:   scheduleStatePath(runOpts.StateDir),
+		running:     make(map[string]bool),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	if err := d.load(time.Now()); err != nil {
+		return err
+	}
+	// The loop waits for the running batches before it returns
+	defer func() { d.gen.close() }()
+	return d.loop()
+}
+
+// load reads the library and works out when each scheduled entry runs next.
+// On failure the previously loaded library stays in effect.
+func (d *daemon) load(now time.Time) error {
+	lib, err := LoadLibrary(d.libraryFile)
+	if err != nil {
+		return err
+	}
+	library := lib.Entries
+	schedules := make(map[string]*cronSchedule)
+	for name, backup := range library {
+		if backup.Schedule == "" {
+			continue
+		}
+		sched, err := parseSchedule(backup.Schedule)
+		if err != nil {
+			return fmt.Errorf("invalid schedule for '%s': %w", name, err)
+		}
+		schedules[name] = sched
+	}
+	// Once loaded, the state is kept up to date by entryStarted
+	d.mu.Lock()
+	state := d.state
+	d.mu.Unlock()
+	if state.LastRun == nil {
+		if state, err = loadScheduleState(d.statePath); err != nil {
+			return err
+		}
+	}
+	base := d.base
+	if base == nil {
+		base = slog.Default()
+	}
+	sinks, err := openLogSinks(lib.Settings.Logging, base)
+	if err != nil {
+		return fmt.Errorf("invalid library settings: %w", err)
+	}
+	log := sinks.logger(base)
+	observers, err := newObservers(lib.Settings, library, d.opts.StateDir, log)
+	if err != nil {
+		sinks.Close()
+		return fmt.Errorf("invalid library settings: %w", err)
+	}
+
+	d.library = library
+	d.mu.Lock()
+	// Runs in flight keep reporting to the generation they started with,
+	// which is closed once they have finished
+	if old := d.gen; old != nil {
+		old.retired = true
+		if old.runs == 0 {
+			go old.close()
+		}
+	}
+	d.gen = &generation{observers: observers, sinks: sinks}
+	d.log = log
+	d.state = state
+	next := make(map[string]time.Time, len(schedules))
+	for name, sched := range schedules {
+		next[name] = initialNext(sched, state.LastRun[name], now)
+	}
+	d.metrics = nil
+	for _, observer := range observers {
+		if m, ok := observer.(*metricsCollector); ok {
+			d.metrics = m
+		}
+	}
+	d.mu.Unlock()
+	if err := d.serveMetrics(lib.Settings.Metrics); err != nil {
+		log.Warn(err.Error())
+	}
+	d.schedules = schedules
+	d.next = next
+
+	log.Info("Loaded library", "library", d.libraryFile, "scheduled", len(schedules))
+	for _, name := range sortedKeys(next) {
+		log.Info("Scheduled entry", "entry", name, "schedule", library[name].Schedule, "next", next[name].Format(time.RFC3339))
+	}
+	return nil
+}
+
+// serveMetrics starts serving /metrics, and the progress of running entries
+// on /progress and /events, the first time a Listen address is configured.
+// The address cannot be changed by a reload.
+func (d *daemon) serveMetrics(cfg *MetricsSettings) error {
+	if cfg == nil || cfg.Listen == "" || cfg.Listen == d.listen {
+		return nil
+	}
+	if d.listen != "" {
+		return fmt.Errorf("restart the daemon to move /metrics from %s to %s", d.listen, cfg.Listen)
+	}
+	ln, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return fmt.Errorf("unable to serve metrics: %w", err)
+	}
+	d.listen = cfg.Listen
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		metrics := d.metrics
+		d.mu.Unlock()
+		if metrics == nil {
+			http.NotFound(w, r)
+			return
+		}
+		metrics.ServeHTTP(w, r)
+	})
+	if d.progress != nil {
+		mux.HandleFunc("/progress", d.progress.serveProgress)
+		mux.HandleFunc("/events", d.progress.serveEvents)
+	}
+	d.log.Info("Serving metrics", "url", fmt.Sprintf("http://%s/metrics", ln.Addr()))
+	go http.Serve(ln, mux)
+	return nil
+}
+
+// initialNext returns when an entry should next run. If a run was due while
+// the daemon was not running, the entry runs once straight away.
+func initialNext(sched *cronSchedule, last, now time.Time) time.Time {
+	if last.IsZero() {
+		return sched.Next(now)
+	}
+	next := sched.Next(last)
+	if !next.IsZero() && !next.After(now) {
+		return now
+	}
+	return next
+}
+
+// loop fires due entries until the daemon is stopped, which cancels the
+// running ones, reloading the library when asked to
+func (d *daemon) loop() error {
+	for {
+		now := time.Now()
+		var due []string
+		for name, at := range d.next {
+			if !at.IsZero() && !at.After(now) {
+				due = append(due, name)
+				d.next[name] = d.schedules[name].Next(now)
+			}
+		}
+		if len(due) > 0 {
+			d.start(due)
+		}
+
+		sleep := maxDaemonSleep
+		for _, at := range d.next {
+			if !at.IsZero() && time.Until(at) < sleep {
+				sleep = time.Until(at)
+			}
+		}
+		timer := time.NewTimer(sleep)
+		select {
+		case <-timer.C:
+		case <-d.reload:
+			timer.Stop()
+			d.log.Info("Reloading library")
+			if err := d.load(time.Now()); err != nil {
+				d.log.Error("Reload failed, keeping previous library", "error", err)
+			}
+		case <-d.ctx.Done():
+			timer.Stop()
+			d.log.Info("Stopping, cancelling running backups")
+			d.wg.Wait()
+			return nil
+		}
+	}
+}
+
+// start runs a batch of due entries in the background after a random jitter.
+// Entries still running from an earlier activation are left alone.
+func (d *daemon) start(due []string) {
+	d.mu.Lock()
+	log, gen := d.log, d.gen
+	var batch []string
+	for _, name := range due {
+		if d.running[name] {
+			log.Warn("Skipping scheduled run: previous run still in progress", "entry", name)
+			continue
+		}
+		d.running[name] = true
+		batch = append(batch, name)
+	}
+	if len(batch) == 0 {
+		d.mu.Unlock()
+		return
+	}
+	var observers []runObserver
+	if gen != nil {
+		gen.runs++
+		observers = gen.observers
+	}
+	d.mu.Unlock()
+
+	// In-flight runs keep the library they were started with across reloads
+	library := d.library
+	opts := d.opts
+	opts.logger = log
+	opts.observers = append(slices.Clip(observers), runObserver(d))
+	if d.progress != nil {
+		opts.observers = append(opts.observers, d.progress)
+	}
+	opts.runID = newRunID()
+	batch = orderEntries(batch, library)
+	var delay time.Duration
+	if d.jitter > 0 {
+		delay = rand.N(d.jitter)
+	}
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer func() {
+			d.mu.Lock()
+			for _, name := range batch {
+				delete(d.running, name)
+			}
+			retire := false
+			if gen != nil {
+				gen.runs--
+				retire = gen.retired && gen.runs == 0
+			}
+			d.mu.Unlock()
+			if retire {
+				gen.close()
+			}
+		}()
+
+		if delay > 0 {
+			log.Info("Starting scheduled entries after jitter", "run_id", opts.runID, "entries", batch, "delay", delay.Round(time.Second).String())
+			select {
+			case <-time.After(delay):
+			case <-d.ctx.Done():
+				return
+			}
+		}
+		started := time.Now()
+		outcomes := runParallel(d.ctx, batch, library, opts)
+		opts.runFinished(newRunReport(started, batch, outcomes))
+		for _, name := range batch {
+			result := outcomes[name]
+			if result.err != nil {
+				log.Error("Scheduled run "+result.status, "run_id", opts.runID, "entry", name, "error", result.err)
+			} else {
+				log.Info("Scheduled run "+result.status, "run_id", opts.runID, "entry", name)
+			}
+		}
+	}()
+}
+
+// entryStarted records when a scheduled entry actually started, after its
+// jitter and any wait for a slot. A run lost to a stop or reload before then
+// is caught up the next time the library is loaded.
+func (d *daemon) entryStarted(backup Backup) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.state.LastRun[backup.Name] = time.Now()
+	if err := d.state.save(d.statePath); err != nil {
+		d.log.Warn("Failed to save schedule state", "error", err)
+	}
+}
+
+func (d *daemon) entryFinished(result outcome) {}
+
+func (d *daemon) runFinished(report runReport) {}
+
+// sortedKeys returns the keys of a map in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,202 @@
+package gobackup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInitialNext(t *testing.T) {
+	sched, err := parseSchedule("@daily")
+	if err != nil {
+		t.Fatalf("parseSchedule failed: %v", err)
+	}
+	now := time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC)
+
+	// Never run before: wait for the next activation
+	if got := initialNext(sched, time.Time{}, now); !got.Equal(time.Date(2024, 1, 11, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("initialNext() without history = %v", got)
+	}
+
+	// Ran today already: nothing to catch up
+	last := time.Date(2024, 1, 10, 0, 0, 5, 0, time.UTC)
+	if got := initialNext(sched, last, now); !got.Equal(time.Date(2024, 1, 11, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("initialNext() after today's run = %v", got)
+	}
+
+	// Machine was off over midnight: run once now
+	last = time.Date(2024, 1, 8, 0, 0, 5, 0, time.UTC)
+	if got := initialNext(sched, last, now); !got.Equal(now) {
+		t.Errorf("initialNext() with missed run = %v, want %v", got, now)
+	}
+}
+
+func TestDaemonLoad(t *testing.T) {
+	dir := t.TempDir()
+	libraryFile := filepath.Join(dir, "library.json")
+	library := `{
+		"nightly": {"Source": "/src", "Destination": "/dest", "Retain": 3, "Type": "tar", "Schedule": "30 2 * * *"},
+		"manual": {"Source": "/src", "Destination": "/dest", "Retain": 3, "Type": "tar"}
+	}`
+	if err := os.WriteFile(libraryFile, []byte(library), 0644); err != nil {
+		t.Fatalf("failed to write library: %v", err)
+	}
+
+	statePath := filepath.Join(dir, "state", "schedule.json")
+	lastRun := time.Now().Add(-48 * time.Hour)
+	state := scheduleState{LastRun: map[string]time.Time{"nightly": lastRun}}
+	if err := state.save(statePath); err != nil {
+		t.Fatalf("failed to save state: %v", err)
+	}
+
+	d := &daemon{libraryFile: libraryFile, statePath: statePath, base: discardLog}
+	now := time.Now()
+	if err := d.load(now); err != nil {
+		t.Fatalf("load() failed: %v", err)
+	}
+	if len(d.next) != 1 {
+		t.Fatalf("scheduled entries = %d, want 1", len(d.next))
+	}
+	if !d.next["nightly"].Equal(now) {
+		t.Errorf("nightly next = %v, want immediate catch-up at %v", d.next["nightly"], now)
+	}
+	if !d.state.LastRun["nightly"].Equal(lastRun) {
+		t.Errorf("LastRun = %v, want %v", d.state.LastRun["nightly"], lastRun)
+	}
+
+	// A broken schedule on reload keeps the previous library
+	broken := `{"nightly": {"Type": "tar", "Schedule": "every night"}}`
+	if err := os.WriteFile(libraryFile, []byte(broken), 0644); err != nil {
+		t.Fatalf("failed to write library: %v", err)
+	}
+	if err := d.load(now); err == nil {
+		t.Error("load() accepted an invalid schedule")
+	}
+	if _, ok := d.library["manual"]; !ok {
+		t.Error("failed reload replaced the previous library")
+	}
+}
+
+func TestDaemonBatchesShareLimits(t *testing.T) {
+	t.Setenv("GOBACKUP_STATE", t.TempDir())
+	t.Setenv("SCRATCH", t.TempDir())
+	logFile := filepath.Join(t.TempDir(), "runs.log")
+	entry := func(name string) Backup {
+		return Backup{Type: "command", Destination: t.TempDir(), Retain: 1,
+			Command: fmt.Sprintf("echo start >> %s; sleep 0.2; echo end >> %s; printf %s", logFile, logFile, name)}
+	}
+	opts := DefaultRunOptions()
+	opts.limiter = newLimiter(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := &daemon{
+		opts:      opts,
+		log:       discardLog,
+		library:   map[string]Backup{"first": entry("first"), "second": entry("second")},
+		statePath: filepath.Join(t.TempDir(), "schedule.json"),
+		state:     scheduleState{LastRun: make(map[string]time.Time)},
+		running:   make(map[string]bool),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+
+	before := time.Now()
+	d.start([]string{"first"})
+	d.start([]string{"second"})
+	d.wg.Wait()
+
+	got := strings.Join(readHookLog(t, logFile), " ")
+	if got != "start end start end" {
+		t.Errorf("runs = %q, want the second batch to wait for the first", got)
+	}
+	for _, name := range []string{"first", "second"} {
+		if !d.state.LastRun[name].After(before) {
+			t.Errorf("LastRun[%s] = %v, want the time it started", name, d.state.LastRun[name])
+		}
+	}
+}
+
+func TestDaemonStopDuringJitterKeepsLastRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	lastRun := time.Now().Add(-48 * time.Hour)
+	d := &daemon{
+		opts:      DefaultRunOptions(),
+		log:       discardLog,
+		jitter:    time.Hour,
+		library:   map[string]Backup{"nightly": {Type: "command", Command: "true", Destination: t.TempDir()}},
+		statePath: filepath.Join(t.TempDir(), "schedule.json"),
+		state:     scheduleState{LastRun: map[string]time.Time{"nightly": lastRun}},
+		running:   make(map[string]bool),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	d.start([]string{"nightly"})
+	cancel()
+	d.wg.Wait()
+	if !d.state.LastRun["nightly"].Equal(lastRun) {
+		t.Errorf("LastRun = %v, want the run lost during jitter to be caught up", d.state.LastRun["nightly"])
+	}
+}
+
+// closeRecorder is a run observer that records being closed
+type closeRecorder struct {
+	closed atomic.Bool
+}
+
+func (r *closeRecorder) entryStarted(backup Backup)   {}
+func (r *closeRecorder) entryFinished(result outcome) {}
+func (r *closeRecorder) runFinished(report runReport) {}
+func (r *closeRecorder) close()                       { r.closed.Store(true) }
+
+func TestDaemonReloadClosesObserversAfterRunsFinish(t *testing.T) {
+	t.Setenv("GOBACKUP_STATE", t.TempDir())
+	t.Setenv("SCRATCH", t.TempDir())
+	libraryFile := writeLibrary(t, fmt.Sprintf(`{"slow": {"Type": "command", "Command": "sleep 0.3", "Destination": %q, "Retain": 1}}`, t.TempDir()))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := &daemon{
+		libraryFile: libraryFile,
+		opts:        DefaultRunOptions(),
+		statePath:   filepath.Join(t.TempDir(), "schedule.json"),
+		base:        discardLog,
+		running:     make(map[string]bool),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	if err := d.load(time.Now()); err != nil {
+		t.Fatalf("load() failed: %v", err)
+	}
+	first := &closeRecorder{}
+	d.gen.observers = append(d.gen.observers, first)
+
+	d.start([]string{"slow"})
+	if err := d.load(time.Now()); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if first.closed.Load() {
+		t.Error("reload closed the observers of a running batch")
+	}
+	d.wg.Wait()
+	if !first.closed.Load() {
+		t.Error("observers replaced by a reload not closed after their batch finished")
+	}
+
+	// Without a batch running the replaced observers are closed straight away
+	second := &closeRecorder{}
+	d.gen.observers = append(d.gen.observers, second)
+	if err := d.load(time.Now()); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); !second.closed.Load() && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !second.closed.Load() {
+		t.Error("idle observers not closed on reload")
+	}
+	d.gen.close()
+}
@@ -0,0 +1,131 @@
+package gobackup
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// validateDependencies checks that every DependsOn refers to an entry in the
+// library and that the dependency graph has no cycles
+func validateDependencies(library map[string]Backup) error {
+	names := make([]string, 0, len(library))
+	for name := range library {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, dep := range library[name].DependsOn {
+			if _, exists := library[dep]; !exists {
+				return fmt.Errorf("entry '%s' depends on unknown entry '%s'", name, dep)
+			}
+			if dep == name {
+				return fmt.Errorf("entry '%s' depends on itself", name)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(library))
+	var path []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			// Report the cycle starting from the first occurrence of name
+			start := 0
+			for i, p := range path {
+				if p == name {
+					start = i
+					break
+				}
+			}
+			cycle := append(append([]string{}, path[start:]...), name)
+			return fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range library[name].DependsOn {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectedDependencies returns the prerequisites of entry that are part of
+// this run. Prerequisites that were not selected are assumed to be handled
+// by another run and do not block the entry.
+func selectedDependencies(entry string, library map[string]Backup, selected map[string]bool) []string {
+	var deps []string
+	for _, dep := range library[entry].DependsOn {
+		if selected[dep] {
+			deps = append(deps, dep)
+		}
+	}
+	return deps
+}
+
+// orderEntries removes duplicates from entries and sorts them so every entry
+// comes after its selected prerequisites, otherwise keeping the order given.
+// The library must already have passed validateDependencies.
+func orderEntries(entries []string, library map[string]Backup) []string {
+	selected := make(map[string]bool, len(entries))
+	var unique []string
+	for _, entry := range entries {
+		if !selected[entry] {
+			selected[entry] = true
+			unique = append(unique, entry)
+		}
+	}
+
+	ordered := make([]string, 0, len(unique))
+	placed := make(map[string]bool, len(unique))
+	for len(ordered) < len(unique) {
+		progress := false
+		for _, entry := range unique {
+			if placed[entry] {
+				continue
+			}
+			ready := true
+			for _, dep := range selectedDependencies(entry, library, selected) {
+				if !placed[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				ordered = append(ordered, entry)
+				placed[entry] = true
+				progress = true
+				break
+			}
+		}
+		if !progress {
+			// Only reachable with a cyclic library; keep the remaining order
+			for _, entry := range unique {
+				if !placed[entry] {
+					ordered = append(ordered, entry)
+					placed[entry] = true
+				}
+			}
+		}
+	}
+	return ordered
+}
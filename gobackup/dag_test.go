@@ -0,0 +1,112 @@
+package gobackup
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestValidateDependencies(t *testing.T) {
+	tests := []struct {
+		name    string
+		library map[string]Backup
+		wantErr string
+	}{
+		{
+			name: "valid chain",
+			library: map[string]Backup{
+				"dump":    {},
+				"archive": {DependsOn: []string{"dump"}},
+				"mirror":  {DependsOn: []string{"archive", "dump"}},
+			},
+		},
+		{
+			name: "unknown dependency",
+			library: map[string]Backup{
+				"archive": {DependsOn: []string{"dump"}},
+			},
+			wantErr: "unknown entry 'dump'",
+		},
+		{
+			name: "self dependency",
+			library: map[string]Backup{
+				"archive": {DependsOn: []string{"archive"}},
+			},
+			wantErr: "depends on itself",
+		},
+		{
+			name: "cycle",
+			library: map[string]Backup{
+				"a": {DependsOn: []string{"b"}},
+				"b": {DependsOn: []string{"c"}},
+				"c": {DependsOn: []string{"a"}},
+			},
+			wantErr: "dependency cycle: a -> b -> c -> a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateDependencies(tt.library)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOrderEntries(t *testing.T) {
+	library := map[string]Backup{
+		"dump":    {},
+		"archive": {DependsOn: []string{"dump"}},
+		"mirror":  {},
+		"vault":   {DependsOn: []string{"mirror"}},
+	}
+
+	ordered := orderEntries([]string{"archive", "vault", "mirror", "dump", "archive"}, library)
+	expected := []string{"mirror", "vault", "dump", "archive"}
+	if strings.Join(ordered, ",") != strings.Join(expected, ",") {
+		t.Errorf("orderEntries() = %v, want %v", ordered, expected)
+	}
+
+	// Prerequisites outside the selection do not reorder anything
+	ordered = orderEntries([]string{"archive"}, library)
+	if len(ordered) != 1 || ordered[0] != "archive" {
+		t.Errorf("orderEntries() = %v, want [archive]", ordered)
+	}
+}
+
+func TestDependentsSkippedWhenPrerequisiteFails(t *testing.T) {
+	t.Setenv("SCRATCH", t.TempDir())
+	dest := t.TempDir()
+	library := map[string]Backup{
+		"dump":    {Type: "tar", Source: t.TempDir(), Destination: "/nonexistent/gobackup", Retain: 1, ChangeDir: true},
+		"archive": {Type: "tar", Source: t.TempDir(), Destination: dest, Retain: 1, ChangeDir: true, DependsOn: []string{"dump"}},
+		"other":   {Type: "tar", Source: t.TempDir(), Destination: dest, Retain: 1, ChangeDir: true},
+	}
+	entries := orderEntries([]string{"archive", "dump", "other"}, library)
+
+	for _, jobs := range []int{1, 3} {
+		var outcomes map[string]outcome
+		if jobs == 1 {
+			outcomes = runSequential(context.Background(), entries, library, DefaultRunOptions())
+		} else {
+			outcomes = runParallel(context.Background(), entries, library, RunOptions{Jobs: jobs})
+		}
+		if outcomes["dump"].status != StatusFailed {
+			t.Errorf("jobs=%d: dump status = %s, want %s", jobs, outcomes["dump"].status, StatusFailed)
+		}
+		if outcomes["archive"].status != StatusSkipped {
+			t.Errorf("jobs=%d: archive status = %s, want %s", jobs, outcomes["archive"].status, StatusSkipped)
+		}
+		if outcomes["other"].status != StatusSucceeded {
+			t.Errorf("jobs=%d: other status = %s (%v), want %s", jobs, outcomes["other"].status, outcomes["other"].err, StatusSucceeded)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package gobackup
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Plan is what running one entry would do, worked out without writing
+// anything. BackupType implementations fill it in.
+type Plan struct {
+	Entry    string
+	Type     string
+	Time     time.Time // when the run would start, which names the archive
+	Steps    []string  // commands in the order they would run
+	Archive  string    // where the archive would be stored
+	Listed   bool      // whether the files to back up could be listed
+	Files    []PlannedFile
+	Excluded int // paths skipped by Excludes, -1 if unknown
+	Size     int64
+	Removed  []string // snapshots retention would delete
+	Problems []string // what would make the real run fail
+}
+
+// PlannedFile is a file that would be backed up, relative to the source
+type PlannedFile struct {
+	Path string
+	Size int64
+}
+
+// dryRun prints the plan of every entry in run order. It fails if the plan
+// of any entry shows a problem the real run would hit.
+func dryRun(w io.Writer, entries []string, library map[string]Backup) error {
+	now := time.Now()
+	var failing []string
+	for i, entry := range entries {
+		var plan *Plan
+		backup, ok := library[entry]
+		if ok {
+			backup.Name = entry
+			plan = planEntry(&backup, now)
+		} else {
+			plan = &Plan{Entry: entry, Excluded: -1, Problems: []string{fmt.Sprintf("no backup found with name '%s'", entry)}}
+		}
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		writePlan(w, plan)
+		if len(plan.Problems) > 0 {
+			failing = append(failing, entry)
+		}
+	}
+	if len(failing) > 0 {
+		return fmt.Errorf("dry run: %d of %d entries would fail: %s", len(failing), len(entries), strings.Join(failing, ", "))
+	}
+	return nil
+}
+
+// planEntry works out what runEntry would do for backup
+func planEntry(backup *Backup, now time.Time) *Plan {
+	plan := &Plan{Entry: backup.Name, Type: backup.Type, Time: now, Excluded: -1}
+	plan.hooks("pre-hook", backup.PreHooks)
+
+	if t, err := backupTypeFor(backup.Type); err != nil {
+		plan.Problem(err)
+	} else {
+		t.Plan(backup, plan)
+	}
+
+	plan.hooks("post-hook", backup.PostHooks)
+	plan.hooks("on-success hook", backup.OnSuccess)
+	plan.hooks("on-failure hook", backup.OnFailure)
+	return plan
+}
+
+// Problem records something that would make the real run fail
+func (p *Plan) Problem(err error) {
+	p.Problems = append(p.Problems, err.Error())
+}
+
+func (p *Plan) hooks(kind string, commands []string) {
+	for _, command := range commands {
+		p.Steps = append(p.Steps, kind+": "+command)
+	}
+}
+
+// timestamp is how the archive of the planned run would be named
+func (p *Plan) timestamp() string {
+	return p.Time.Format("2006.01.02_15.04.05")
+}
+
+// Tar plans archiving the directory backup.Source into Destination like the
+// tar type does, for types that end by archiving a directory
+func (p *Plan) Tar(backup *Backup) {
+	tarFlags, fileExtension, err := tarCompression(backup)
+	if err != nil {
+		p.Problem(err)
+		return
+	}
+	temp := filepath.Join(GetEnv("SCRATCH", "/tmp"), fmt.Sprintf("gobackup_%s_%s_*.%s", backup.Name, p.timestamp(), fileExtension))
+	p.Steps = append(p.Steps, tarCommand(backup, tarFlags, temp))
+	p.Store(backup, fileExtension)
+}
+
+// command plans storing the output of backup.Command like command() would
+func (p *Plan) command(backup *Backup) {
+	if strings.TrimSpace(backup.Command) == "" {
+		p.Problem(fmt.Errorf("command backup requires a Command"))
+		return
+	}
+	if backup.CommandArchive {
+		compressor, fileExtension, err := commandArchiveCompression(backup)
+		if err != nil {
+			p.Problem(err)
+			return
+		}
+		scratch := GetEnv("SCRATCH", "/tmp")
+		spool := filepath.Join(scratch, fmt.Sprintf("gobackup_%s_%s_*.out", backup.Name, p.timestamp()))
+		temp := filepath.Join(scratch, fmt.Sprintf("gobackup_%s_%s_*.%s", backup.Name, p.timestamp(), fileExtension))
+		p.Steps = append(p.Steps,
+			fmt.Sprintf("%s | %s > %s", backup.Command, strings.Join(compressor, " "), shellQuote(spool)),
+			fmt.Sprintf("archive %s as ./%s into %s", shellQuote(spool), commandFileName(backup), shellQuote(temp)))
+		p.Store(backup, fileExtension)
+		return
+	}
+	compressor, fileExtension, err := commandCompression(backup)
+	if err != nil {
+		p.Problem(err)
+		return
+	}
+	temp := filepath.Join(GetEnv("SCRATCH", "/tmp"), fmt.Sprintf("gobackup_%s_%s_*.%s", backup.Name, p.timestamp(), fileExtension))
+	p.Steps = append(p.Steps, fmt.Sprintf("%s | %s > %s", backup.Command, strings.Join(compressor, " "), shellQuote(temp)))
+	p.Store(backup, fileExtension)
+}
+
+// Store plans moving an archive with fileExtension into Destination and
+// applying retention, for types that write the archive themselves
+func (p *Plan) Store(backup *Backup, fileExtension string) {
+	p.Archive = filepath.Join(backup.Destination, fmt.Sprintf("%s_%s.%s", backup.Name, p.timestamp(), fileExtension))
+	if err := checkDestination(backup.Destination); err != nil {
+		p.Problem(err)
+		return
+	}
+	files, err := filepath.Glob(filepath.Join(backup.Destination, backup.Name+"_*."+fileExtension))
+	if err != nil {
+		p.Problem(err)
+		return
+	}
+	p.Removed = retentionVictims(append(files, p.Archive), backup.Retain)
+}
+
+// ListLocal lists the files under backup.Source that Tar would include
+func (p *Plan) ListLocal(backup *Backup) {
+	p.Excluded = 0
+	err := filepath.WalkDir(backup.Source, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(backup.Source, file)
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if excludedPath(rel, backup.Excludes) {
+			p.Excluded++
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		f := PlannedFile{Path: rel}
+		if info, err := d.Info(); err == nil && info.Mode().IsRegular() {
+			f.Size = info.Size()
+		}
+		p.Files = append(p.Files, f)
+		p.Size += f.Size
+		return nil
+	})
+	if err != nil {
+		p.Problem(fmt.Errorf("unable to list source: %w", err))
+		return
+	}
+	p.Listed = true
+}
+
+// excludedPath reports whether an Excludes pattern matches rel. Like GNU
+// tar's default, patterns are unanchored: they may match the whole path or
+// any trailing part of it starting at a directory boundary.
+func excludedPath(rel string, patterns []string) bool {
+	parts := strings.Split(rel, "/")
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(strings.TrimPrefix(pattern, "./"), "/")
+		for i := range parts {
+			if ok, _ := path.Match(pattern, strings.Join(parts[i:], "/")); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rsyncListLine matches a line of `rsync --list-only` output:
+// permissions, size, date, time and name
+var rsyncListLine = regexp.MustCompile(`^(\S+)\s+([\d,]+)\s+\S+\s+\S+\s(.+)$`)
+
+// listRsync lists the files rsync would pull, asking rsync itself so its
+// exclude rules apply exactly. Nothing is transferred.
+func (p *Plan) listRsync(backup *Backup) {
+	cmdString := fmt.Sprintf("rsync -r --list-only --no-human-readable%s -e %s %s",
+		excludeFlags(backup),
+		shellQuote("ssh "+strings.Join(sshOptions, " ")),
+		shellQuote(backup.Source),
+	)
+	// The listing of a large tree is parsed as it streams rather than
+	// held in memory whole
+	stderr := newTailBuffer(outputTailLines)
+	stdout := newLineWriter(func(line string) {
+		m := rsyncListLine.FindStringSubmatch(line)
+		if m == nil || strings.HasPrefix(m[1], "d") {
+			return
+		}
+		name := m[3]
+		if strings.HasPrefix(m[1], "l") {
+			name, _, _ = strings.Cut(name, " -> ")
+		}
+		size, _ := strconv.ParseInt(strings.ReplaceAll(m[2], ",", ""), 10, 64)
+		p.Files = append(p.Files, PlannedFile{Path: name, Size: size})
+		p.Size += size
+	})
+	cmd := exec.Command("sh", "-c", cmdString)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	stdout.Flush()
+	if err != nil {
+		p.Problem(fmt.Errorf("unable to list source: %w: %s", err, strings.TrimSpace(stderr.String())))
+		return
+	}
+	p.Listed = true
+}
+
+// writePlan prints a plan for people to read
+func writePlan(w io.Writer, p *Plan) {
+	fmt.Fprintf(w, "%s (%s)\n", p.Entry, p.Type)
+	for _, step := range p.Steps {
+		fmt.Fprintf(w, "  would run: %s\n", step)
+	}
+	if p.Archive != "" {
+		fmt.Fprintf(w, "  archive:   %s\n", p.Archive)
+	}
+	if p.Listed {
+		excluded := ""
+		if p.Excluded >= 0 {
+			excluded = fmt.Sprintf(", %d excluded", p.Excluded)
+		}
+		fmt.Fprintf(w, "  files:     %d included%s, about %s before compression\n", len(p.Files), excluded, formatSize(p.Size))
+		for _, f := range p.Files {
+			fmt.Fprintf(w, "    %s (%s)\n", f.Path, formatSize(f.Size))
+		}
+	}
+	if len(p.Removed) == 0 && p.Archive != "" && len(p.Problems) == 0 {
+		fmt.Fprintln(w, "  retention: nothing to delete")
+	}
+	for _, removed := range p.Removed {
+		fmt.Fprintf(w, "  retention: would delete %s\n", removed)
+	}
+	for _, problem := range p.Problems {
+		fmt.Fprintf(w, "  problem:   %s\n", problem)
+	}
+}
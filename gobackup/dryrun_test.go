@@ -0,0 +1,152 @@
+package gobackup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// dirNames lists the names in dir
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", dir, err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestDryRunTar(t *testing.T) {
+	scratch := t.TempDir()
+	t.Setenv("SCRATCH", scratch)
+	source, dest := t.TempDir(), t.TempDir()
+	os.MkdirAll(filepath.Join(source, "sub", "cache"), 0755)
+	os.WriteFile(filepath.Join(source, "a.txt"), make([]byte, 1536), 0644)
+	os.WriteFile(filepath.Join(source, "sub", "b.txt"), make([]byte, 512), 0644)
+	os.WriteFile(filepath.Join(source, "sub", "debug.log"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(source, "sub", "cache", "blob"), []byte("x"), 0644)
+	for _, name := range []string{"photos_2024.01.01_00.00.00.tar.gz", "photos_2024.01.02_00.00.00.tar.gz"} {
+		os.WriteFile(filepath.Join(dest, name), nil, 0644)
+	}
+	library := map[string]Backup{
+		"photos": {Type: "tar", Source: source, Destination: dest, Retain: 2, ChangeDir: true,
+			Excludes: []string{"*.log", "cache/"}, PreHooks: []string{"sync"}},
+	}
+
+	var out strings.Builder
+	if err := dryRun(&out, []string{"photos"}, library); err != nil {
+		t.Fatalf("dryRun() failed: %v", err)
+	}
+	output := out.String()
+	for _, want := range []string{
+		"would run: pre-hook: sync",
+		"would run: tar --exclude='*.log' --exclude='cache/' -cf '" + filepath.Join(scratch, "gobackup_photos_"),
+		"-C " + source + " .",
+		"archive:   " + filepath.Join(dest, "photos_"),
+		"files:     2 included, 2 excluded, about 2.0 KiB before compression",
+		"    a.txt (1.5 KiB)",
+		"    sub/b.txt (512 B)",
+		"retention: would delete " + filepath.Join(dest, "photos_2024.01.01_00.00.00.tar.gz"),
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output does not contain %q:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, "debug.log") || strings.Contains(output, "blob") {
+		t.Errorf("excluded files listed:\n%s", output)
+	}
+	if names := dirNames(t, scratch); len(names) != 0 {
+		t.Errorf("dry run wrote to scratch: %v", names)
+	}
+	if names := dirNames(t, dest); len(names) != 2 {
+		t.Errorf("dry run changed the destination: %v", names)
+	}
+}
+
+func TestDryRunRsyncListsWithoutPulling(t *testing.T) {
+	logFile := fakeSSH(t)
+	scratch := t.TempDir()
+	t.Setenv("SCRATCH", scratch)
+	dir := t.TempDir()
+	script := "#!/bin/sh\necho \"$@\" >> " + logFile + "\n" +
+		"echo 'drwxr-xr-x          4,096 2024/01/01 12:00:00 data'\n" +
+		"echo '-rw-r--r--      2,097,152 2024/01/01 12:00:00 data/a file.bin'\n" +
+		"echo 'lrwxrwxrwx             10 2024/01/01 12:00:00 data/link -> a file.bin'\n"
+	os.WriteFile(filepath.Join(dir, "rsync"), []byte(script), 0755)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	library := map[string]Backup{
+		"torado": {Type: "rsync", Source: "user@nas:/data", Destination: t.TempDir(), Retain: 3,
+			Excludes: []string{"*.tmp"}, RemotePreCommands: []string{"pg_dump db > /data/db.sql"}},
+	}
+
+	var out strings.Builder
+	if err := dryRun(&out, []string{"torado"}, library); err != nil {
+		t.Fatalf("dryRun() failed: %v", err)
+	}
+	output := out.String()
+	for _, want := range []string{
+		"would run: ssh user@nas: pg_dump db > /data/db.sql",
+		"would run: rsync --exclude='*.tmp' -rahz --delete",
+		"files:     2 included, about 2.0 MiB before compression",
+		"    data/a file.bin (2.0 MiB)",
+		"    data/link (10 B)",
+		"retention: nothing to delete",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output does not contain %q:\n%s", want, output)
+		}
+	}
+	data, _ := os.ReadFile(logFile)
+	if calls := strings.TrimSpace(string(data)); !strings.Contains(calls, "--list-only") || strings.Contains(calls, "pg_dump") {
+		t.Errorf("dry run ran more than a listing: %s", calls)
+	}
+	if names := dirNames(t, scratch); len(names) != 0 {
+		t.Errorf("dry run wrote to scratch: %v", names)
+	}
+}
+
+func TestDryRunReportsProblems(t *testing.T) {
+	library := map[string]Backup{
+		"photos": {Type: "tar", Source: t.TempDir(), Destination: "/nonexistent/gobackup", ChangeDir: true},
+		"odd":    {Type: "zip"},
+	}
+	var out strings.Builder
+	err := dryRun(&out, []string{"photos", "odd", "missing"}, library)
+	if err == nil || !strings.Contains(err.Error(), "3 of 3 entries would fail") {
+		t.Errorf("dryRun() error = %v", err)
+	}
+	for _, want := range []string{
+		"problem:   destination directory does not exist: /nonexistent/gobackup",
+		`problem:   unknown Type "zip"`,
+		"problem:   no backup found with name 'missing'",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestExcludedPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		patterns []string
+		expected bool
+	}{
+		{"a.log", []string{"*.log"}, true},
+		{"sub/a.log", []string{"*.log"}, true},
+		{"sub/cache", []string{"cache/"}, true},
+		{"sub/cache/x", []string{"sub/cache"}, false},
+		{"sub/cache", []string{"./sub/cache"}, true},
+		{"sub/a.txt", []string{"*.log", "tmp"}, false},
+	}
+	for _, tt := range tests {
+		if got := excludedPath(tt.path, tt.patterns); got != tt.expected {
+			t.Errorf("excludedPath(%q, %v) = %v, want %v", tt.path, tt.patterns, got, tt.expected)
+		}
+	}
+}
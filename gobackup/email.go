@@ -0,0 +1,198 @@
+package gobackup
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	smtpStartTLS = "starttls"
+	smtpTLS      = "tls"
+	smtpNoTLS    = "none"
+)
+
+// smtpAddress works out the server host, dial address and TLS mode of an
+// email target. URL is smtp://host[:port] or smtps://host[:port]; smtps
+// implies implicit TLS unless TLS says otherwise.
+func smtpAddress(rawURL, mode string) (host, addr, tlsMode string, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid URL: %w", err)
+	}
+	switch u.Scheme {
+	case "smtp":
+		tlsMode = smtpStartTLS
+	case "smtps":
+		tlsMode = smtpTLS
+	default:
+		return "", "", "", fmt.Errorf("invalid URL %q (expected smtp://host:port or smtps://host:port)", rawURL)
+	}
+	if mode != "" {
+		tlsMode = mode
+	}
+	port := map[string]string{smtpStartTLS: "587", smtpTLS: "465", smtpNoTLS: "25"}[tlsMode]
+	if port == "" {
+		return "", "", "", fmt.Errorf("invalid TLS %q (supported: %s, %s, %s)", mode, smtpStartTLS, smtpTLS, smtpNoTLS)
+	}
+	host = u.Hostname()
+	if host == "" {
+		return "", "", "", fmt.Errorf("invalid URL %q: missing host", rawURL)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	return host, net.JoinHostPort(host, port), tlsMode, nil
+}
+
+// sendEmail delivers a plain text message to the target's recipients,
+// dropping the connection once ctx is cancelled
+func sendEmail(ctx context.Context, target notifyTarget, subject, body string) error {
+	host, addr, tlsMode, err := smtpAddress(target.URL, target.TLS)
+	if err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+	from := target.From
+	if from == "" {
+		from = "gobackup@" + hostname
+	}
+	message, err := emailMessage(from, target.To, subject, body)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig := &tls.Config{ServerName: host}
+	dialer := &net.Dialer{Timeout: notifyTimeout}
+	var conn net.Conn
+	if tlsMode == smtpTLS {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: tlsConfig}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(notifyTimeout))
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if hostname != "" {
+		if err := c.Hello(hostname); err != nil {
+			return err
+		}
+	}
+	if tlsMode == smtpStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s does not support STARTTLS (set TLS to \"tls\" or \"none\")", addr)
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if target.Username != "" {
+		password, err := resolveSecret(target.Password)
+		if err != nil {
+			return err
+		}
+		if err := c.Auth(smtp.PlainAuth("", target.Username, password, host)); err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range target.To {
+		if err := c.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("recipient %s rejected: %w", rcpt, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// emailMessage builds a quoted-printable text/plain message
+func emailMessage(from string, to []string, subject, body string) ([]byte, error) {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&msg)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+// emailReport is the default email body: a table of the entries of a run
+// followed by the error and last lines of output of each failed entry
+func emailReport(event notifyEvent) string {
+	var b strings.Builder
+	entries := event.Entries
+	if event.Scope == scopeRun {
+		fmt.Fprintf(&b, "gobackup run on %s %s: %d succeeded, %d failed, %d skipped in %s\n\n",
+			event.Host, event.Status, event.Succeeded, event.Failed, event.Skipped, event.Duration)
+	} else {
+		fmt.Fprintf(&b, "Backup of '%s' on %s %s after %s\n\n", event.Entry, event.Host, event.Status, event.Duration)
+		entries = []notifyEvent{event}
+	}
+
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENTRY\tSTATUS\tSIZE\tDURATION\tREMOVED\tARCHIVE")
+	for _, entry := range entries {
+		archive := entry.Archive
+		if archive == "" {
+			archive = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n", entry.Entry, entry.Status, entry.SizeHuman, entry.Duration, len(entry.Removed), archive)
+	}
+	tw.Flush()
+
+	for _, entry := range entries {
+		if entry.Status == StatusSucceeded {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%s %s: %s\n", entry.Entry, entry.Status, entry.Error)
+		if entry.Output != "" {
+			b.WriteString("\nLast output:\n")
+			for _, line := range strings.Split(entry.Output, "\n") {
+				b.WriteString("    " + line + "\n")
+			}
+		}
+	}
+	return b.String()
+}
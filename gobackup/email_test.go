@@ -0,0 +1,186 @@
+package gobackup
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"mime/quotedprintable"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpMessage is a message received by fakeSMTPServer
+type smtpMessage struct {
+	Auth string
+	From string
+	To   []string
+	Data string
+}
+
+// fakeSMTPServer accepts a single plaintext SMTP session and reports the
+// message it received
+func fakeSMTPServer(t *testing.T) (string, <-chan smtpMessage) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	received := make(chan smtpMessage, 1)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		tp := textproto.NewConn(conn)
+		var msg smtpMessage
+		tp.PrintfLine("220 fake ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			verb, arg, _ := strings.Cut(line, " ")
+			switch strings.ToUpper(verb) {
+			case "EHLO", "HELO":
+				tp.PrintfLine("250-fake")
+				tp.PrintfLine("250 AUTH PLAIN")
+			case "AUTH":
+				_, initial, _ := strings.Cut(arg, " ")
+				decoded, _ := base64.StdEncoding.DecodeString(initial)
+				msg.Auth = string(decoded)
+				tp.PrintfLine("235 ok")
+			case "MAIL":
+				msg.From = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+				tp.PrintfLine("250 ok")
+			case "RCPT":
+				msg.To = append(msg.To, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+				tp.PrintfLine("250 ok")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				data, _ := tp.ReadDotBytes()
+				msg.Data = string(data)
+				tp.PrintfLine("250 queued")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				received <- msg
+				return
+			default:
+				tp.PrintfLine("502 unsupported")
+			}
+		}
+	}()
+	return ln.Addr().String(), received
+}
+
+// decodeBody returns the decoded quoted-printable body of a message
+func decodeBody(t *testing.T, data string) (map[string]string, string) {
+	t.Helper()
+	r := textproto.NewReader(bufio.NewReader(strings.NewReader(data)))
+	header, err := r.ReadMIMEHeader()
+	if err != nil {
+		t.Fatalf("Failed to parse message headers: %v", err)
+	}
+	body, err := io.ReadAll(quotedprintable.NewReader(r.R))
+	if err != nil {
+		t.Fatalf("Failed to decode body: %v", err)
+	}
+	headers := make(map[string]string)
+	for key := range header {
+		headers[key] = header.Get(key)
+	}
+	return headers, string(body)
+}
+
+func TestEmailRunReport(t *testing.T) {
+	addr, received := fakeSMTPServer(t)
+	t.Setenv("TEST_SMTP_PASSWORD", "hunter2")
+	target := NotificationTarget{
+		Type:     "email",
+		URL:      "smtp://" + addr,
+		TLS:      "none",
+		From:     "backups@example.com",
+		To:       []string{"ops@example.com", "me@example.com"},
+		Username: "backups",
+		Password: "env:TEST_SMTP_PASSWORD",
+		Title:    "[{{.Host}}] backups {{.Status}}: {{.Failed}} failed",
+	}
+	n, err := newNotifier([]NotificationTarget{target}, "")
+	if err != nil {
+		t.Fatalf("newNotifier() failed: %v", err)
+	}
+	n.log = discardLog
+
+	// Email defaults to a single report per run, sent whatever the outcome
+	report := sampleReport(time.Now())
+	for _, result := range report.outcomes {
+		n.entryFinished(result)
+	}
+	n.runFinished(report)
+
+	var msg smtpMessage
+	select {
+	case msg = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email received")
+	}
+	if msg.Auth != "\x00backups\x00hunter2" {
+		t.Errorf("AUTH PLAIN = %q", msg.Auth)
+	}
+	if msg.From != "backups@example.com" || strings.Join(msg.To, ",") != "ops@example.com,me@example.com" {
+		t.Errorf("envelope = %s -> %v", msg.From, msg.To)
+	}
+
+	headers, body := decodeBody(t, msg.Data)
+	if !strings.HasSuffix(headers["Subject"], "backups failed: 1 failed") {
+		t.Errorf("Subject = %q", headers["Subject"])
+	}
+	if headers["To"] != "ops@example.com, me@example.com" {
+		t.Errorf("To = %q", headers["To"])
+	}
+	for _, want := range []string{
+		"1 succeeded, 1 failed, 0 skipped",
+		"ENTRY",
+		"example-documents  succeeded  512.0 MiB",
+		"example-server failed: rsync backup failed",
+		"    ssh: connect to host example port 22: Connection refused",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("report does not contain %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestSMTPAddress(t *testing.T) {
+	tests := []struct {
+		url, tls             string
+		host, addr, wantMode string
+	}{
+		{"smtp://mail.example.com", "", "mail.example.com", "mail.example.com:587", smtpStartTLS},
+		{"smtps://mail.example.com", "", "mail.example.com", "mail.example.com:465", smtpTLS},
+		{"smtp://relay:2525", "none", "relay", "relay:2525", smtpNoTLS},
+		{"smtp://relay", "none", "relay", "relay:25", smtpNoTLS},
+	}
+	for _, tt := range tests {
+		host, addr, mode, err := smtpAddress(tt.url, tt.tls)
+		if err != nil {
+			t.Errorf("smtpAddress(%q, %q) failed: %v", tt.url, tt.tls, err)
+			continue
+		}
+		if host != tt.host || addr != tt.addr || mode != tt.wantMode {
+			t.Errorf("smtpAddress(%q, %q) = %s, %s, %s, want %s, %s, %s", tt.url, tt.tls, host, addr, mode, tt.host, tt.addr, tt.wantMode)
+		}
+	}
+
+	for _, bad := range [][2]string{{"mail.example.com:587", ""}, {"smtp://", ""}, {"smtp://mail", "ssl"}} {
+		if _, _, _, err := smtpAddress(bad[0], bad[1]); err == nil {
+			t.Errorf("smtpAddress(%q, %q) succeeded, want error", bad[0], bad[1])
+		}
+	}
+}
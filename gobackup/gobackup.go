@@ -0,0 +1,19 @@
+// Package gobackup runs the backups described by a library: tar archives of
+// local directories, rsync pulls from remote hosts and archives of command
+// output, with retention, hooks, locking and notifications. The gobackup
+// command is a thin layer over it.
+//
+// Load a library with LoadLibrary, or build one in memory, and run entries
+// of it with a Runner:
+//
+//	library, err := gobackup.LoadLibrary("library.json")
+//	if err != nil {
+//		return err
+//	}
+//	runner := gobackup.NewRunner(library, gobackup.DefaultRunOptions())
+//	runner.OnEvent = func(e gobackup.Event) { fmt.Println(e.Type, e.Entry) }
+//	result, err := runner.Run(ctx, []string{"photos"})
+package gobackup
+
+// VERSION is the version of gobackup
+const VERSION = "0.1.1"
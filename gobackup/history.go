@@ -0,0 +1,154 @@
+package gobackup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// historyCompactSize is how large the history file may grow before it is
+// rewritten keeping only the last historyKeep records of each entry
+const (
+	historyCompactSize = 1 << 20
+	historyKeep        = 100
+)
+
+// historyRecord is how one entry of one run ended
+type historyRecord struct {
+	RunID    string    `json:"RunID,omitempty"`
+	Entry    string    `json:"Entry"`
+	Started  time.Time `json:"Started"`
+	Finished time.Time `json:"Finished"`
+	Status   string    `json:"Status"`
+	Archive  string    `json:"Archive,omitempty"`
+	Size     int64     `json:"Size,omitempty"`
+	Attempts int       `json:"Attempts,omitempty"` // rsync pulls tried
+	Error    string    `json:"Error,omitempty"`
+}
+
+func historyPath(dir string) string {
+	return statePath(dir, "history.jsonl")
+}
+
+// historyRecorder appends a record to the run history, one JSON object per
+// line, every time an entry finishes
+type historyRecorder struct {
+	path string
+	log  *slog.Logger
+	mu   sync.Mutex
+}
+
+func newHistoryRecorder(path string) *historyRecorder {
+	return &historyRecorder{path: path, log: slog.Default()}
+}
+
+func (h *historyRecorder) entryStarted(backup Backup) {}
+
+func (h *historyRecorder) entryFinished(result outcome) {
+	record := historyRecord{
+		RunID:    result.runID,
+		Entry:    result.entry,
+		Started:  result.started,
+		Finished: result.started.Add(result.duration),
+		Status:   result.status,
+		Archive:  result.archive,
+		Size:     result.size,
+		Attempts: len(result.attempts),
+	}
+	if result.err != nil {
+		record.Error = result.err.Error()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.append(record); err != nil {
+		h.log.Warn("Failed to record run history", "path", h.path, "error", err)
+	}
+}
+
+func (h *historyRecorder) runFinished(report runReport) {}
+
+func (h *historyRecorder) append(record historyRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	info, statErr := f.Stat()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if statErr == nil && info.Size() > historyCompactSize {
+		return compactHistory(h.path, historyKeep)
+	}
+	return nil
+}
+
+// compactHistory rewrites the history keeping the last keep records of
+// every entry
+func compactHistory(path string, keep int) error {
+	records, err := loadHistory(path)
+	if err != nil {
+		return err
+	}
+	counts := make(map[string]int)
+	var kept []historyRecord
+	for i := len(records) - 1; i >= 0; i-- {
+		if counts[records[i].Entry] < keep {
+			counts[records[i].Entry]++
+			kept = append(kept, records[i])
+		}
+	}
+	var b bytes.Buffer
+	for i := len(kept) - 1; i >= 0; i-- {
+		line, err := json.Marshal(kept[i])
+		if err != nil {
+			return err
+		}
+		b.Write(append(line, '\n'))
+	}
+	return writeFileAtomic(path, b.Bytes(), 0644)
+}
+
+// loadHistory reads the run history oldest first. A missing file is an
+// empty history; unreadable lines, such as one cut short by a crash, are
+// skipped.
+func loadHistory(path string) ([]historyRecord, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read run history: %w", err)
+	}
+	defer f.Close()
+	var records []historyRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var record historyRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil || record.Entry == "" {
+			continue
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read run history: %w", err)
+	}
+	return records, nil
+}
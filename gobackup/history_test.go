@@ -0,0 +1,84 @@
+package gobackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistoryRecordsEveryEntry(t *testing.T) {
+	t.Setenv("SCRATCH", t.TempDir())
+	path := filepath.Join(t.TempDir(), "state", "history.jsonl")
+	library := map[string]Backup{
+		"photos": {Type: "tar", Source: t.TempDir(), Destination: t.TempDir(), Retain: 2, ChangeDir: true},
+		"broken": {Type: "tar", Source: t.TempDir(), Destination: "/nonexistent/gobackup", Retain: 1, ChangeDir: true},
+	}
+	opts := DefaultRunOptions()
+	opts.runID = "run1"
+	opts.observers = []runObserver{newHistoryRecorder(path)}
+	outcomes := runSequential(context.Background(), []string{"photos", "broken"}, library, opts)
+
+	records, err := loadHistory(path)
+	if err != nil {
+		t.Fatalf("loadHistory() failed: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	photos, broken := records[0], records[1]
+	if photos.Entry != "photos" || photos.RunID != "run1" || photos.Status != StatusSucceeded ||
+		photos.Archive != outcomes["photos"].archive || photos.Size == 0 || photos.Error != "" {
+		t.Errorf("unexpected photos record: %+v", photos)
+	}
+	if broken.Entry != "broken" || broken.Status != StatusFailed || broken.Error == "" || broken.Archive != "" {
+		t.Errorf("unexpected broken record: %+v", broken)
+	}
+	if broken.Finished.Before(broken.Started) {
+		t.Errorf("finished %v before started %v", broken.Finished, broken.Started)
+	}
+}
+
+func TestLoadHistorySkipsDamagedLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	data := `{"Entry":"db","Status":"succeeded"}
+{"Entry":"db","Sta
+{"Entry":"web","Status":"failed","Error":"boom"}
+`
+	os.WriteFile(path, []byte(data), 0644)
+	records, err := loadHistory(path)
+	if err != nil {
+		t.Fatalf("loadHistory() failed: %v", err)
+	}
+	if len(records) != 2 || records[1].Entry != "web" || records[1].Error != "boom" {
+		t.Errorf("records = %+v", records)
+	}
+	if records, err := loadHistory(filepath.Join(t.TempDir(), "missing")); records != nil || err != nil {
+		t.Errorf("missing history = %v, %v, want empty", records, err)
+	}
+}
+
+func TestCompactHistoryKeepsLatestPerEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	h := newHistoryRecorder(path)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		for _, entry := range []string{"db", "web"} {
+			h.entryFinished(outcome{entry: entry, status: StatusFailed, err: errors.New(fmt.Sprint(i)), started: start.Add(time.Duration(i) * time.Hour)})
+		}
+	}
+	if err := compactHistory(path, 2); err != nil {
+		t.Fatalf("compactHistory() failed: %v", err)
+	}
+	records, _ := loadHistory(path)
+	var got []string
+	for _, r := range records {
+		got = append(got, r.Entry+r.Error)
+	}
+	if fmt.Sprint(got) != "[db3 web3 db4 web4]" {
+		t.Errorf("kept %v, want the last two of each entry in order", got)
+	}
+}
@@ -0,0 +1,171 @@
+package gobackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// defaultHookTimeout applies to every hook command unless HookTimeout is set
+const defaultHookTimeout = 10 * time.Minute
+
+const (
+	hookPolicyAbort    = "abort"
+	hookPolicyContinue = "continue"
+)
+
+// hookEnv describes the entry and its outcome to hook commands
+type hookEnv struct {
+	backup  Backup
+	archive string
+	status  string
+	err     error
+}
+
+// environ returns the process environment plus the GOBACKUP_* variables
+func (h hookEnv) environ() []string {
+	env := append(os.Environ(),
+		"GOBACKUP_ENTRY="+h.backup.Name,
+		"GOBACKUP_TYPE="+h.backup.Type,
+		"GOBACKUP_SOURCE="+h.backup.Source,
+		"GOBACKUP_DESTINATION="+h.backup.Destination,
+		"GOBACKUP_ARCHIVE="+h.archive,
+		"GOBACKUP_STATUS="+h.status,
+	)
+	if h.err != nil {
+		env = append(env, "GOBACKUP_ERROR="+h.err.Error())
+	}
+	return env
+}
+
+// hookTimeout returns the configured per-command timeout
+func hookTimeout(backup *Backup) (time.Duration, error) {
+	if backup.HookTimeout == "" {
+		return defaultHookTimeout, nil
+	}
+	timeout, err := time.ParseDuration(backup.HookTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid HookTimeout %q: %w", backup.HookTimeout, err)
+	}
+	// A timeout of zero would kill every hook as soon as it started
+	if timeout <= 0 {
+		return 0, fmt.Errorf("invalid HookTimeout %q: must be positive", backup.HookTimeout)
+	}
+	return timeout, nil
+}
+
+// runHooks runs each command in order through sh -c. With stopOnError the
+// first failing command ends the list, otherwise every command runs and all
+// failures are returned together.
+func runHooks(ctx context.Context, kind string, commands []string, env hookEnv, timeout time.Duration, stopOnError bool, log *slog.Logger) error {
+	log = log.With("phase", kind+"-hook")
+	var errs []error
+	for _, command := range commands {
+		log.Info("Running "+kind+" hook", "command", command)
+		if err := runHook(ctx, command, env, timeout, log); err != nil {
+			err = fmt.Errorf("%s hook %q failed: %w", kind, command, err)
+			log.Error(err.Error())
+			errs = append(errs, err)
+			if stopOnError {
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runHook runs a single hook command, killing it once timeout has elapsed
+// or ctx is done
+func runHook(parent context.Context, command string, env hookEnv, timeout time.Duration, log *slog.Logger) error {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = env.environ()
+	out := newLogWriter(log, slog.LevelInfo)
+	defer out.Flush()
+	cmd.Stdout = out
+	cmd.Stderr = out
+	killGroupOnCancel(cmd)
+	err := cmd.Run()
+	if err != nil && parent.Err() != nil {
+		return fmt.Errorf("interrupted: %w", parent.Err())
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	return err
+}
+
+// killGroupOnCancel runs cmd in its own process group and makes context
+// cancellation kill the whole group, not just the sh -c wrapper
+func killGroupOnCancel(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// Don't wait forever on pipes held open by children that escaped the group
+	cmd.WaitDelay = 5 * time.Second
+}
+
+// withHooks runs PreHooks, the backup itself, PostHooks and then OnSuccess
+// or OnFailure. PostHooks always run once the PreHooks have started, even if
+// a pre-hook or the backup failed. A failing pre-hook aborts the backup
+// unless PreHookPolicy is "continue", which only records it as a warning of
+// the backup. The hooks that follow the backup still
+// run if ctx is cancelled, so anything a pre-hook stopped is started again.
+func withHooks(ctx context.Context, backup *Backup, log *slog.Logger, run func() (*BackupResult, error)) (*BackupResult, error) {
+	timeout, err := hookTimeout(backup)
+	if err != nil {
+		return nil, err
+	}
+	policy := backup.PreHookPolicy
+	if policy == "" {
+		policy = hookPolicyAbort
+	}
+	if policy != hookPolicyAbort && policy != hookPolicyContinue {
+		return nil, fmt.Errorf("invalid PreHookPolicy %q (supported: %s, %s)", policy, hookPolicyAbort, hookPolicyContinue)
+	}
+	// Hooks see the entry as configured, before rsync rewrites Source
+	env := hookEnv{backup: *backup, status: "running"}
+
+	var result *BackupResult
+	preErr := runHooks(ctx, "pre", backup.PreHooks, env, timeout, true, log)
+	if preErr != nil && policy == hookPolicyAbort {
+		err = fmt.Errorf("backup aborted: %w", preErr)
+	} else {
+		result, err = run()
+		if preErr != nil {
+			log.Warn("Continuing despite the failed pre-hook", "policy", policy)
+			if result == nil {
+				result = &BackupResult{}
+			}
+			result.Warnings = append(result.Warnings, preErr.Error())
+		}
+	}
+
+	if result != nil {
+		env.archive = result.ArchivePath
+	}
+	env.status, env.err = "success", err
+	if err != nil {
+		env.status = "failure"
+	}
+	cleanup := context.WithoutCancel(ctx)
+	if postErr := runHooks(cleanup, "post", backup.PostHooks, env, timeout, false, log); postErr != nil {
+		err = errors.Join(err, postErr)
+		env.status, env.err = "failure", err
+	}
+
+	if err == nil {
+		runHooks(cleanup, "success", backup.OnSuccess, env, timeout, false, log)
+	} else {
+		runHooks(cleanup, "failure", backup.OnFailure, env, timeout, false, log)
+	}
+	return result, err
+}
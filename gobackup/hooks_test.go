@@ -0,0 +1,131 @@
+package gobackup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readHookLog(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read hook log: %v", err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestWithHooksOrderAndEnvironment(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "hooks.log")
+	backup := Backup{
+		Name:        "photos",
+		Type:        "tar",
+		Source:      "/srv/photos",
+		Destination: "/backups",
+		PreHooks:    []string{`echo "pre $GOBACKUP_ENTRY $GOBACKUP_STATUS" >> ` + logFile},
+		PostHooks:   []string{`echo "post $GOBACKUP_STATUS $GOBACKUP_ARCHIVE" >> ` + logFile},
+		OnSuccess:   []string{`echo "success $GOBACKUP_SOURCE" >> ` + logFile},
+		OnFailure:   []string{`echo "failure" >> ` + logFile},
+	}
+
+	_, err := withHooks(context.Background(), &backup, discardLog, func() (*BackupResult, error) {
+		return &BackupResult{ArchivePath: "/backups/photos.tar.gz"}, nil
+	})
+	if err != nil {
+		t.Fatalf("withHooks() failed: %v", err)
+	}
+
+	expected := []string{
+		"pre photos running",
+		"post success /backups/photos.tar.gz",
+		"success /srv/photos",
+	}
+	if got := readHookLog(t, logFile); strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Errorf("hook log = %q, want %q", got, expected)
+	}
+}
+
+func TestWithHooksPostHooksRunOnFailure(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "hooks.log")
+	backup := Backup{
+		Name:      "photos",
+		PreHooks:  []string{"echo pre >> " + logFile, "exit 3", "echo never >> " + logFile},
+		PostHooks: []string{"echo post >> " + logFile},
+		OnFailure: []string{`echo "failure $GOBACKUP_STATUS" >> ` + logFile},
+	}
+
+	ran := false
+	_, err := withHooks(context.Background(), &backup, discardLog, func() (*BackupResult, error) {
+		ran = true
+		return nil, nil
+	})
+	if err == nil {
+		t.Fatal("withHooks() succeeded despite failing pre-hook")
+	}
+	if ran {
+		t.Error("backup ran although the pre-hook failed with the abort policy")
+	}
+	expected := []string{"pre", "post", "failure failure"}
+	if got := readHookLog(t, logFile); strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Errorf("hook log = %q, want %q", got, expected)
+	}
+}
+
+func TestWithHooksContinuePolicy(t *testing.T) {
+	backup := Backup{
+		Name:          "photos",
+		PreHooks:      []string{"exit 1"},
+		PreHookPolicy: hookPolicyContinue,
+	}
+
+	ran := false
+	result, err := withHooks(context.Background(), &backup, discardLog, func() (*BackupResult, error) {
+		ran = true
+		return &BackupResult{ArchivePath: "/backups/photos.tar.gz"}, nil
+	})
+	if !ran {
+		t.Error("backup did not run with the continue policy")
+	}
+	if err != nil {
+		t.Errorf("pre-hook failure failed the backup: %v", err)
+	}
+	if result == nil || len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], `pre hook "exit 1" failed`) {
+		t.Errorf("result = %+v, want the pre-hook failure as a warning", result)
+	}
+}
+
+func TestWithHooksTimeout(t *testing.T) {
+	backup := Backup{
+		Name:        "photos",
+		PreHooks:    []string{"sleep 5"},
+		HookTimeout: "100ms",
+	}
+
+	start := time.Now()
+	_, err := withHooks(context.Background(), &backup, discardLog, func() (*BackupResult, error) { return nil, nil })
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("error = %v, want a timeout", err)
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Error("hook was not stopped at its timeout")
+	}
+}
+
+func TestHookTimeoutMustBePositive(t *testing.T) {
+	for _, timeout := range []string{"0s", "-1m"} {
+		backup := Backup{Name: "photos", HookTimeout: timeout}
+		if _, err := hookTimeout(&backup); err == nil {
+			t.Errorf("hookTimeout() accepted HookTimeout %q", timeout)
+		}
+	}
+}
+
+func TestWithHooksInvalidPolicy(t *testing.T) {
+	backup := Backup{Name: "photos", PreHookPolicy: "ignore"}
+	if _, err := withHooks(context.Background(), &backup, discardLog, func() (*BackupResult, error) { return nil, nil }); err == nil {
+		t.Error("withHooks() accepted an invalid PreHookPolicy")
+	}
+}
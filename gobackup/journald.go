@@ -0,0 +1,87 @@
+package gobackup
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// journalSocket is where journald listens for its native protocol
+const journalSocket = "/run/systemd/journal/socket"
+
+// journalHandler sends records to journald using its native protocol, one
+// datagram of FIELD=value lines per record. Record attributes become
+// upper-case fields, so entry and run_id can be matched with
+// `journalctl ENTRY=photos` or `journalctl RUN_ID=...`.
+type journalHandler struct {
+	conn       *sinkConn
+	level      slog.Leveler
+	identifier string
+	attrs      flatAttrs
+}
+
+func (h *journalHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *journalHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.conn.write(h.format(r))
+}
+
+func (h *journalHandler) format(r slog.Record) []byte {
+	var b bytes.Buffer
+	journalField(&b, "MESSAGE", r.Message)
+	journalField(&b, "PRIORITY", strconv.Itoa(syslogSeverity(r.Level)))
+	journalField(&b, "SYSLOG_IDENTIFIER", h.identifier)
+	for _, attr := range h.attrs.record(r) {
+		if key := journalFieldName(attr.key); key != "" {
+			journalField(&b, key, attr.value)
+		}
+	}
+	return b.Bytes()
+}
+
+func (h *journalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = h.attrs.with(attrs)
+	return &clone
+}
+
+func (h *journalHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.attrs = h.attrs.group(name)
+	return &clone
+}
+
+// journalField appends a field. Values containing newlines are written as
+// the field name, a newline, the little-endian 64-bit length and the value.
+func journalField(b *bytes.Buffer, key, value string) {
+	if !strings.Contains(value, "\n") {
+		b.WriteString(key + "=" + value + "\n")
+		return
+	}
+	b.WriteString(key + "\n")
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
+
+// journalFieldName makes key a valid journal field name: upper-case
+// letters, digits and underscores, not starting with an underscore or a
+// digit, at most 64 characters. Trusted fields start with an underscore, so
+// those cannot be set.
+func journalFieldName(key string) string {
+	name := []byte(strings.ToUpper(key))
+	for i, c := range name {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			name[i] = '_'
+		}
+	}
+	trimmed := strings.TrimLeft(string(name), "_0123456789")
+	if len(trimmed) > 64 {
+		trimmed = trimmed[:64]
+	}
+	return trimmed
+}
@@ -0,0 +1,86 @@
+package gobackup
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// parseJournalFields decodes a native protocol datagram
+func parseJournalFields(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	fields := make(map[string]string)
+	for len(data) > 0 {
+		nl := bytes.IndexByte(data, '\n')
+		if nl < 0 {
+			t.Fatalf("unterminated field in %q", data)
+		}
+		line := data[:nl]
+		if eq := bytes.IndexByte(line, '='); eq >= 0 {
+			fields[string(line[:eq])] = string(line[eq+1:])
+			data = data[nl+1:]
+			continue
+		}
+		data = data[nl+1:]
+		length := binary.LittleEndian.Uint64(data[:8])
+		fields[string(line)] = string(data[8 : 8+length])
+		data = data[8+length+1:]
+	}
+	return fields
+}
+
+func TestJournaldSink(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "journal.sock")
+	pc, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer pc.Close()
+	sinks, err := openLogSinks([]LogSink{{Type: "journald", Address: socket}}, discardLog)
+	if err != nil {
+		t.Fatalf("openLogSinks() failed: %v", err)
+	}
+	defer sinks.Close()
+
+	sinks.logger(discardLog).With("run_id", "0a1b", "entry", "photos").With("phase", "tar").Error("tar backup failed", "error", "exit status 2\ntar: photos: Cannot open")
+
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64*1024)
+	n, err := pc.Read(buf)
+	if err != nil {
+		t.Fatalf("no journal datagram: %v", err)
+	}
+	fields := parseJournalFields(t, buf[:n])
+	expected := map[string]string{
+		"MESSAGE":           "tar backup failed",
+		"PRIORITY":          "3",
+		"SYSLOG_IDENTIFIER": "gobackup",
+		"RUN_ID":            "0a1b",
+		"ENTRY":             "photos",
+		"PHASE":             "tar",
+		"ERROR":             "exit status 2\ntar: photos: Cannot open",
+	}
+	for key, value := range expected {
+		if fields[key] != value {
+			t.Errorf("%s = %q, want %q", key, fields[key], value)
+		}
+	}
+}
+
+func TestJournalFieldName(t *testing.T) {
+	tests := map[string]string{
+		"entry":      "ENTRY",
+		"run_id":     "RUN_ID",
+		"remote.cmd": "REMOTE_CMD",
+		"_PID":       "PID",
+		"2fa":        "FA",
+	}
+	for key, expected := range tests {
+		if got := journalFieldName(key); got != expected {
+			t.Errorf("journalFieldName(%q) = %q, want %q", key, got, expected)
+		}
+	}
+}
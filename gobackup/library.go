@@ -0,0 +1,272 @@
+package gobackup
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+// Library is a parsed library file: the backup entries keyed by name and
+// the settings stored under settingsKey
+type Library struct {
+	Entries  map[string]Backup
+	Settings Settings
+}
+
+// LibraryNotFoundError is returned when the library file does not exist
+type LibraryNotFoundError struct {
+	Path string
+	Err  error
+}
+
+func (e *LibraryNotFoundError) Error() string {
+	return fmt.Sprintf("library %s not found, does it actually exist? %v", e.Path, e.Err)
+}
+
+func (e *LibraryNotFoundError) Unwrap() error { return e.Err }
+
+// LibraryParseError is returned when the library file is not valid JSON or
+// a value has the wrong type. Line and Column are 1-based and point at or
+// just after the offending value.
+type LibraryParseError struct {
+	Path   string
+	Entry  string // entry holding the bad value, empty if unknown
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *LibraryParseError) Error() string {
+	where := fmt.Sprintf("%s:%d:%d", e.Path, e.Line, e.Column)
+	if e.Entry != "" {
+		return fmt.Sprintf("%s: entry '%s': %v", where, e.Entry, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", where, e.Err)
+}
+
+func (e *LibraryParseError) Unwrap() error { return e.Err }
+
+// LibraryValidationError lists every problem found in a library that
+// parsed, so all of them can be fixed in one go
+type LibraryValidationError struct {
+	Path     string
+	Problems []error
+}
+
+func (e *LibraryValidationError) Error() string {
+	lines := make([]string, 0, len(e.Problems)+1)
+	if e.Path == "" {
+		lines = append(lines, "library is invalid:")
+	} else {
+		lines = append(lines, fmt.Sprintf("library %s is invalid:", e.Path))
+	}
+	for _, problem := range e.Problems {
+		lines = append(lines, "  "+problem.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e *LibraryValidationError) Unwrap() []error { return e.Problems }
+
+// LoadLibrary reads, parses and validates the library file at path. Errors
+// are a *LibraryNotFoundError, *LibraryParseError or
+// *LibraryValidationError. With a validation error the parsed library is
+// returned as well, so tools reporting on entries can still show the valid
+// ones.
+func LoadLibrary(path string) (Library, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Library{}, &LibraryNotFoundError{Path: path, Err: err}
+	}
+	if err != nil {
+		return Library{}, fmt.Errorf("unable to read library %s: %w", path, err)
+	}
+	library, err := parseLibrary(path, data)
+	if err != nil {
+		return Library{}, err
+	}
+	if problems := validateLibrary(library); len(problems) > 0 {
+		return library, &LibraryValidationError{Path: path, Problems: problems}
+	}
+	return library, nil
+}
+
+// parseLibrary decodes the library one top-level key at a time, so errors
+// in an entry can be located in the file
+func parseLibrary(path string, data []byte) (Library, error) {
+	library := Library{Entries: make(map[string]Backup)}
+	parseErr := func(entry string, offset int64, err error) error {
+		line, column := position(data, offset)
+		return &LibraryParseError{Path: path, Entry: entry, Line: line, Column: column, Err: err}
+	}
+	// syntaxErr locates errors of the decoder, which knows where it stopped
+	dec := json.NewDecoder(bytes.NewReader(data))
+	syntaxErr := func(err error) error {
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			return parseErr("", syntax.Offset, err)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return parseErr("", int64(len(data)), io.ErrUnexpectedEOF)
+		}
+		return parseErr("", dec.InputOffset(), err)
+	}
+
+	if tok, err := dec.Token(); err != nil {
+		return library, syntaxErr(err)
+	} else if tok != json.Delim('{') {
+		return library, parseErr("", dec.InputOffset(), errors.New("library must be a JSON object of entries"))
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return library, syntaxErr(err)
+		}
+		name := tok.(string)
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return library, syntaxErr(err)
+		}
+		start := dec.InputOffset() - int64(len(raw))
+
+		var value any = &library.Settings
+		var backup Backup
+		if name != settingsKey {
+			value = &backup
+		}
+		if err := json.Unmarshal(raw, value); err != nil {
+			offset := start
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				offset += typeErr.Offset
+			}
+			return library, parseErr(name, offset, err)
+		}
+		if name != settingsKey {
+			if _, dup := library.Entries[name]; dup {
+				return library, parseErr(name, start, errors.New("entry defined more than once"))
+			}
+			library.Entries[name] = backup
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return library, syntaxErr(err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return library, parseErr("", dec.InputOffset(), errors.New("unexpected data after the library object"))
+	}
+	return library, nil
+}
+
+// position converts a byte offset into a 1-based line and column
+func position(data []byte, offset int64) (line, column int) {
+	offset = min(max(offset, 0), int64(len(data)))
+	before := data[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	column = len(before) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
+
+// validateLibrary returns every problem with the entries of library, in
+// entry name order
+func validateLibrary(library Library) []error {
+	return validateEntries(library.Entries)
+}
+
+// validateSelected returns the problems with entries and everything they
+// depend on, so a run is not held up by entries it does not touch. Entries
+// not in the library are left to the caller.
+func validateSelected(library Library, entries []string) []error {
+	closure := make(map[string]Backup)
+	var add func(name string)
+	add = func(name string) {
+		backup, exists := library.Entries[name]
+		if _, seen := closure[name]; seen || !exists {
+			return
+		}
+		closure[name] = backup
+		for _, dep := range backup.DependsOn {
+			add(dep)
+		}
+	}
+	for _, entry := range entries {
+		add(entry)
+	}
+	return validateEntries(closure)
+}
+
+// validateEntries returns every problem with entries, in entry name order.
+// Dependencies on entries missing from it are problems too.
+func validateEntries(entries map[string]Backup) []error {
+	var problems []error
+	for _, name := range sortedKeys(entries) {
+		backup := entries[name]
+		backup.Name = name
+		for _, err := range validateEntry(&backup) {
+			problems = append(problems, fmt.Errorf("entry '%s': %w", name, err))
+		}
+	}
+	if err := validateDependencies(entries); err != nil {
+		problems = append(problems, err)
+	}
+	return problems
+}
+
+// validateEntry checks the settings of one entry without touching the
+// filesystem or network, which may legitimately be unavailable until the
+// entry runs
+func validateEntry(backup *Backup) []error {
+	var problems []error
+	problem := func(err error) {
+		if err != nil {
+			problems = append(problems, err)
+		}
+	}
+	if strings.ContainsAny(backup.Name, ",/") {
+		problem(errors.New("name must not contain ',' or '/'"))
+	}
+
+	if t, err := backupTypeFor(backup.Type); err != nil {
+		problem(err)
+	} else {
+		problems = append(problems, t.Validate(backup)...)
+	}
+	if backup.Retain < 0 {
+		problem(fmt.Errorf("invalid Retain %d", backup.Retain))
+	}
+
+	if backup.PreHookPolicy != "" && backup.PreHookPolicy != hookPolicyAbort && backup.PreHookPolicy != hookPolicyContinue {
+		problem(fmt.Errorf("invalid PreHookPolicy %q (supported: %s, %s)", backup.PreHookPolicy, hookPolicyAbort, hookPolicyContinue))
+	}
+	_, err := hookTimeout(backup)
+	problem(err)
+	_, err = entryTimeout(backup)
+	problem(err)
+	_, err = retryPolicyFor(backup)
+	problem(err)
+	if backup.Schedule != "" {
+		if _, err := parseSchedule(backup.Schedule); err != nil {
+			problem(fmt.Errorf("invalid Schedule: %w", err))
+		}
+	}
+	if backup.MaxAge != "" {
+		if maxAge, err := time.ParseDuration(backup.MaxAge); err != nil || maxAge <= 0 {
+			problem(fmt.Errorf("invalid MaxAge %q", backup.MaxAge))
+		}
+	}
+	return problems
+}
+
+// isLibraryError reports whether err comes from loading a library
+func isLibraryError(err error) bool {
+	var notFound *LibraryNotFoundError
+	var parse *LibraryParseError
+	var invalid *LibraryValidationError
+	return errors.As(err, &notFound) || errors.As(err, &parse) || errors.As(err, &invalid)
+}
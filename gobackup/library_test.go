@@ -0,0 +1,123 @@
+package gobackup
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeLibrary(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "library.json")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("Failed to write library: %v", err)
+	}
+	return path
+}
+
+func TestLoadLibraryNotFound(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.json")
+	_, err := LoadLibrary(path)
+	var notFound *LibraryNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Fatalf("error = %v, want a *LibraryNotFoundError", err)
+	}
+	if notFound.Path != path || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("error = %+v", notFound)
+	}
+	if ExitCode(err) != ExitConfigError {
+		t.Errorf("ExitCode() = %d, want %d", ExitCode(err), ExitConfigError)
+	}
+}
+
+func TestLoadLibraryParseErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		contents string
+		entry    string
+		line     int
+		column   int
+	}{
+		{
+			name:     "syntax error",
+			contents: "{\n  \"photos\": {\n    \"Source\": \"/srv/photos\",\n  }\n}",
+			line:     4,
+			column:   4,
+		},
+		{
+			name:     "wrong type",
+			contents: "{\n  \"photos\": {\n    \"Source\": \"/srv/photos\",\n    \"Retain\": \"three\"\n  }\n}",
+			entry:    "photos",
+			line:     4,
+			column:   22,
+		},
+		{
+			name:     "duplicate entry",
+			contents: "{\n  \"photos\": {},\n  \"photos\": {}\n}",
+			entry:    "photos",
+			line:     3,
+			column:   13,
+		},
+		{
+			name:     "truncated",
+			contents: "{\n  \"photos\": {",
+			line:     2,
+			column:   14,
+		},
+		{
+			name:     "not an object",
+			contents: `["photos"]`,
+			line:     1,
+			column:   2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := LoadLibrary(writeLibrary(t, tc.contents))
+			var parseErr *LibraryParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("error = %v, want a *LibraryParseError", err)
+			}
+			if parseErr.Entry != tc.entry || parseErr.Line != tc.line || parseErr.Column != tc.column {
+				t.Errorf("error at entry %q %d:%d, want entry %q %d:%d (%v)",
+					parseErr.Entry, parseErr.Line, parseErr.Column, tc.entry, tc.line, tc.column, err)
+			}
+		})
+	}
+}
+
+func TestLoadLibraryValidation(t *testing.T) {
+	path := writeLibrary(t, `{
+		"photos": {"Source": "/srv/photos", "Destination": "/backups", "Type": "tar"},
+		"broken": {"Type": "zip", "Retain": -1, "Schedule": "whenever"},
+		"db": {"Type": "command", "DependsOn": ["missing"]}
+	}`)
+
+	library, err := LoadLibrary(path)
+	var invalid *LibraryValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("error = %v, want a *LibraryValidationError", err)
+	}
+	expected := []string{
+		`entry 'broken': unknown Type "zip"`,
+		"entry 'broken': invalid Retain -1",
+		"entry 'broken': invalid Schedule",
+		"entry 'db': command backup requires a Command",
+		"entry 'db': Destination is required",
+		"missing",
+	}
+	if len(invalid.Problems) != len(expected) {
+		t.Fatalf("problems = %q, want %d", invalid.Problems, len(expected))
+	}
+	for i, problem := range invalid.Problems {
+		if !strings.Contains(problem.Error(), expected[i]) {
+			t.Errorf("problem %d = %q, want it to contain %q", i, problem, expected[i])
+		}
+	}
+	if library.Entries["photos"].Source != "/srv/photos" {
+		t.Error("the parsed library was not returned with the validation error")
+	}
+}
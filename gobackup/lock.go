@@ -0,0 +1,182 @@
+package gobackup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+var (
+	// lockPollInterval is how often a held lock is re-checked while waiting
+	lockPollInterval = time.Second
+	// lockGracePeriod is how long a lock file that cannot be parsed is
+	// assumed to be still being written by its holder
+	lockGracePeriod = 10 * time.Second
+)
+
+// lockInfo is written into every lock file so a held lock can be traced back
+// to the process holding it
+type lockInfo struct {
+	PID     int       `json:"PID"`
+	Host    string    `json:"Host"`
+	Started time.Time `json:"Started"`
+}
+
+// LockBusyError is returned when a lock is still held by another live process
+// after any wait period has passed
+type LockBusyError struct {
+	Path   string
+	Holder lockInfo
+}
+
+func (e *LockBusyError) Error() string {
+	if e.Holder.PID == 0 {
+		return fmt.Sprintf("lock %s is being taken by another process", e.Path)
+	}
+	return fmt.Sprintf("lock %s is held by pid %d on %s since %s",
+		e.Path, e.Holder.PID, e.Holder.Host, e.Holder.Started.Format(time.RFC3339))
+}
+
+// fileLock is an exclusive lock backed by a file created with O_EXCL
+type fileLock struct {
+	path string
+}
+
+// entryLockPath returns the lock file guarding a whole library entry
+func entryLockPath(backup *Backup) string {
+	scratch := GetEnv("SCRATCH", "/tmp")
+	return filepath.Join(scratch, fmt.Sprintf("gobackup_%s.lock", backup.Name))
+}
+
+// scratchLockPath returns the lock file guarding an rsync scratch directory
+func scratchLockPath(scratchDir string) string {
+	return filepath.Clean(scratchDir) + ".lock"
+}
+
+// acquireLock takes the lock at path. Locks left behind by dead processes on
+// this host are cleared. If the lock is held, acquireLock keeps retrying until
+// wait has elapsed and then returns a *LockBusyError, or until ctx is done.
+func acquireLock(ctx context.Context, path string, wait time.Duration) (*fileLock, error) {
+	hostname, _ := os.Hostname()
+	info := lockInfo{PID: os.Getpid(), Host: hostname, Started: time.Now()}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode lock info: %w", err)
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, werr := f.Write(data)
+			cerr := f.Close()
+			if werr != nil || cerr != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("failed to write lock file %s: %w", path, errors.Join(werr, cerr))
+			}
+			return &fileLock{path: path}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create lock file %s: %w", path, err)
+		}
+
+		holder, stale, readErr := inspectLock(path, hostname)
+		if stale {
+			breakLock(path, hostname)
+			continue
+		}
+		if os.IsNotExist(readErr) {
+			// Released between our create and read
+			continue
+		}
+
+		if !time.Now().Before(deadline) {
+			return nil, &LockBusyError{Path: path, Holder: holder}
+		}
+		sleep := lockPollInterval
+		if remaining := time.Until(deadline); remaining < sleep {
+			sleep = remaining
+		}
+		timer := time.NewTimer(sleep)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("gave up waiting for lock %s: %w", path, ctx.Err())
+		}
+	}
+}
+
+// inspectLock reads the lock at path and reports whether it may be cleared:
+// its holder is a dead process on this host, or it cannot be parsed and is
+// older than lockGracePeriod, as after a crash between creating and writing
+// it
+func inspectLock(path, hostname string) (lockInfo, bool, error) {
+	holder, err := readLock(path)
+	if err == nil {
+		return holder, holder.stale(hostname), nil
+	}
+	if os.IsNotExist(err) {
+		return holder, false, err
+	}
+	info, statErr := os.Stat(path)
+	return holder, statErr == nil && time.Since(info.ModTime()) > lockGracePeriod, err
+}
+
+// breakLock clears the stale lock at path. The file is first renamed to a
+// name no other process uses and checked again there, so a lock another
+// process took after ours was found stale is put back rather than deleted.
+func breakLock(path, hostname string) {
+	moved := fmt.Sprintf("%s.stale.%d.%d", path, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(path, moved); err != nil {
+		return
+	}
+	if _, stale, _ := inspectLock(moved, hostname); !stale {
+		os.Link(moved, path)
+	}
+	os.Remove(moved)
+}
+
+// readLock parses the holder information from an existing lock file
+func readLock(path string) (lockInfo, error) {
+	var info lockInfo
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return info, err
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return info, fmt.Errorf("failed to parse lock file %s: %w", path, err)
+	}
+	return info, nil
+}
+
+// stale reports whether the lock holder is a process on this host that no
+// longer exists. Locks held from other hosts are never considered stale.
+func (l lockInfo) stale(hostname string) bool {
+	if l.Host != hostname || l.PID <= 0 {
+		return false
+	}
+	return !processAlive(l.PID)
+}
+
+// processAlive checks for a running process without signalling it
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// Release removes the lock file
+func (l *fileLock) Release() error {
+	if l == nil {
+		return nil
+	}
+	if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove lock file %s: %w", l.path, err)
+	}
+	return nil
+}
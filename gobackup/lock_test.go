@@ -0,0 +1,140 @@
+package gobackup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquireLockExclusive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "entry.lock")
+
+	lock, err := acquireLock(context.Background(), path, 0)
+	if err != nil {
+		t.Fatalf("acquireLock() failed: %v", err)
+	}
+
+	holder, err := readLock(path)
+	if err != nil {
+		t.Fatalf("readLock() failed: %v", err)
+	}
+	if holder.PID != os.Getpid() {
+		t.Errorf("lock PID = %d, want %d", holder.PID, os.Getpid())
+	}
+
+	_, err = acquireLock(context.Background(), path, 0)
+	var busy *LockBusyError
+	if !errors.As(err, &busy) {
+		t.Fatalf("second acquireLock() error = %v, want *LockBusyError", err)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Release() failed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("lock file still exists after Release()")
+	}
+}
+
+func TestAcquireLockClearsStaleLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "entry.lock")
+
+	// Find a PID that is guaranteed to be dead
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("failed to run helper process: %v", err)
+	}
+	hostname, _ := os.Hostname()
+	data, _ := json.Marshal(lockInfo{PID: cmd.Process.Pid, Host: hostname, Started: time.Now()})
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("failed to write stale lock: %v", err)
+	}
+
+	lock, err := acquireLock(context.Background(), path, 0)
+	if err != nil {
+		t.Fatalf("acquireLock() did not clear stale lock: %v", err)
+	}
+	lock.Release()
+}
+
+func TestAcquireLockKeepsOtherHostsLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "entry.lock")
+	data, _ := json.Marshal(lockInfo{PID: 1, Host: "some-other-host", Started: time.Now()})
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("failed to write lock: %v", err)
+	}
+
+	if _, err := acquireLock(context.Background(), path, 0); err == nil {
+		t.Error("acquireLock() took a lock held from another host")
+	}
+}
+
+func TestAcquireLockWaits(t *testing.T) {
+	original := lockPollInterval
+	lockPollInterval = 10 * time.Millisecond
+	defer func() { lockPollInterval = original }()
+
+	path := filepath.Join(t.TempDir(), "entry.lock")
+	first, err := acquireLock(context.Background(), path, 0)
+	if err != nil {
+		t.Fatalf("acquireLock() failed: %v", err)
+	}
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		first.Release()
+	}()
+
+	second, err := acquireLock(context.Background(), path, 2*time.Second)
+	if err != nil {
+		t.Fatalf("acquireLock() with wait failed: %v", err)
+	}
+	second.Release()
+}
+
+func TestAcquireLockClearsUnparseableLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "entry.lock")
+	// A crash between creating the lock file and writing it leaves it empty
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatalf("failed to write lock: %v", err)
+	}
+
+	_, err := acquireLock(context.Background(), path, 0)
+	var busy *LockBusyError
+	if !errors.As(err, &busy) {
+		t.Fatalf("acquireLock() = %v, want an empty lock within its grace period to be busy", err)
+	}
+
+	old := time.Now().Add(-2 * lockGracePeriod)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := acquireLock(context.Background(), path, 0)
+	if err != nil {
+		t.Fatalf("acquireLock() did not clear an old unparseable lock: %v", err)
+	}
+	lock.Release()
+}
+
+func TestBreakLockKeepsLiveLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "entry.lock")
+	hostname, _ := os.Hostname()
+	// Taken by a live process after ours found the previous holder dead
+	live := lockInfo{PID: os.Getpid(), Host: hostname, Started: time.Now()}
+	data, _ := json.Marshal(live)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("failed to write lock: %v", err)
+	}
+
+	breakLock(path, hostname)
+	if holder, err := readLock(path); err != nil || holder.PID != live.PID {
+		t.Errorf("live lock was not put back: %+v, %v", holder, err)
+	}
+	if matches, _ := filepath.Glob(path + ".stale.*"); len(matches) != 0 {
+		t.Errorf("renamed lock files left behind: %v", matches)
+	}
+}
@@ -0,0 +1,299 @@
+package gobackup
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewLogHandler returns a handler writing records in format, text or json,
+// at level and above to w
+func NewLogHandler(format, level string, w io.Writer) (slog.Handler, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid --log-level %q (supported: debug, info, warn, error)", level)
+	}
+	return newLogHandler(format, w, lvl)
+}
+
+// logSinks are the journald and syslog sinks configured in a library
+type logSinks struct {
+	handlers []slog.Handler
+	conns    []*sinkConn
+}
+
+// openLogSinks connects to the sinks configured in a library in the
+// background. Invalid settings are an error; an unreachable daemon is only a
+// warning on log.
+func openLogSinks(sinks []LogSink, log *slog.Logger) (*logSinks, error) {
+	s := &logSinks{}
+	for i, sink := range sinks {
+		handler, conn, err := newLogSink(sink)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("Logging[%d]: %w", i, err)
+		}
+		s.handlers = append(s.handlers, handler)
+		s.conns = append(s.conns, conn)
+	}
+	for i, conn := range s.conns {
+		conn.start(func(err error) {
+			log.Warn("Log sink unavailable, dropping its records until it can be reached", "sink", sinks[i].Type, "error", err)
+		})
+	}
+	return s, nil
+}
+
+// logger returns log with its records also sent to the sinks
+func (s *logSinks) logger(log *slog.Logger) *slog.Logger {
+	if len(s.handlers) == 0 {
+		return log
+	}
+	return slog.New(append(teeHandler{log.Handler()}, s.handlers...))
+}
+
+// Close disconnects from the sinks
+func (s *logSinks) Close() {
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// newLogSink returns the handler for one configured sink
+func newLogSink(sink LogSink) (slog.Handler, *sinkConn, error) {
+	level := slog.LevelInfo
+	if sink.Level != "" {
+		if err := level.UnmarshalText([]byte(sink.Level)); err != nil {
+			return nil, nil, fmt.Errorf("invalid Level %q (supported: debug, info, warn, error)", sink.Level)
+		}
+	}
+	identifier := sink.Identifier
+	if identifier == "" {
+		identifier = "gobackup"
+	}
+	switch sink.Type {
+	case "journald":
+		address := sink.Address
+		if address == "" {
+			address = journalSocket
+		}
+		conn := &sinkConn{candidates: [][2]string{{"unixgram", strings.TrimPrefix(address, "unix://")}}}
+		return &journalHandler{conn: conn, level: level, identifier: identifier}, conn, nil
+	case "syslog":
+		facility, err := parseFacility(sink.Facility)
+		if err != nil {
+			return nil, nil, err
+		}
+		candidates, err := syslogCandidates(sink.Address)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid Address: %w", err)
+		}
+		conn := &sinkConn{candidates: candidates}
+		return newSyslogHandler(conn, level, facility, identifier), conn, nil
+	}
+	return nil, nil, fmt.Errorf("unknown Type %q (supported: journald, syslog)", sink.Type)
+}
+
+// newRunID returns an identifier for one run, logged as run_id with every
+// record so the lines of a run can be picked out of a shared log
+func newRunID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newLogHandler returns a text or JSON handler writing to w
+func newLogHandler(format string, w io.Writer, level slog.Leveler) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	}
+	return nil, fmt.Errorf("invalid --log-format %q (supported: text, json)", format)
+}
+
+// teeHandler sends every record to all of its handlers
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			if err := h.Handle(ctx, r.Clone()); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
+
+// messageHandler writes only the message of each record, prefixed with the
+// level for warnings and errors. It keeps the output tail of an entry
+// readable in failure reports.
+type messageHandler struct {
+	w io.Writer
+}
+
+func (h messageHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo
+}
+
+func (h messageHandler) Handle(ctx context.Context, r slog.Record) error {
+	line := r.Message
+	if r.Level >= slog.LevelWarn {
+		line = r.Level.String() + ": " + line
+	}
+	_, err := io.WriteString(h.w, line+"\n")
+	return err
+}
+
+func (h messageHandler) WithAttrs(attrs []slog.Attr) slog.Handler { return h }
+func (h messageHandler) WithGroup(name string) slog.Handler       { return h }
+
+// withHandler returns a logger that also sends its records to h
+func withHandler(log *slog.Logger, h slog.Handler) *slog.Logger {
+	return slog.New(teeHandler{log.Handler(), h})
+}
+
+// lineWriter is an io.Writer for subprocess output that calls fn with every
+// complete line. Carriage returns end lines too, so progress output that
+// redraws a line is seen one update at a time. Blank lines are dropped.
+type lineWriter struct {
+	fn  func(line string)
+	mu  sync.Mutex // guards buf against concurrent subprocess output
+	buf []byte
+}
+
+func newLineWriter(fn func(line string)) *lineWriter {
+	return &lineWriter{fn: fn}
+}
+
+// newLogWriter returns a lineWriter logging every line as a record at level,
+// so output from concurrent jobs never interleaves mid-line and carries the
+// entry and phase of log
+func newLogWriter(log *slog.Logger, level slog.Level) *lineWriter {
+	return newLineWriter(func(line string) {
+		log.Log(context.Background(), level, line)
+	})
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	lw.buf = append(lw.buf, p...)
+	for {
+		idx := bytes.IndexAny(lw.buf, "\r\n")
+		if idx < 0 {
+			break
+		}
+		lw.line(string(lw.buf[:idx]))
+		lw.buf = lw.buf[idx+1:]
+	}
+	return len(p), nil
+}
+
+// Flush passes on any trailing partial line
+func (lw *lineWriter) Flush() {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if len(lw.buf) > 0 {
+		lw.line(string(lw.buf))
+		lw.buf = nil
+	}
+}
+
+func (lw *lineWriter) line(line string) {
+	if strings.TrimSpace(line) != "" {
+		lw.fn(line)
+	}
+}
+
+// entryLog is the per-run log file of an entry with LogFile set. It is
+// written to scratch while the entry runs and then stored next to the
+// archive, or as <Name>_<timestamp>.failed.log if no archive was written.
+type entryLog struct {
+	file    *os.File
+	started time.Time
+}
+
+// openEntryLog starts a log file for backup and returns a logger that also
+// writes to it. Failing to create the file is logged, not fatal.
+func openEntryLog(backup *Backup, log *slog.Logger, format string) (*slog.Logger, *entryLog) {
+	if !backup.LogFile {
+		return log, nil
+	}
+	scratch := GetEnv("SCRATCH", "/tmp")
+	file, err := os.CreateTemp(scratch, fmt.Sprintf("gobackup_%s_*.log", backup.Name))
+	if err != nil {
+		log.Warn("Unable to create entry log file", "error", err)
+		return log, nil
+	}
+	handler, err := newLogHandler(format, file, slog.LevelDebug)
+	if err != nil {
+		handler = slog.NewTextHandler(file, &slog.HandlerOptions{Level: slog.LevelDebug})
+	}
+	return withHandler(log, handler.WithAttrs([]slog.Attr{slog.String("entry", backup.Name)})), &entryLog{file: file, started: time.Now()}
+}
+
+// finish closes the log file and moves it next to archive
+func (l *entryLog) finish(backup *Backup, archive string, log *slog.Logger) string {
+	if l == nil {
+		return ""
+	}
+	l.file.Close()
+	var final string
+	switch {
+	case archive != "":
+		final = archive + ".log"
+	case checkDestination(backup.Destination) == nil:
+		final = filepath.Join(backup.Destination, fmt.Sprintf("%s_%s.failed.log", backup.Name, l.started.Format("2006.01.02_15.04.05")))
+	default:
+		log.Warn("Destination unavailable, entry log kept in scratch", "log", l.file.Name())
+		return l.file.Name()
+	}
+	if err := moveIntoPlace(l.file.Name(), final, slog.New(slog.DiscardHandler)); err != nil {
+		log.Warn("Unable to store entry log", "log", l.file.Name(), "error", err)
+		return l.file.Name()
+	}
+	log.Info("Stored entry log", "log", final)
+	return final
+}
@@ -0,0 +1,142 @@
+package gobackup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// discardLog is a logger for tests that do not look at the log
+var discardLog = slog.New(slog.DiscardHandler)
+
+func TestNewLogHandlerJSON(t *testing.T) {
+	var out bytes.Buffer
+	handler, err := NewLogHandler("json", "info", &out)
+	if err != nil {
+		t.Fatalf("NewLogHandler() failed: %v", err)
+	}
+	log := slog.New(handler)
+	log.With("entry", "photos").With("phase", "tar").Info("Beginning tar")
+	log.Debug("hidden")
+
+	var record map[string]any
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("output is not a single JSON record: %v: %s", err, out.String())
+	}
+	if record["msg"] != "Beginning tar" || record["entry"] != "photos" || record["phase"] != "tar" || record["level"] != "INFO" {
+		t.Errorf("unexpected record: %v", record)
+	}
+
+	if _, err := NewLogHandler("xml", "info", &out); err == nil {
+		t.Error("NewLogHandler() accepted an unknown format")
+	}
+	if _, err := NewLogHandler("text", "loud", &out); err == nil {
+		t.Error("NewLogHandler() accepted an unknown level")
+	}
+}
+
+func TestLogWriterLogsWholeLines(t *testing.T) {
+	var out bytes.Buffer
+	lw := newLogWriter(slog.New(messageHandler{&out}), slog.LevelWarn)
+
+	lw.Write([]byte("first li"))
+	if out.Len() != 0 {
+		t.Fatalf("partial line logged early: %q", out.String())
+	}
+	lw.Write([]byte("ne\r\n\nsecond line\ntrailing"))
+	lw.Flush()
+
+	expected := "WARN: first line\nWARN: second line\nWARN: trailing\n"
+	if out.String() != expected {
+		t.Errorf("output = %q, want %q", out.String(), expected)
+	}
+}
+
+func TestEntryLogStoredNextToArchive(t *testing.T) {
+	t.Setenv("SCRATCH", t.TempDir())
+	source := t.TempDir()
+	os.WriteFile(filepath.Join(source, "photo.jpg"), []byte("jpeg"), 0644)
+	library := map[string]Backup{
+		"photos": {Type: "tar", Source: source, Destination: t.TempDir(), Retain: 2, ChangeDir: true, LogFile: true},
+	}
+
+	result := execute(context.Background(), "photos", library, DefaultRunOptions(), discardLog)
+	if result.status != StatusSucceeded {
+		t.Fatalf("execute() failed: %v", result.err)
+	}
+	data, err := os.ReadFile(result.archive + ".log")
+	if err != nil {
+		t.Fatalf("entry log not stored next to archive: %v", err)
+	}
+	for _, want := range []string{"Beginning tar", "entry=photos", "phase=tar"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("entry log does not contain %q:\n%s", want, data)
+		}
+	}
+
+	snapshots, _ := listSnapshots(&result.backup)
+	if len(snapshots) != 1 {
+		t.Errorf("log file counted as a snapshot: %v", snapshots)
+	}
+}
+
+func TestEntryLogOfFailedRun(t *testing.T) {
+	t.Setenv("SCRATCH", t.TempDir())
+	dest := t.TempDir()
+	library := map[string]Backup{
+		"photos": {Type: "tar", Source: filepath.Join(t.TempDir(), "missing"), Destination: dest, Retain: 2, LogFile: true},
+	}
+
+	result := execute(context.Background(), "photos", library, DefaultRunOptions(), discardLog)
+	if result.status != StatusFailed {
+		t.Fatal("execute() succeeded for a missing source")
+	}
+	logs, _ := filepath.Glob(filepath.Join(dest, "photos_*.failed.log"))
+	if len(logs) != 1 {
+		t.Fatalf("found %d failure logs, want 1", len(logs))
+	}
+	if leftover, _ := filepath.Glob(filepath.Join(os.Getenv("SCRATCH"), "*.log")); len(leftover) != 0 {
+		t.Errorf("entry log left in scratch: %v", leftover)
+	}
+}
+
+func TestRetentionRemovesEntryLogs(t *testing.T) {
+	dest := t.TempDir()
+	files := []string{
+		"db_2024.01.01_00.00.00.tar", "db_2024.01.01_00.00.00.tar.log",
+		"db_2024.01.02_00.00.00.failed.log",
+		"db_2024.01.03_00.00.00.tar", "db_2024.01.03_00.00.00.tar.log",
+		"db_2024.01.04_00.00.00.failed.log",
+		"db_2024.01.05_00.00.00.tar", "db_2024.01.05_00.00.00.tar.log",
+	}
+	for _, name := range files {
+		os.WriteFile(filepath.Join(dest, name), nil, 0644)
+	}
+	backup := Backup{Name: "db", Destination: dest, Retain: 2}
+
+	removed, err := cleanupOldBackups(&backup, "tar", discardLog)
+	if err != nil {
+		t.Fatalf("cleanupOldBackups() failed: %v", err)
+	}
+	if len(removed) != 1 || filepath.Base(removed[0]) != "db_2024.01.01_00.00.00.tar" {
+		t.Errorf("removed = %v, want only the oldest archive", removed)
+	}
+	entries, _ := os.ReadDir(dest)
+	var kept []string
+	for _, e := range entries {
+		kept = append(kept, e.Name())
+	}
+	expected := []string{
+		"db_2024.01.03_00.00.00.tar", "db_2024.01.03_00.00.00.tar.log",
+		"db_2024.01.04_00.00.00.failed.log",
+		"db_2024.01.05_00.00.00.tar", "db_2024.01.05_00.00.00.tar.log",
+	}
+	if strings.Join(kept, " ") != strings.Join(expected, " ") {
+		t.Errorf("kept %v, want %v", kept, expected)
+	}
+}
@@ -0,0 +1,351 @@
+package gobackup
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// settingsKey is the reserved library key holding global settings rather
+// than a backup entry
+const settingsKey = "_settings"
+
+// newObservers sets up everything the library settings ask to be told
+// about entries and runs, keeping their state in stateDir and reporting
+// their own problems to log
+func newObservers(settings Settings, library map[string]Backup, stateDir string, log *slog.Logger) ([]runObserver, error) {
+	notifier, err := newNotifier(settings.Notifications, notifyStatePath(stateDir))
+	if err != nil {
+		return nil, err
+	}
+	notifier.log = log
+	pinger := newPinger()
+	pinger.log = log
+	history := newHistoryRecorder(historyPath(stateDir))
+	history.log = log
+	observers := []runObserver{notifier, pinger, history}
+	publisher, err := newMQTTPublisher(settings.MQTT, library, mqttStatePath(stateDir))
+	if err != nil {
+		return nil, err
+	}
+	if publisher != nil {
+		publisher.log = log
+		observers = append(observers, publisher)
+	}
+	metrics, err := newMetricsCollector(settings.Metrics, metricsStatePath(stateDir))
+	if err != nil {
+		return nil, err
+	}
+	if metrics != nil {
+		metrics.log = log
+		observers = append(observers, metrics)
+	}
+	return observers, nil
+}
+
+// Statuses an entry of a run ends with
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusSkipped   = "skipped"
+	StatusCancelled = "cancelled"
+)
+
+// outcome records how a single entry of a run ended
+type outcome struct {
+	runID    string
+	entry    string
+	backup   Backup
+	status   string
+	err      error
+	started  time.Time
+	duration time.Duration
+	archive  string
+	size     int64
+	removed  []string  // old backups deleted by retention
+	changed  int       // files tar reported as changed while reading
+	attempts []Attempt // tries at pulling an rsync source
+	output   string    // last lines of output, kept for failed entries
+	warned   []string  // problems that did not fail the entry
+}
+
+// runReport collects the outcomes of one run in the order entries ran
+type runReport struct {
+	started  time.Time
+	finished time.Time
+	outcomes []outcome
+}
+
+// newRunReport orders the outcomes of a run like its entries
+func newRunReport(started time.Time, entries []string, outcomes map[string]outcome) runReport {
+	report := runReport{started: started, finished: time.Now()}
+	for _, entry := range entries {
+		report.outcomes = append(report.outcomes, outcomes[entry])
+	}
+	return report
+}
+
+// blockedBy returns the first selected prerequisite of entry that did not
+// succeed, or an empty string if the entry may run
+func blockedBy(entry string, library map[string]Backup, selected map[string]bool, outcomes map[string]outcome) string {
+	for _, dep := range selectedDependencies(entry, library, selected) {
+		if outcomes[dep].status != StatusSucceeded {
+			return dep
+		}
+	}
+	return ""
+}
+
+// selectedSet returns the entries of a run as a lookup set
+func selectedSet(entries []string) map[string]bool {
+	selected := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		selected[entry] = true
+	}
+	return selected
+}
+
+// execute runs a single entry and records how it went. An entry that fails
+// because ctx was cancelled is recorded as cancelled.
+func execute(ctx context.Context, entry string, library map[string]Backup, opts RunOptions, log *slog.Logger) outcome {
+	result := outcome{runID: opts.runID, entry: entry, backup: library[entry], started: time.Now()}
+	result.backup.Name = entry
+	opts.entryStarted(result.backup)
+	tail := newTailBuffer(outputTailLines)
+	log = withHandler(log, messageHandler{tail})
+	log, entryLog := openEntryLog(&result.backup, log, opts.LogFormat)
+	archive, err := runEntry(ctx, entry, library, opts, log)
+	result.duration = time.Since(result.started)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		result.status, result.err = StatusCancelled, err
+		result.output = tail.String()
+	case err != nil:
+		result.status, result.err = StatusFailed, err
+		result.output = tail.String()
+	default:
+		result.status = StatusSucceeded
+	}
+	if archive != nil {
+		result.removed, result.changed, result.attempts = archive.Removed, archive.ChangedFiles, archive.Attempts
+		result.warned = archive.Warnings
+	}
+	if archive != nil && archive.ArchivePath != "" {
+		result.archive = archive.ArchivePath
+		if info, err := os.Stat(archive.ArchivePath); err == nil {
+			result.size = info.Size()
+		}
+	}
+	entryLog.finish(&result.backup, result.archive, log)
+	opts.entryFinished(result)
+	return result
+}
+
+// skip records that entry did not run because prerequisite dep did not succeed
+func skip(entry, dep string, library map[string]Backup, opts RunOptions, log *slog.Logger) outcome {
+	err := fmt.Errorf("skipped '%s': prerequisite '%s' did not succeed", entry, dep)
+	log.Warn("Skipping entry because a prerequisite did not succeed", "prerequisite", dep)
+	result := outcome{runID: opts.runID, entry: entry, backup: library[entry], status: StatusSkipped, err: err, started: time.Now()}
+	result.backup.Name = entry
+	opts.entryFinished(result)
+	return result
+}
+
+// notStarted records that entry did not start because the run was cancelled
+func notStarted(ctx context.Context, entry string, library map[string]Backup, opts RunOptions, log *slog.Logger) outcome {
+	err := fmt.Errorf("'%s' not started: %w", entry, context.Cause(ctx))
+	log.Warn("Not starting entry because the run was cancelled")
+	result := outcome{runID: opts.runID, entry: entry, backup: library[entry], status: StatusCancelled, err: err, started: time.Now()}
+	result.backup.Name = entry
+	opts.entryFinished(result)
+	return result
+}
+
+// runSequential runs entries one after another in the given order
+func runSequential(ctx context.Context, entries []string, library map[string]Backup, opts RunOptions) map[string]outcome {
+	selected := selectedSet(entries)
+	outcomes := make(map[string]outcome, len(entries))
+	for _, entry := range entries {
+		log := opts.entryLogger(entry)
+		if ctx.Err() != nil {
+			outcomes[entry] = notStarted(ctx, entry, library, opts, log)
+			continue
+		}
+		if dep := blockedBy(entry, library, selected, outcomes); dep != "" {
+			outcomes[entry] = skip(entry, dep, library, opts, log)
+			continue
+		}
+		outcomes[entry] = execute(ctx, entry, library, opts, log)
+	}
+	return outcomes
+}
+
+// runEntry looks up a single library entry and runs it, logging to log. The
+// entry is killed once its Timeout has passed.
+func runEntry(ctx context.Context, entry string, library map[string]Backup, opts RunOptions, log *slog.Logger) (*BackupResult, error) {
+	log.Info("Looking up entry")
+
+	backup, exists := library[entry]
+	if !exists {
+		err := fmt.Errorf("no backup found with name '%s'", entry)
+		log.Error(err.Error())
+		return nil, err
+	}
+	// Set the name from the map key
+	backup.Name = entry
+	backup.stateDir = opts.StateDir
+	timeout, err := entryTimeout(&backup)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+
+	// Make sure no other run is working on this entry or its scratch directory
+	release, err := lockEntry(ctx, &backup, opts.LockWait, log)
+	if err != nil {
+		log.Error(err.Error(), "phase", "lock")
+		return nil, fmt.Errorf("backup '%s' is already running: %w", entry, err)
+	}
+	defer release()
+
+	runCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	progress := opts.progress(log)
+	result, err := withHooks(runCtx, &backup, log, func() (*BackupResult, error) {
+		return runBackup(runCtx, &backup, log, progress)
+	})
+	if err != nil && ctx.Err() == nil && runCtx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s: %w", timeout, err)
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("%s backup failed", backup.Type), "error", err)
+		return result, fmt.Errorf("%s backup failed for '%s': %w", backup.Type, entry, err)
+	}
+	return result, nil
+}
+
+// entryTimeout returns the entry's Timeout, 0 if it has none
+func entryTimeout(backup *Backup) (time.Duration, error) {
+	if backup.Timeout == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(backup.Timeout)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid Timeout %q", backup.Timeout)
+	}
+	return timeout, nil
+}
+
+// runBackup dispatches to the registered implementation for the entry's
+// Type
+func runBackup(ctx context.Context, backup *Backup, log *slog.Logger, progress ProgressFunc) (*BackupResult, error) {
+	t, err := backupTypeFor(backup.Type)
+	if err != nil {
+		return nil, err
+	}
+	return t.Run(ctx, backup, log, progress)
+}
+
+// lockEntry takes the entry lock and, for types that keep one, the scratch
+// directory lock. The returned function releases both.
+func lockEntry(ctx context.Context, backup *Backup, wait time.Duration, log *slog.Logger) (func(), error) {
+	paths := []string{entryLockPath(backup)}
+	if dir := resourcesOf(backup).ScratchDir; dir != "" {
+		paths = append(paths, scratchLockPath(dir))
+	}
+
+	var locks []*fileLock
+	release := func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			if err := locks[i].Release(); err != nil {
+				log.Warn(err.Error(), "phase", "lock")
+			}
+		}
+	}
+	for _, path := range paths {
+		lock, err := acquireLock(ctx, path, wait)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		locks = append(locks, lock)
+	}
+	return release, nil
+}
+
+// runParallel runs entries concurrently within the limits in opts. An entry
+// starts once all of its selected prerequisites have finished, so independent
+// branches of the dependency graph run side by side. Log records of each
+// entry carry its name. Once ctx is cancelled no further entries start.
+func runParallel(ctx context.Context, entries []string, library map[string]Backup, opts RunOptions) map[string]outcome {
+	selected := selectedSet(entries)
+	lim := opts.limiter
+	if lim == nil {
+		lim = newLimiter(opts.Jobs)
+	}
+	done := make(map[string]chan struct{}, len(entries))
+	for _, entry := range entries {
+		done[entry] = make(chan struct{})
+	}
+	var (
+		resMu    sync.Mutex
+		wg       sync.WaitGroup
+		outcomes = make(map[string]outcome, len(entries))
+	)
+	for _, entry := range entries {
+		var keys []string
+		if backup, exists := library[entry]; exists {
+			keys = resourceKeys(&backup)
+			for _, key := range keys {
+				switch {
+				case strings.HasPrefix(key, "dest:"):
+					lim.setLimit(key, opts.PerDestination)
+				case strings.HasPrefix(key, "host:"):
+					lim.setLimit(key, opts.PerHost)
+				}
+			}
+		}
+
+		wg.Add(1)
+		go func(entry string, keys []string) {
+			defer wg.Done()
+			defer close(done[entry])
+
+			for _, dep := range selectedDependencies(entry, library, selected) {
+				<-done[dep]
+			}
+
+			log := opts.entryLogger(entry)
+
+			resMu.Lock()
+			dep := blockedBy(entry, library, selected, outcomes)
+			resMu.Unlock()
+			var result outcome
+			switch {
+			case ctx.Err() != nil:
+				result = notStarted(ctx, entry, library, opts, log)
+			case dep != "":
+				result = skip(entry, dep, library, opts, log)
+			case !lim.acquire(ctx, keys):
+				result = notStarted(ctx, entry, library, opts, log)
+			default:
+				result = execute(ctx, entry, library, opts, log)
+				lim.release(keys)
+			}
+
+			resMu.Lock()
+			outcomes[entry] = result
+			resMu.Unlock()
+		}(entry, keys)
+	}
+	wg.Wait()
+	return outcomes
+}
@@ -0,0 +1,275 @@
+package gobackup
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogicWithValidJSON(t *testing.T) {
+	// Create a temporary JSON file for testing
+	testJSON := `{
+		"test_backup": {
+			"Source": "/tmp/test_source",
+			"Destination": "/tmp/test_dest",
+			"Retain": 3,
+			"Verbose": false,
+			"Type": "tar",
+			"ChangeDir": true
+		}
+	}`
+
+	// Create temporary file
+	tmpFile, err := os.CreateTemp("", "test_library_*.json")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	// Write test data
+	if _, err := tmpFile.WriteString(testJSON); err != nil {
+		t.Fatalf("Failed to write test data: %v", err)
+	}
+	tmpFile.Close()
+
+	// Test JSON parsing
+	jsonData, err := os.ReadFile(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to read test file: %v", err)
+	}
+
+	var library map[string]Backup
+	err = json.Unmarshal(jsonData, &library)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal JSON: %v", err)
+	}
+
+	// Verify the backup was loaded correctly
+	backup, exists := library["test_backup"]
+	if !exists {
+		t.Fatal("Expected backup 'test_backup' not found in library")
+	}
+
+	if backup.Source != "/tmp/test_source" {
+		t.Errorf("Source = %v, want %v", backup.Source, "/tmp/test_source")
+	}
+	if backup.Destination != "/tmp/test_dest" {
+		t.Errorf("Destination = %v, want %v", backup.Destination, "/tmp/test_dest")
+	}
+	if backup.Retain != 3 {
+		t.Errorf("Retain = %v, want %v", backup.Retain, 3)
+	}
+	if backup.Type != "tar" {
+		t.Errorf("Type = %v, want %v", backup.Type, "tar")
+	}
+}
+
+func TestLogicWithInvalidJSON(t *testing.T) {
+	// Create a temporary JSON file with invalid JSON
+	invalidJSON := `{
+		"test_backup": {
+			"Source": "/tmp/test_source",
+			"Destination": "/tmp/test_dest",
+			"Retain": 3,
+			"Verbose": false,
+			"Type": "tar",
+			"ChangeDir": true
+		}
+		// Missing closing brace
+	`
+
+	// Create temporary file
+	tmpFile, err := os.CreateTemp("", "test_library_invalid_*.json")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	// Write invalid JSON
+	if _, err := tmpFile.WriteString(invalidJSON); err != nil {
+		t.Fatalf("Failed to write test data: %v", err)
+	}
+	tmpFile.Close()
+
+	// Test JSON parsing
+	jsonData, err := os.ReadFile(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to read test file: %v", err)
+	}
+
+	var library map[string]Backup
+	err = json.Unmarshal(jsonData, &library)
+	if err == nil {
+		t.Error("Expected JSON unmarshaling to fail with invalid JSON")
+	}
+}
+
+func TestLogicWithMultipleEntries(t *testing.T) {
+	// Test parsing multiple entries from command line
+	testCases := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name:     "single entry",
+			input:    "test",
+			expected: []string{"test"},
+		},
+		{
+			name:     "multiple entries",
+			input:    "test1,test2,test3",
+			expected: []string{"test1", "test2", "test3"},
+		},
+		{
+			name:     "single entry with comma",
+			input:    "test,",
+			expected: []string{"test", ""},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var entries []string
+			if strings.Contains(tc.input, ",") {
+				entries = strings.Split(tc.input, ",")
+			} else {
+				entries = []string{tc.input}
+			}
+
+			if len(entries) != len(tc.expected) {
+				t.Errorf("Expected %d entries, got %d", len(tc.expected), len(entries))
+			}
+
+			for i, entry := range entries {
+				if entry != tc.expected[i] {
+					t.Errorf("Entry %d = %v, want %v", i, entry, tc.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLoadLibrarySettings(t *testing.T) {
+	libraryFile := filepath.Join(t.TempDir(), "library.json")
+	libraryJSON := `{
+		"_settings": {
+			"Notifications": [{"Type": "ntfy", "Topic": "backups", "On": "change"}]
+		},
+		"photos": {"Source": "/srv/photos", "Destination": "/backups", "Retain": 3, "Type": "tar"}
+	}`
+	if err := os.WriteFile(libraryFile, []byte(libraryJSON), 0644); err != nil {
+		t.Fatalf("Failed to write library: %v", err)
+	}
+
+	lib, err := LoadLibrary(libraryFile)
+	if err != nil {
+		t.Fatalf("LoadLibrary() failed: %v", err)
+	}
+	library, settings := lib.Entries, lib.Settings
+	if _, exists := library["_settings"]; exists {
+		t.Error("settings were loaded as a backup entry")
+	}
+	if len(library) != 1 || library["photos"].Source != "/srv/photos" {
+		t.Errorf("library = %+v", library)
+	}
+	if len(settings.Notifications) != 1 || settings.Notifications[0].Topic != "backups" {
+		t.Errorf("settings = %+v", settings)
+	}
+}
+
+// processRunning reports whether pid is alive and not a zombie waiting to be
+// reaped
+func processRunning(pid int) bool {
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+	_, rest, _ := strings.Cut(string(stat), ") ")
+	return !strings.HasPrefix(rest, "Z")
+}
+
+func TestCancelledRunKillsEntryAndCleansUp(t *testing.T) {
+	scratch := t.TempDir()
+	t.Setenv("SCRATCH", scratch)
+	t.Setenv("GOBACKUP_STATE", t.TempDir())
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	library := map[string]Backup{
+		"slow":  {Type: "command", Command: "sleep 30 & echo $! > " + pidFile + "; wait", Destination: t.TempDir(), Retain: 1},
+		"later": {Type: "tar", Source: t.TempDir(), Destination: t.TempDir(), Retain: 1, ChangeDir: true},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for {
+			if _, err := os.Stat(pidFile); err == nil {
+				cancel()
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	start := time.Now()
+	outcomes := runSequential(ctx, []string{"slow", "later"}, library, DefaultRunOptions())
+	if time.Since(start) > 10*time.Second {
+		t.Error("run was not stopped when cancelled")
+	}
+
+	for _, entry := range []string{"slow", "later"} {
+		if outcomes[entry].status != StatusCancelled {
+			t.Errorf("%s status = %s (%v), want %s", entry, outcomes[entry].status, outcomes[entry].err, StatusCancelled)
+		}
+	}
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	// The process group is killed, so children of the sh -c go too
+	for i := 0; i < 100 && processRunning(pid); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if processRunning(pid) {
+		t.Errorf("child process %d still running after cancellation", pid)
+	}
+	left, _ := os.ReadDir(scratch)
+	for _, f := range left {
+		t.Errorf("left behind in scratch: %s", f.Name())
+	}
+}
+
+func TestEntryTimeout(t *testing.T) {
+	t.Setenv("SCRATCH", t.TempDir())
+	t.Setenv("GOBACKUP_STATE", t.TempDir())
+	hookLog := filepath.Join(t.TempDir(), "hooks.log")
+	library := map[string]Backup{
+		"slow": {Type: "command", Command: "sleep 30", Destination: t.TempDir(), Retain: 1,
+			Timeout: "200ms", PostHooks: []string{"echo post >> " + hookLog}},
+	}
+
+	start := time.Now()
+	outcomes := runSequential(context.Background(), []string{"slow"}, library, DefaultRunOptions())
+	if time.Since(start) > 10*time.Second {
+		t.Error("entry was not stopped at its timeout")
+	}
+	result := outcomes["slow"]
+	if result.status != StatusFailed || result.err == nil || !strings.Contains(result.err.Error(), "timed out after 200ms") {
+		t.Errorf("outcome = %s (%v), want failed after timing out", result.status, result.err)
+	}
+	// Post hooks still run once the entry has timed out
+	if lines := readHookLog(t, hookLog); len(lines) != 1 || lines[0] != "post" {
+		t.Errorf("post hooks = %v, want [post]", lines)
+	}
+
+	library["slow"] = Backup{Type: "command", Command: "true", Destination: t.TempDir(), Timeout: "soon"}
+	outcomes = runSequential(context.Background(), []string{"slow"}, library, DefaultRunOptions())
+	if err := outcomes["slow"].err; err == nil || !strings.Contains(err.Error(), `invalid Timeout "soon"`) {
+		t.Errorf("error = %v, want invalid Timeout", err)
+	}
+}
@@ -0,0 +1,214 @@
+package gobackup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Values of gobackup_last_exit_status
+const (
+	exitStatusSucceeded = 0
+	exitStatusFailed    = 1
+	exitStatusSkipped   = 2
+	exitStatusCancelled = 3
+)
+
+// entryMetrics is what is remembered about an entry between runs. The
+// counters are kept in the state file so they only ever increase.
+type entryMetrics struct {
+	LastRun             float64 `json:"LastRun"`
+	LastSuccess         float64 `json:"LastSuccess"`
+	Duration            float64 `json:"Duration"`
+	ArchiveSize         int64   `json:"ArchiveSize"`
+	Snapshots           int     `json:"Snapshots"`
+	ExitStatus          int     `json:"ExitStatus"`
+	ChangedFileWarnings int64   `json:"ChangedFileWarnings"`
+	RetentionDeletions  int64   `json:"RetentionDeletions"`
+}
+
+// metricsCollector keeps Prometheus metrics about every entry that has run,
+// writes them to the textfile after each entry and serves them over HTTP.
+// Other gobackup processes may share the state file, so it is read again and
+// updated under a file lock every time an entry finishes.
+type metricsCollector struct {
+	textfile  string
+	statePath string
+	log       *slog.Logger
+
+	mu      sync.Mutex
+	entries map[string]*entryMetrics
+}
+
+// newMetricsCollector returns nil if metrics are not configured
+func newMetricsCollector(cfg *MetricsSettings, statePath string) (*metricsCollector, error) {
+	if cfg == nil || (cfg.Textfile == "" && cfg.Listen == "") {
+		return nil, nil
+	}
+	if cfg.Textfile != "" && !strings.HasSuffix(cfg.Textfile, ".prom") {
+		return nil, fmt.Errorf("Metrics: Textfile %q must end in .prom for the textfile collector", cfg.Textfile)
+	}
+	m := &metricsCollector{textfile: cfg.Textfile, statePath: statePath, log: slog.Default(), entries: make(map[string]*entryMetrics)}
+	m.load()
+	return m, nil
+}
+
+// load replaces the metrics in memory with those of the state file, keeping
+// them if it cannot be read
+func (m *metricsCollector) load() {
+	data, err := os.ReadFile(m.statePath)
+	if err != nil {
+		return
+	}
+	entries := make(map[string]*entryMetrics)
+	if err := json.Unmarshal(data, &entries); err != nil {
+		m.log.Warn("Ignoring unreadable metrics state", "path", m.statePath, "error", err)
+		return
+	}
+	m.entries = entries
+}
+
+func metricsStatePath(dir string) string {
+	return statePath(dir, "metrics.json")
+}
+
+func (m *metricsCollector) entryStarted(backup Backup) {}
+
+func (m *metricsCollector) entryFinished(result outcome) {
+	snapshots := -1
+	if list, err := ListSnapshots(result.backup); err == nil {
+		snapshots = len(list)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.statePath == "" {
+		m.record(result, snapshots)
+		m.writeTextfile()
+		return
+	}
+	err := withFileLock(m.statePath+".lock", func() error {
+		m.load()
+		m.record(result, snapshots)
+		// The textfile is written under the lock too, so a process holding
+		// older counters cannot overwrite it
+		defer m.writeTextfile()
+		data, err := json.MarshalIndent(m.entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(m.statePath, data, 0644)
+	})
+	if err != nil {
+		m.log.Warn("Failed to save metrics state", "error", err)
+	}
+}
+
+// record adds a finished entry to the metrics, with the number of snapshots
+// it now has or -1 if they could not be listed. The caller must hold m.mu.
+func (m *metricsCollector) record(result outcome, snapshots int) {
+	e := m.entries[result.entry]
+	if e == nil {
+		e = &entryMetrics{}
+		m.entries[result.entry] = e
+	}
+	finished := result.started.Add(result.duration)
+	e.LastRun = unixSeconds(finished)
+	e.Duration = result.duration.Seconds()
+	switch result.status {
+	case StatusSucceeded:
+		e.ExitStatus = exitStatusSucceeded
+		e.LastSuccess = e.LastRun
+	case StatusSkipped:
+		e.ExitStatus = exitStatusSkipped
+	case StatusCancelled:
+		e.ExitStatus = exitStatusCancelled
+	default:
+		e.ExitStatus = exitStatusFailed
+	}
+	if result.archive != "" {
+		e.ArchiveSize = result.size
+	}
+	if snapshots >= 0 {
+		e.Snapshots = snapshots
+	}
+	e.ChangedFileWarnings += int64(result.changed)
+	e.RetentionDeletions += int64(len(result.removed))
+}
+
+// writeTextfile writes the metrics to the textfile, if configured. The
+// caller must hold m.mu.
+func (m *metricsCollector) writeTextfile() {
+	if m.textfile == "" {
+		return
+	}
+	var b bytes.Buffer
+	m.write(&b)
+	if err := writeFileAtomic(m.textfile, b.Bytes(), 0644); err != nil {
+		m.log.Warn("Failed to write metrics textfile", "path", m.textfile, "error", err)
+	}
+}
+
+func (m *metricsCollector) runFinished(report runReport) {}
+
+// metricDefinitions describes every metric family in exposition order
+var metricDefinitions = []struct {
+	name, kind, help string
+	value            func(*entryMetrics) float64
+}{
+	{"gobackup_last_run_timestamp_seconds", "gauge", "Unix time the last run of the entry finished.",
+		func(e *entryMetrics) float64 { return e.LastRun }},
+	{"gobackup_last_success_timestamp_seconds", "gauge", "Unix time the last successful run of the entry finished.",
+		func(e *entryMetrics) float64 { return e.LastSuccess }},
+	{"gobackup_last_duration_seconds", "gauge", "Duration of the last run of the entry.",
+		func(e *entryMetrics) float64 { return e.Duration }},
+	{"gobackup_last_archive_size_bytes", "gauge", "Size of the last archive written for the entry.",
+		func(e *entryMetrics) float64 { return float64(e.ArchiveSize) }},
+	{"gobackup_snapshots", "gauge", "Number of backups of the entry in its destination.",
+		func(e *entryMetrics) float64 { return float64(e.Snapshots) }},
+	{"gobackup_last_exit_status", "gauge", "Outcome of the last run of the entry: 0 succeeded, 1 failed, 2 skipped, 3 cancelled.",
+		func(e *entryMetrics) float64 { return float64(e.ExitStatus) }},
+	{"gobackup_tar_changed_file_warnings_total", "counter", "Files tar reported as changed while reading them.",
+		func(e *entryMetrics) float64 { return float64(e.ChangedFileWarnings) }},
+	{"gobackup_retention_deletions_total", "counter", "Old backups deleted by retention.",
+		func(e *entryMetrics) float64 { return float64(e.RetentionDeletions) }},
+}
+
+// write renders the metrics in the Prometheus text exposition format.
+// The caller must hold m.mu.
+func (m *metricsCollector) write(w io.Writer) {
+	names := sortedKeys(m.entries)
+	for _, def := range metricDefinitions {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", def.name, def.help, def.name, def.kind)
+		for _, name := range names {
+			fmt.Fprintf(w, "%s{entry=\"%s\"} %s\n", def.name, escapeLabel(name), strconv.FormatFloat(def.value(m.entries[name]), 'f', -1, 64))
+		}
+	}
+}
+
+// ServeHTTP serves the metrics on /metrics
+func (m *metricsCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var b bytes.Buffer
+	m.mu.Lock()
+	m.write(&b)
+	m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(b.Bytes())
+}
+
+// escapeLabel escapes a Prometheus label value
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
@@ -0,0 +1,125 @@
+package gobackup
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsTextfile(t *testing.T) {
+	t.Setenv("SCRATCH", t.TempDir())
+	dir := t.TempDir()
+	dest := t.TempDir()
+	// Two older backups of photos, one of which retention removes
+	for _, name := range []string{"photos_2020.01.01_00.00.00.tar.gz", "photos_2020.01.02_00.00.00.tar.gz", "photos_notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dest, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	library := map[string]Backup{
+		"photos": {Type: "tar", Source: t.TempDir(), Destination: dest, Retain: 2, ChangeDir: true},
+		"broken": {Type: "tar", Source: t.TempDir(), Destination: "/nonexistent/gobackup", Retain: 1, ChangeDir: true},
+	}
+	textfile := filepath.Join(dir, "textfile", "gobackup.prom")
+	cfg := &MetricsSettings{Textfile: textfile}
+	statePath := filepath.Join(dir, "metrics.json")
+
+	m, err := newMetricsCollector(cfg, statePath)
+	if err != nil {
+		t.Fatalf("newMetricsCollector() failed: %v", err)
+	}
+	opts := DefaultRunOptions()
+	opts.observers = []runObserver{m}
+	before := time.Now()
+	outcomes := runSequential(context.Background(), []string{"photos", "broken"}, library, opts)
+
+	data, err := os.ReadFile(textfile)
+	if err != nil {
+		t.Fatalf("textfile not written: %v", err)
+	}
+	metrics := string(data)
+	for _, want := range []string{
+		"# TYPE gobackup_last_success_timestamp_seconds gauge",
+		"# TYPE gobackup_retention_deletions_total counter",
+		`gobackup_snapshots{entry="photos"} 2`,
+		`gobackup_last_exit_status{entry="photos"} 0`,
+		`gobackup_last_exit_status{entry="broken"} 1`,
+		`gobackup_last_success_timestamp_seconds{entry="broken"} 0`,
+		`gobackup_retention_deletions_total{entry="photos"} 1`,
+		`gobackup_last_archive_size_bytes{entry="photos"} ` + strconv.FormatInt(outcomes["photos"].size, 10),
+	} {
+		if !strings.Contains(metrics, want+"\n") {
+			t.Errorf("textfile does not contain %q:\n%s", want, metrics)
+		}
+	}
+	if m.entries["photos"].LastSuccess < unixSeconds(before) {
+		t.Errorf("LastSuccess = %v, want after %v", m.entries["photos"].LastSuccess, unixSeconds(before))
+	}
+
+	// Counters carry over to the next run through the state file
+	m, _ = newMetricsCollector(cfg, statePath)
+	m.entryFinished(outcome{entry: "photos", backup: library["photos"], status: StatusSucceeded, started: time.Now(), changed: 3, removed: []string{"a"}})
+	m.entryFinished(outcome{entry: "photos", backup: library["photos"], status: StatusSucceeded, started: time.Now(), changed: 2})
+	data, _ = os.ReadFile(textfile)
+	for _, want := range []string{
+		`gobackup_tar_changed_file_warnings_total{entry="photos"} 5`,
+		`gobackup_retention_deletions_total{entry="photos"} 2`,
+		`gobackup_last_exit_status{entry="broken"} 1`,
+	} {
+		if !strings.Contains(string(data), want+"\n") {
+			t.Errorf("textfile does not contain %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestMetricsMergeConcurrentProcesses(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "metrics.json")
+	cfg := &MetricsSettings{Listen: ":0"}
+
+	// A daemon and a manual run both loaded the state before either wrote
+	daemon, _ := newMetricsCollector(cfg, statePath)
+	manual, _ := newMetricsCollector(cfg, statePath)
+	daemon.entryFinished(outcome{entry: "photos", status: StatusSucceeded, started: time.Now(), changed: 2})
+	manual.entryFinished(outcome{entry: "photos", status: StatusSucceeded, started: time.Now(), changed: 3})
+	manual.entryFinished(outcome{entry: "db", status: StatusFailed, started: time.Now()})
+	daemon.entryFinished(outcome{entry: "photos", status: StatusSucceeded, started: time.Now(), removed: []string{"a"}})
+
+	m, _ := newMetricsCollector(cfg, statePath)
+	if e := m.entries["photos"]; e == nil || e.ChangedFileWarnings != 5 || e.RetentionDeletions != 1 {
+		t.Errorf("photos metrics = %+v, want the counters of both processes", e)
+	}
+	if e := m.entries["db"]; e == nil || e.ExitStatus != exitStatusFailed {
+		t.Errorf("db metrics = %+v, want those of the manual run", e)
+	}
+}
+
+func TestMetricsServeHTTP(t *testing.T) {
+	m, err := newMetricsCollector(&MetricsSettings{Listen: ":0"}, filepath.Join(t.TempDir(), "metrics.json"))
+	if err != nil {
+		t.Fatalf("newMetricsCollector() failed: %v", err)
+	}
+	m.entryFinished(outcome{entry: `odd "name"`, status: StatusSkipped, started: time.Now()})
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %s", ct)
+	}
+	if want := `gobackup_last_exit_status{entry="odd \"name\""} 2`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("response does not contain %q:\n%s", want, rec.Body.String())
+	}
+}
+
+func TestNewMetricsCollectorConfig(t *testing.T) {
+	if m, err := newMetricsCollector(nil, ""); m != nil || err != nil {
+		t.Errorf("newMetricsCollector(nil) = %v, %v, want nil, nil", m, err)
+	}
+	if _, err := newMetricsCollector(&MetricsSettings{Textfile: "/var/lib/node_exporter/gobackup.txt"}, ""); err == nil {
+		t.Error("newMetricsCollector() accepted a textfile without .prom")
+	}
+}
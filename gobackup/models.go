@@ -0,0 +1,106 @@
+package gobackup
+
+// Backup is one entry of a library. Its Type selects the registered
+// BackupType that runs it.
+type Backup struct {
+	Name            string   `json:"Name"`
+	Source          string   `json:"Source"`
+	Destination     string   `json:"Destination"`
+	Retain          int      `json:"Retain"`
+	User            string   `json:"User"`
+	Verbose         bool     `json:"Verbose"`
+	Type            string   `json:"Type"`
+	ChangeDir       bool     `json:"ChangeDir"`
+	CompressionType string   `json:"CompressionType"`
+	Excludes        []string `json:"Excludes"`
+	DependsOn       []string `json:"DependsOn"`
+	Schedule        string   `json:"Schedule"`
+	PreHooks        []string `json:"PreHooks"`
+	PostHooks       []string `json:"PostHooks"`
+	OnSuccess       []string `json:"OnSuccess"`
+	OnFailure       []string `json:"OnFailure"`
+	PreHookPolicy   string   `json:"PreHookPolicy"`
+	HookTimeout     string   `json:"HookTimeout"`
+	// Run on the rsync source host over SSH before and after the pull
+	RemotePreCommands  []string `json:"RemotePreCommands"`
+	RemotePostCommands []string `json:"RemotePostCommands"`
+	// Type "command": stdout of Command is stored as the backup
+	Command         string `json:"Command"`
+	CommandFileName string `json:"CommandFileName"`
+	CommandArchive  bool   `json:"CommandArchive"`
+	// Dead man's switch pinged on start, success (PingURL) and failure
+	PingURL string `json:"PingURL"`
+	// Keep the log of each run next to its archive
+	LogFile bool `json:"LogFile"`
+	// `status` reports the entry overdue once its last success is older
+	MaxAge string `json:"MaxAge"`
+	// The entry, hooks included, is killed and fails once it runs longer
+	Timeout string `json:"Timeout"`
+	// Transient rsync failures are retried up to RetryAttempts tries in
+	// total, waiting RetryBackoff (default 30s) doubled for every retry
+	RetryAttempts int    `json:"RetryAttempts"`
+	RetryBackoff  string `json:"RetryBackoff"`
+
+	stateDir string // StateDir of the run, set while the entry runs
+}
+
+// Settings holds library-wide configuration, stored under the reserved
+// "_settings" key of the library
+type Settings struct {
+	Notifications []NotificationTarget `json:"Notifications"`
+	MQTT          *MQTTSettings        `json:"MQTT"`
+	Metrics       *MetricsSettings     `json:"Metrics"`
+	Logging       []LogSink            `json:"Logging"`
+}
+
+// LogSink sends log records to journald or a syslog daemon in addition to
+// stderr
+type LogSink struct {
+	Type       string `json:"Type"`       // journald or syslog
+	Address    string `json:"Address"`    // syslog: unix:///dev/log (default), udp://host:514 or tcp://host:514
+	Facility   string `json:"Facility"`   // syslog facility, default daemon
+	Identifier string `json:"Identifier"` // SYSLOG_IDENTIFIER / APP-NAME, default gobackup
+	Level      string `json:"Level"`      // minimum level sent: debug, info (default), warn or error
+}
+
+// MetricsSettings configures Prometheus metrics about entries
+type MetricsSettings struct {
+	Textfile string `json:"Textfile"` // .prom file for the node_exporter textfile collector
+	Listen   string `json:"Listen"`   // address the daemon serves /metrics, /progress and /events on, e.g. :9842
+}
+
+// MQTTSettings configures publishing of entry status to an MQTT broker.
+// Password accepts a secret reference like NotificationTarget.
+type MQTTSettings struct {
+	Broker          string `json:"Broker"`          // tcp://host:1883 or ssl://host:8883
+	Username        string `json:"Username"`        // optional broker username
+	Password        string `json:"Password"`        // optional broker password
+	ClientID        string `json:"ClientID"`        // default gobackup-<host>
+	TopicPrefix     string `json:"TopicPrefix"`     // default gobackup
+	QoS             int    `json:"QoS"`             // 0 or 1 (default)
+	Discovery       bool   `json:"Discovery"`       // publish Home Assistant discovery payloads
+	DiscoveryPrefix string `json:"DiscoveryPrefix"` // default homeassistant
+}
+
+// NotificationTarget is a webhook, ntfy, Gotify or email destination for
+// backup outcome notifications. Token and Password accept a secret
+// reference: "env:NAME" or "file:/path" instead of the secret itself.
+type NotificationTarget struct {
+	Type     string            `json:"Type"`     // webhook, ntfy, gotify or email
+	URL      string            `json:"URL"`      // webhook URL, server base URL or smtp://host:port
+	Topic    string            `json:"Topic"`    // ntfy topic
+	Token    string            `json:"Token"`    // ntfy access token or Gotify application token
+	Priority int               `json:"Priority"` // ntfy/Gotify priority
+	On       string            `json:"On"`       // failure (default, always for email), success, always or change
+	Scope    string            `json:"Scope"`    // entry (default, run for email), run or both
+	Entries  []string          `json:"Entries"`  // only notify about these entries
+	Title    string            `json:"Title"`    // template for the title or email subject
+	Message  string            `json:"Message"`  // template for the message text or email body
+	Body     string            `json:"Body"`     // template for the webhook JSON body
+	Headers  map[string]string `json:"Headers"`  // extra HTTP headers
+	From     string            `json:"From"`     // email sender, default gobackup@<host>
+	To       []string          `json:"To"`       // email recipients
+	TLS      string            `json:"TLS"`      // email: starttls (default), tls or none
+	Username string            `json:"Username"` // SMTP username
+	Password string            `json:"Password"` // SMTP password
+}
@@ -0,0 +1,511 @@
+package gobackup
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// mqttTimeout bounds a whole publish session, from connecting to the
+	// broker until the last message is acknowledged
+	mqttTimeout = 10 * time.Second
+	// mqttFlushTimeout is how long the end of a run waits for states still
+	// being published before giving up on them
+	mqttFlushTimeout = 15 * time.Second
+)
+
+// MQTT 3.1.1 control packet types, already shifted into the high nibble
+const (
+	mqttConnect    byte = 1 << 4
+	mqttConnack    byte = 2 << 4
+	mqttPublish    byte = 3 << 4
+	mqttPuback     byte = 4 << 4
+	mqttDisconnect byte = 14 << 4
+)
+
+// mqttEntryState is the retained JSON state published for every entry.
+// Timestamps are null until known so Home Assistant shows them as unknown.
+type mqttEntryState struct {
+	Status      string     `json:"status"`
+	LastRun     *time.Time `json:"last_run"`
+	LastSuccess *time.Time `json:"last_success"`
+	Duration    float64    `json:"duration"`
+	Size        int64      `json:"size"`
+	Archive     string     `json:"archive,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// mqttPublisher publishes the state of every entry as a retained message on
+// <TopicPrefix>/<entry>/state when it starts and finishes. With Discovery,
+// Home Assistant discovery payloads for every library entry are published
+// the first time the publisher talks to the broker.
+//
+// States are published in order from a goroutine of their own, so an
+// unreachable broker does not hold up the entries. The end of the run waits
+// for them, up to mqttFlushTimeout.
+type mqttPublisher struct {
+	cfg       MQTTSettings
+	entries   []string
+	statePath string
+	host      string
+	log       *slog.Logger
+	queue     sendQueue[mqttUpdate]
+
+	mu         sync.Mutex
+	state      map[string]mqttEntryState
+	discovered bool
+}
+
+// mqttUpdate is the state of an entry waiting to be published
+type mqttUpdate struct {
+	entry   string
+	payload []byte
+}
+
+// newMQTTPublisher validates the MQTT settings. It returns nil if MQTT is
+// not configured.
+func newMQTTPublisher(cfg *MQTTSettings, library map[string]Backup, statePath string) (*mqttPublisher, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if _, _, err := mqttAddress(cfg.Broker); err != nil {
+		return nil, fmt.Errorf("MQTT: %w", err)
+	}
+	if cfg.QoS != 0 && cfg.QoS != 1 {
+		return nil, fmt.Errorf("MQTT: invalid QoS %d (supported: 0, 1)", cfg.QoS)
+	}
+	host, _ := os.Hostname()
+	p := &mqttPublisher{cfg: *cfg, entries: sortedKeys(library), statePath: statePath, host: host, log: slog.Default()}
+	p.queue.deliver = p.send
+	if p.cfg.ClientID == "" {
+		p.cfg.ClientID = "gobackup-" + host
+	}
+	if p.cfg.TopicPrefix == "" {
+		p.cfg.TopicPrefix = "gobackup"
+	}
+	p.cfg.TopicPrefix = strings.TrimSuffix(p.cfg.TopicPrefix, "/")
+	if p.cfg.DiscoveryPrefix == "" {
+		p.cfg.DiscoveryPrefix = "homeassistant"
+	}
+	return p, nil
+}
+
+func mqttStatePath(dir string) string {
+	return statePath(dir, "mqtt.json")
+}
+
+func (p *mqttPublisher) entryStarted(backup Backup) {
+	now := time.Now()
+	p.update(backup.Name, func(st *mqttEntryState) {
+		st.Status = "running"
+		st.LastRun = &now
+		st.Error = ""
+	})
+}
+
+func (p *mqttPublisher) entryFinished(result outcome) {
+	p.update(result.entry, func(st *mqttEntryState) {
+		st.Status = result.status
+		if result.status == StatusSkipped {
+			started := result.started
+			st.LastRun = &started
+		}
+		if result.status == StatusSucceeded {
+			finished := result.started.Add(result.duration)
+			st.LastSuccess = &finished
+		}
+		st.Duration = result.duration.Round(time.Millisecond).Seconds()
+		// Size and archive describe the latest backup that was written
+		if result.archive != "" {
+			st.Archive, st.Size = result.archive, result.size
+		}
+		st.Error = ""
+		if result.err != nil {
+			st.Error = result.err.Error()
+		}
+	})
+}
+
+func (p *mqttPublisher) runFinished(report runReport) {
+	p.flush(mqttFlushTimeout)
+}
+
+// close publishes the states still queued. Connections do not outlive a
+// publish, so there is nothing else to release.
+func (p *mqttPublisher) close() {
+	p.flush(mqttFlushTimeout)
+}
+
+// flush waits up to timeout for the queued states to be published, then
+// drops those that are left
+func (p *mqttPublisher) flush(timeout time.Duration) {
+	if dropped := p.queue.flush(timeout); dropped > 0 {
+		p.log.Warn("Dropping MQTT states that could not be published in time", "broker", p.cfg.Broker, "count", dropped)
+	}
+}
+
+// update changes the stored state of entry and queues it for publishing
+func (p *mqttPublisher) update(entry string, change func(*mqttEntryState)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state == nil {
+		p.state = make(map[string]mqttEntryState)
+		if data, err := os.ReadFile(p.statePath); err == nil {
+			json.Unmarshal(data, &p.state)
+		}
+	}
+	st := p.state[entry]
+	change(&st)
+	p.state[entry] = st
+	if p.statePath != "" {
+		data, _ := json.MarshalIndent(p.state, "", "  ")
+		if err := writeFileAtomic(p.statePath, data, 0644); err != nil {
+			p.log.Warn("Failed to save MQTT state", "error", err)
+		}
+	}
+
+	payload, err := json.Marshal(st)
+	if err != nil {
+		p.log.Warn("Failed to encode MQTT state", "error", err)
+		return
+	}
+	p.queue.enqueue(mqttUpdate{entry: entry, payload: payload})
+}
+
+// send publishes the state of an entry, preceded by the discovery payloads
+// until they have reached the broker once
+func (p *mqttPublisher) send(ctx context.Context, u mqttUpdate) {
+	p.mu.Lock()
+	discover := p.cfg.Discovery && !p.discovered
+	p.mu.Unlock()
+	var messages []mqttMessage
+	if discover {
+		var err error
+		if messages, err = p.discoveryMessages(); err != nil {
+			p.log.Warn("Failed to encode MQTT discovery", "error", err)
+		}
+	}
+	messages = append(messages, mqttMessage{Topic: p.stateTopic(u.entry), Payload: u.payload})
+	if err := p.publish(ctx, messages); err != nil {
+		p.log.Warn("MQTT publish failed", "broker", p.cfg.Broker, "entry", u.entry, "error", err)
+		return
+	}
+	if discover {
+		p.mu.Lock()
+		p.discovered = true
+		p.mu.Unlock()
+	}
+}
+
+// mqttMessage is a retained message to publish
+type mqttMessage struct {
+	Topic   string
+	Payload []byte
+}
+
+// publish sends messages as retained messages over a short-lived connection
+func (p *mqttPublisher) publish(ctx context.Context, messages []mqttMessage) error {
+	password, err := resolveSecret(p.cfg.Password)
+	if err != nil {
+		return err
+	}
+	c, err := dialMQTT(ctx, p.cfg.Broker, p.cfg.ClientID, p.cfg.Username, password)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	for _, msg := range messages {
+		if err := c.publish(msg.Topic, msg.Payload, p.cfg.QoS, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *mqttPublisher) stateTopic(entry string) string {
+	return p.cfg.TopicPrefix + "/" + mqttTopicSegment(entry) + "/state"
+}
+
+// mqttSensors are the Home Assistant sensors created for every entry
+var mqttSensors = []struct {
+	key, name, deviceClass, unit string
+}{
+	{"status", "Status", "", ""},
+	{"last_run", "Last run", "timestamp", ""},
+	{"last_success", "Last success", "timestamp", ""},
+	{"size", "Archive size", "data_size", "B"},
+	{"duration", "Duration", "duration", "s"},
+}
+
+// discoveryMessages returns the Home Assistant discovery config of every
+// sensor of every library entry
+func (p *mqttPublisher) discoveryMessages() ([]mqttMessage, error) {
+	var messages []mqttMessage
+	for _, entry := range p.entries {
+		node := haObjectID("gobackup_" + p.host + "_" + entry)
+		device := map[string]any{
+			"identifiers":  []string{node},
+			"name":         "Backup " + entry,
+			"manufacturer": "gobackup",
+			"sw_version":   VERSION,
+		}
+		for _, sensor := range mqttSensors {
+			config := map[string]any{
+				"name":           sensor.name,
+				"unique_id":      node + "_" + sensor.key,
+				"state_topic":    p.stateTopic(entry),
+				"value_template": "{{ value_json." + sensor.key + " }}",
+				"device":         device,
+			}
+			if sensor.deviceClass != "" {
+				config["device_class"] = sensor.deviceClass
+			}
+			if sensor.unit != "" {
+				config["unit_of_measurement"] = sensor.unit
+			}
+			if sensor.key == "status" {
+				config["json_attributes_topic"] = p.stateTopic(entry)
+				config["icon"] = "mdi:backup-restore"
+			}
+			payload, err := json.Marshal(config)
+			if err != nil {
+				return nil, err
+			}
+			topic := fmt.Sprintf("%s/sensor/%s/%s/config", p.cfg.DiscoveryPrefix, node, sensor.key)
+			messages = append(messages, mqttMessage{Topic: topic, Payload: payload})
+		}
+	}
+	return messages, nil
+}
+
+var (
+	mqttTopicUnsafe = regexp.MustCompile(`[+#/\x00]`)
+	haObjectUnsafe  = regexp.MustCompile(`[^a-z0-9_-]+`)
+)
+
+// mqttTopicSegment makes an entry name safe to use as one topic level
+func mqttTopicSegment(name string) string {
+	return mqttTopicUnsafe.ReplaceAllString(name, "_")
+}
+
+// haObjectID makes a name valid as a Home Assistant node or object ID
+func haObjectID(name string) string {
+	return haObjectUnsafe.ReplaceAllString(strings.ToLower(name), "_")
+}
+
+// mqttAddress returns the dial address of a broker URL and whether it uses
+// TLS. tcp:// and mqtt:// are plain, ssl://, tls:// and mqtts:// use TLS.
+func mqttAddress(broker string) (string, bool, error) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid Broker: %w", err)
+	}
+	var useTLS bool
+	port := "1883"
+	switch u.Scheme {
+	case "tcp", "mqtt":
+	case "ssl", "tls", "mqtts":
+		useTLS, port = true, "8883"
+	default:
+		return "", false, fmt.Errorf("invalid Broker %q (expected tcp://host:1883 or ssl://host:8883)", broker)
+	}
+	if u.Hostname() == "" {
+		return "", false, fmt.Errorf("invalid Broker %q: missing host", broker)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	return net.JoinHostPort(u.Hostname(), port), useTLS, nil
+}
+
+// mqttClient is a minimal MQTT 3.1.1 client that can only publish
+type mqttClient struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	stop   func() bool // stops closing conn when the context is cancelled
+	nextID uint16
+}
+
+// dialMQTT connects to broker and completes the CONNECT handshake. The
+// connection is closed as soon as ctx is cancelled.
+func dialMQTT(ctx context.Context, broker, clientID, username, password string) (*mqttClient, error) {
+	addr, useTLS, err := mqttAddress(broker)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: mqttTimeout}
+	var conn net.Conn
+	if useTLS {
+		host, _, _ := net.SplitHostPort(addr)
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(mqttTimeout))
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	c := &mqttClient{conn: conn, r: bufio.NewReader(conn), stop: stop}
+
+	flags := byte(0x02) // clean session
+	if username != "" {
+		flags |= 0x80
+		if password != "" {
+			flags |= 0x40
+		}
+	}
+	body := mqttString(nil, "MQTT")
+	body = append(body, 4, flags, 0, 60) // protocol level 4, keep alive 60s
+	body = mqttString(body, clientID)
+	if username != "" {
+		body = mqttString(body, username)
+		if password != "" {
+			body = mqttString(body, password)
+		}
+	}
+	if err := writeMQTTPacket(conn, mqttConnect, body); err != nil {
+		stop()
+		conn.Close()
+		return nil, err
+	}
+	header, ack, err := readMQTTPacket(c.r)
+	if err != nil {
+		stop()
+		conn.Close()
+		return nil, fmt.Errorf("failed to read CONNACK: %w", err)
+	}
+	if header&0xf0 != mqttConnack || len(ack) != 2 {
+		stop()
+		conn.Close()
+		return nil, fmt.Errorf("unexpected reply to CONNECT (packet type %d)", header>>4)
+	}
+	if ack[1] != 0 {
+		stop()
+		conn.Close()
+		return nil, fmt.Errorf("broker refused connection: %s", mqttConnackReason(ack[1]))
+	}
+	return c, nil
+}
+
+// publish sends a message and, for QoS 1, waits for the broker's PUBACK
+func (c *mqttClient) publish(topic string, payload []byte, qos int, retain bool) error {
+	header := mqttPublish | byte(qos)<<1
+	if retain {
+		header |= 0x01
+	}
+	body := mqttString(nil, topic)
+	var id uint16
+	if qos > 0 {
+		c.nextID++
+		id = c.nextID
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	body = append(body, payload...)
+	if err := writeMQTTPacket(c.conn, header, body); err != nil {
+		return err
+	}
+	if qos == 0 {
+		return nil
+	}
+	ackHeader, ack, err := readMQTTPacket(c.r)
+	if err != nil {
+		return fmt.Errorf("failed to read PUBACK: %w", err)
+	}
+	if ackHeader&0xf0 != mqttPuback || len(ack) != 2 || binary.BigEndian.Uint16(ack) != id {
+		return fmt.Errorf("unexpected reply to PUBLISH (packet type %d)", ackHeader>>4)
+	}
+	return nil
+}
+
+// close disconnects cleanly and closes the connection
+func (c *mqttClient) close() error {
+	c.stop()
+	err := writeMQTTPacket(c.conn, mqttDisconnect, nil)
+	return errors.Join(err, c.conn.Close())
+}
+
+// mqttString appends a length-prefixed UTF-8 string
+func mqttString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// writeMQTTPacket writes a control packet with its variable length header
+func writeMQTTPacket(w io.Writer, header byte, body []byte) error {
+	packet := []byte{header}
+	n := len(body)
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if n == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(packet, body...))
+	return err
+}
+
+// readMQTTPacket reads one control packet, returning its first header byte
+// and the rest of the packet
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		if digit&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, fmt.Errorf("malformed remaining length")
+		}
+		multiplier *= 128
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+func mqttConnackReason(code byte) string {
+	switch code {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "client identifier rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad user name or password"
+	case 5:
+		return "not authorized"
+	}
+	return fmt.Sprintf("return code %d", code)
+}
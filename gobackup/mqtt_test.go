@@ -0,0 +1,296 @@
+package gobackup
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testBroker is an in-process MQTT broker that keeps retained messages
+type testBroker struct {
+	ln       net.Listener
+	username string
+	password string
+
+	mu       sync.Mutex
+	retained map[string]string
+	clients  []string
+}
+
+func newTestBroker(t *testing.T, username, password string) *testBroker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	b := &testBroker{ln: ln, username: username, password: password, retained: make(map[string]string)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *testBroker) url() string {
+	return "tcp://" + b.ln.Addr().String()
+}
+
+// readString reads a length-prefixed string from the front of body
+func readString(body []byte) (string, []byte) {
+	n := int(binary.BigEndian.Uint16(body))
+	return string(body[2 : 2+n]), body[2+n:]
+}
+
+func (b *testBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	r := bufio.NewReader(conn)
+
+	header, body, err := readMQTTPacket(r)
+	if err != nil || header != mqttConnect {
+		return
+	}
+	_, rest := readString(body) // protocol name
+	flags := rest[1]
+	clientID, rest := readString(rest[4:])
+	var username, password string
+	if flags&0x80 != 0 {
+		username, rest = readString(rest)
+	}
+	if flags&0x40 != 0 {
+		password, _ = readString(rest)
+	}
+	if username != b.username || password != b.password {
+		writeMQTTPacket(conn, mqttConnack, []byte{0, 4})
+		return
+	}
+	b.mu.Lock()
+	b.clients = append(b.clients, clientID)
+	b.mu.Unlock()
+	writeMQTTPacket(conn, mqttConnack, []byte{0, 0})
+
+	for {
+		header, body, err := readMQTTPacket(r)
+		if err != nil || header == mqttDisconnect {
+			return
+		}
+		if header&0xf0 != mqttPublish {
+			continue
+		}
+		topic, rest := readString(body)
+		if qos := header >> 1 & 0x03; qos > 0 {
+			writeMQTTPacket(conn, mqttPuback, rest[:2])
+			rest = rest[2:]
+		}
+		if header&0x01 != 0 {
+			b.mu.Lock()
+			b.retained[topic] = string(rest)
+			b.mu.Unlock()
+		}
+	}
+}
+
+// message returns the retained message of topic
+func (b *testBroker) message(topic string) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	payload, ok := b.retained[topic]
+	return payload, ok
+}
+
+func TestMQTTPublishesEntryState(t *testing.T) {
+	broker := newTestBroker(t, "gobackup", "s3cret")
+	t.Setenv("TEST_MQTT_PASSWORD", "s3cret")
+	t.Setenv("SCRATCH", t.TempDir())
+	library := map[string]Backup{
+		"photos": {Type: "tar", Source: t.TempDir(), Destination: t.TempDir(), Retain: 2, ChangeDir: true},
+		"broken": {Type: "tar", Source: t.TempDir(), Destination: "/nonexistent/gobackup", Retain: 1, ChangeDir: true},
+	}
+	cfg := &MQTTSettings{Broker: broker.url(), Username: "gobackup", Password: "env:TEST_MQTT_PASSWORD", QoS: 1, TopicPrefix: "home/backups/"}
+	p, err := newMQTTPublisher(cfg, library, filepath.Join(t.TempDir(), "mqtt.json"))
+	if err != nil {
+		t.Fatalf("newMQTTPublisher() failed: %v", err)
+	}
+	var out strings.Builder
+	p.log = slog.New(slog.NewTextHandler(&out, nil))
+	opts := DefaultRunOptions()
+	opts.observers = []runObserver{p}
+	outcomes := runSequential(context.Background(), []string{"photos", "broken"}, library, opts)
+	opts.runFinished(runReport{})
+	if out.Len() != 0 {
+		t.Fatalf("unexpected output: %s", out.String())
+	}
+
+	payload, ok := broker.message("home/backups/photos/state")
+	if !ok {
+		t.Fatal("no retained state for photos")
+	}
+	var state mqttEntryState
+	if err := json.Unmarshal([]byte(payload), &state); err != nil {
+		t.Fatalf("state is not JSON: %v\n%s", err, payload)
+	}
+	if state.Status != StatusSucceeded || state.LastRun == nil || state.LastSuccess == nil ||
+		state.Archive != outcomes["photos"].archive || state.Size != outcomes["photos"].size || state.Size == 0 {
+		t.Errorf("unexpected photos state: %s", payload)
+	}
+
+	payload, _ = broker.message("home/backups/broken/state")
+	state = mqttEntryState{}
+	json.Unmarshal([]byte(payload), &state)
+	if state.Status != StatusFailed || state.LastSuccess != nil || !strings.Contains(state.Error, "destination directory does not exist") {
+		t.Errorf("unexpected broken state: %s", payload)
+	}
+	if !strings.Contains(payload, `"last_success":null`) {
+		t.Errorf("unknown last_success should be null: %s", payload)
+	}
+
+	// Discovery was not enabled
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	for topic := range broker.retained {
+		if strings.HasPrefix(topic, "homeassistant/") {
+			t.Errorf("unexpected discovery message on %s", topic)
+		}
+	}
+}
+
+func TestMQTTKeepsLastSuccessAcrossRuns(t *testing.T) {
+	broker := newTestBroker(t, "", "")
+	statePath := filepath.Join(t.TempDir(), "mqtt.json")
+	cfg := &MQTTSettings{Broker: broker.url(), QoS: 1}
+
+	p, _ := newMQTTPublisher(cfg, nil, statePath)
+	p.entryFinished(outcome{entry: "db", status: StatusSucceeded, started: time.Now(), archive: "/b/db_1.tar.gz", size: 42})
+	p.flush(time.Minute)
+
+	// A later process reports a failure
+	p, _ = newMQTTPublisher(cfg, nil, statePath)
+	p.entryStarted(Backup{Name: "db"})
+	p.flush(time.Minute)
+	payload, _ := broker.message("gobackup/db/state")
+	if !strings.Contains(payload, `"status":"running"`) {
+		t.Errorf("state while running = %s", payload)
+	}
+	p.entryFinished(outcome{entry: "db", status: StatusFailed, started: time.Now(), err: io.ErrUnexpectedEOF})
+	p.flush(time.Minute)
+
+	payload, _ = broker.message("gobackup/db/state")
+	var state mqttEntryState
+	json.Unmarshal([]byte(payload), &state)
+	if state.Status != StatusFailed || state.LastSuccess == nil || state.Size != 42 || state.Archive != "/b/db_1.tar.gz" {
+		t.Errorf("failed run lost the previous success: %s", payload)
+	}
+}
+
+func TestMQTTHomeAssistantDiscovery(t *testing.T) {
+	broker := newTestBroker(t, "", "")
+	library := map[string]Backup{"Photos Library": {Type: "tar"}, "db": {Type: "command"}}
+	cfg := &MQTTSettings{Broker: broker.url(), QoS: 1, Discovery: true}
+	p, err := newMQTTPublisher(cfg, library, "")
+	if err != nil {
+		t.Fatalf("newMQTTPublisher() failed: %v", err)
+	}
+	p.host = "nas"
+	p.entryStarted(Backup{Name: "db"})
+	p.flush(time.Minute)
+
+	// Every library entry gets its sensors, not only the one that ran
+	for _, node := range []string{"gobackup_nas_photos_library", "gobackup_nas_db"} {
+		for _, sensor := range mqttSensors {
+			topic := "homeassistant/sensor/" + node + "/" + sensor.key + "/config"
+			if _, ok := broker.message(topic); !ok {
+				t.Errorf("missing discovery message %s", topic)
+			}
+		}
+	}
+
+	payload, _ := broker.message("homeassistant/sensor/gobackup_nas_photos_library/size/config")
+	var config map[string]any
+	if err := json.Unmarshal([]byte(payload), &config); err != nil {
+		t.Fatalf("discovery payload is not JSON: %v", err)
+	}
+	if config["state_topic"] != "gobackup/Photos Library/state" || config["device_class"] != "data_size" ||
+		config["unit_of_measurement"] != "B" || config["unique_id"] != "gobackup_nas_photos_library_size" {
+		t.Errorf("unexpected discovery payload: %s", payload)
+	}
+}
+
+func TestMQTTBrokerFailureOnlyWarns(t *testing.T) {
+	broker := newTestBroker(t, "gobackup", "right")
+	p, _ := newMQTTPublisher(&MQTTSettings{Broker: broker.url(), Username: "gobackup", Password: "wrong"}, nil, "")
+	var out strings.Builder
+	p.log = slog.New(slog.NewTextHandler(&out, nil))
+	p.entryStarted(Backup{Name: "db"})
+	p.flush(time.Minute)
+	if !strings.Contains(out.String(), "bad user name or password") {
+		t.Errorf("missing warning, got %q", out.String())
+	}
+}
+
+func TestMQTTUnresponsiveBrokerDoesNotHoldUpEntries(t *testing.T) {
+	// The broker accepts connections but never answers CONNECT
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	p, _ := newMQTTPublisher(&MQTTSettings{Broker: "tcp://" + ln.Addr().String()}, nil, "")
+	var out strings.Builder
+	p.log = slog.New(slog.NewTextHandler(&out, nil))
+	started := time.Now()
+	p.entryStarted(Backup{Name: "photos"})
+	p.entryStarted(Backup{Name: "music"})
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("publishing held up the entries for %v", elapsed)
+	}
+	p.flush(50 * time.Millisecond)
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("flush took %v", elapsed)
+	}
+	for _, want := range []string{`msg="MQTT publish failed"`, `msg="Dropping MQTT states that could not be published in time"`, "count=1"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("missing %q in %q", want, out.String())
+		}
+	}
+}
+
+func TestNewMQTTPublisherValidation(t *testing.T) {
+	if p, err := newMQTTPublisher(nil, nil, ""); p != nil || err != nil {
+		t.Errorf("newMQTTPublisher(nil) = %v, %v, want nil, nil", p, err)
+	}
+	for _, cfg := range []MQTTSettings{
+		{},
+		{Broker: "http://broker"},
+		{Broker: "tcp://"},
+		{Broker: "tcp://broker", QoS: 2},
+	} {
+		if _, err := newMQTTPublisher(&cfg, nil, ""); err == nil {
+			t.Errorf("newMQTTPublisher(%+v) succeeded, want error", cfg)
+		}
+	}
+}
@@ -0,0 +1,612 @@
+package gobackup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	notifyOnFailure = "failure"
+	notifyOnSuccess = "success"
+	notifyOnAlways  = "always"
+	notifyOnChange  = "change"
+
+	scopeEntry = "entry"
+	scopeRun   = "run"
+	scopeBoth  = "both"
+
+	// runStateKey stores the status of whole runs in the notify state
+	runStateKey = "_run"
+)
+
+var (
+	// notifyTimeout bounds every notification request
+	notifyTimeout = 10 * time.Second
+	// notifyFlushTimeout is how long the end of a run waits for
+	// notifications still being sent before giving up on them
+	notifyFlushTimeout = 30 * time.Second
+)
+
+// notifyEvent is the data available to notification templates and the
+// default webhook JSON body
+type notifyEvent struct {
+	Scope           string
+	Host            string
+	Entry           string `json:",omitempty"`
+	Type            string `json:",omitempty"`
+	Status          string
+	PreviousStatus  string `json:",omitempty"`
+	Started         time.Time
+	Duration        string
+	DurationSeconds float64
+	Archive         string `json:",omitempty"`
+	Size            int64
+	SizeHuman       string
+	Error           string          `json:",omitempty"`
+	Output          string          `json:",omitempty"`
+	Removed         []string        `json:",omitempty"`
+	Attempts        []notifyAttempt `json:",omitempty"`
+	Succeeded       int             `json:",omitempty"`
+	Failed          int             `json:",omitempty"`
+	Skipped         int             `json:",omitempty"`
+	Entries         []notifyEvent   `json:",omitempty"`
+}
+
+// notifyAttempt describes one try at pulling an rsync source
+type notifyAttempt struct {
+	Started  time.Time
+	Duration string
+	Error    string `json:",omitempty"`
+}
+
+// notifyTarget is a validated NotificationTarget with parsed templates
+type notifyTarget struct {
+	NotificationTarget
+	title   *template.Template
+	message *template.Template
+	body    *template.Template
+}
+
+// notifier sends notifications about finished entries and runs. It remembers
+// the last status of every entry so targets can notify on state changes.
+//
+// Notifications are sent in order from a goroutine of their own, so an
+// unreachable service does not hold up the entries. The end of the run waits
+// for them, up to notifyFlushTimeout.
+type notifier struct {
+	targets   []notifyTarget
+	statePath string
+	client    *http.Client
+	log       *slog.Logger
+	queue     sendQueue[notification]
+
+	mu    sync.Mutex
+	state map[string]string
+}
+
+// notification is an event waiting to be sent to a target
+type notification struct {
+	target notifyTarget
+	event  notifyEvent
+}
+
+var templateFuncs = template.FuncMap{
+	// json encodes a value, e.g. {{json .Error}} inside a JSON body template
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// newNotifier validates the configured targets
+func newNotifier(targets []NotificationTarget, statePath string) (*notifier, error) {
+	n := &notifier{statePath: statePath, client: &http.Client{Timeout: notifyTimeout}, log: slog.Default()}
+	n.queue.deliver = n.deliver
+	for i, target := range targets {
+		// Email defaults to one report per run, whatever the outcome
+		if target.On == "" {
+			target.On = notifyOnFailure
+			if target.Type == "email" {
+				target.On = notifyOnAlways
+			}
+		}
+		if target.Scope == "" {
+			target.Scope = scopeEntry
+			if target.Type == "email" {
+				target.Scope = scopeRun
+			}
+		}
+		t := notifyTarget{NotificationTarget: target}
+		if err := t.validate(); err != nil {
+			return nil, fmt.Errorf("notification %d (%s): %w", i+1, target.Type, err)
+		}
+		var err error
+		if t.title, err = parseTemplate("Title", target.Title); err != nil {
+			return nil, fmt.Errorf("notification %d (%s): %w", i+1, target.Type, err)
+		}
+		if t.message, err = parseTemplate("Message", target.Message); err != nil {
+			return nil, fmt.Errorf("notification %d (%s): %w", i+1, target.Type, err)
+		}
+		if t.body, err = parseTemplate("Body", target.Body); err != nil {
+			return nil, fmt.Errorf("notification %d (%s): %w", i+1, target.Type, err)
+		}
+		n.targets = append(n.targets, t)
+	}
+	return n, nil
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+func (t notifyTarget) validate() error {
+	switch t.Type {
+	case "webhook", "gotify":
+		if t.URL == "" {
+			return fmt.Errorf("URL is required")
+		}
+	case "ntfy":
+		if t.Topic == "" {
+			return fmt.Errorf("Topic is required")
+		}
+	case "email":
+		if t.URL == "" {
+			return fmt.Errorf("URL is required")
+		}
+		if len(t.To) == 0 {
+			return fmt.Errorf("To is required")
+		}
+		if _, _, _, err := smtpAddress(t.URL, t.TLS); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown notification type %q (supported: webhook, ntfy, gotify, email)", t.Type)
+	}
+	switch t.On {
+	case notifyOnFailure, notifyOnSuccess, notifyOnAlways, notifyOnChange:
+	default:
+		return fmt.Errorf("invalid On %q (supported: failure, success, always, change)", t.On)
+	}
+	switch t.Scope {
+	case scopeEntry, scopeRun, scopeBoth:
+	default:
+		return fmt.Errorf("invalid Scope %q (supported: entry, run, both)", t.Scope)
+	}
+	return nil
+}
+
+// wants reports whether the target should fire for an event
+func (t notifyTarget) wants(event notifyEvent) bool {
+	if event.Scope == scopeEntry && len(t.Entries) > 0 {
+		found := false
+		for _, name := range t.Entries {
+			found = found || name == event.Entry
+		}
+		if !found {
+			return false
+		}
+	}
+	if t.Scope != scopeBoth && t.Scope != event.Scope {
+		return false
+	}
+	failed := event.Status != StatusSucceeded
+	switch t.On {
+	case notifyOnSuccess:
+		return !failed
+	case notifyOnAlways:
+		return true
+	case notifyOnChange:
+		if event.PreviousStatus == "" {
+			return failed
+		}
+		return event.PreviousStatus != event.Status
+	default:
+		return failed
+	}
+}
+
+func (n *notifier) entryStarted(backup Backup) {}
+
+func (n *notifier) entryFinished(result outcome) {
+	event := entryEvent(result)
+	n.dispatch(result.entry, event)
+}
+
+func (n *notifier) runFinished(report runReport) {
+	event := runEvent(report)
+	n.dispatch(runStateKey, event)
+	n.flush(notifyFlushTimeout)
+}
+
+// close sends the notifications still queued and drops idle connections
+func (n *notifier) close() {
+	n.flush(notifyFlushTimeout)
+	n.client.CloseIdleConnections()
+}
+
+// flush waits up to timeout for the queued notifications to be sent, then
+// drops those that are left
+func (n *notifier) flush(timeout time.Duration) {
+	if dropped := n.queue.flush(timeout); dropped > 0 {
+		n.log.Warn("Dropping notifications that could not be sent in time", "count", dropped)
+	}
+}
+
+// dispatch records the new status under key and queues event for every
+// target that wants it
+func (n *notifier) dispatch(key string, event notifyEvent) {
+	if len(n.targets) == 0 {
+		return
+	}
+	event.PreviousStatus = n.swapState(key, event.Status)
+	for _, target := range n.targets {
+		if target.wants(event) {
+			n.queue.enqueue(notification{target, event})
+		}
+	}
+}
+
+// deliver sends a queued notification. Failures are reported but never
+// fail the backup.
+func (n *notifier) deliver(ctx context.Context, msg notification) {
+	if err := n.send(ctx, msg.target, msg.event); err != nil {
+		n.log.Warn("Notification failed", "type", msg.target.Type, "error", err)
+	}
+}
+
+// swapState stores the latest status for key and returns the previous one
+func (n *notifier) swapState(key, status string) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.state == nil {
+		n.state = make(map[string]string)
+		if data, err := os.ReadFile(n.statePath); err == nil {
+			json.Unmarshal(data, &n.state)
+		}
+	}
+	previous := n.state[key]
+	n.state[key] = status
+	if previous != status && n.statePath != "" {
+		data, _ := json.MarshalIndent(n.state, "", "  ")
+		if err := writeFileAtomic(n.statePath, data, 0644); err != nil {
+			n.log.Warn("Failed to save notification state", "error", err)
+		}
+	}
+	return previous
+}
+
+func notifyStatePath(dir string) string {
+	return statePath(dir, "notify.json")
+}
+
+// entryEvent describes a finished entry
+func entryEvent(result outcome) notifyEvent {
+	host, _ := os.Hostname()
+	event := notifyEvent{
+		Scope:           scopeEntry,
+		Host:            host,
+		Entry:           result.entry,
+		Type:            result.backup.Type,
+		Status:          result.status,
+		Started:         result.started,
+		Duration:        result.duration.Round(time.Second).String(),
+		DurationSeconds: result.duration.Seconds(),
+		Archive:         result.archive,
+		Size:            result.size,
+		SizeHuman:       formatSize(result.size),
+		Output:          result.output,
+		Removed:         result.removed,
+	}
+	if result.err != nil {
+		event.Error = result.err.Error()
+	}
+	for _, a := range result.attempts {
+		na := notifyAttempt{Started: a.Started, Duration: a.Duration.Round(time.Second).String()}
+		if a.Err != nil {
+			na.Error = a.Err.Error()
+		}
+		event.Attempts = append(event.Attempts, na)
+	}
+	return event
+}
+
+// runEvent summarises a finished run
+func runEvent(report runReport) notifyEvent {
+	host, _ := os.Hostname()
+	duration := report.finished.Sub(report.started)
+	event := notifyEvent{
+		Scope:           scopeRun,
+		Host:            host,
+		Status:          StatusSucceeded,
+		Started:         report.started,
+		Duration:        duration.Round(time.Second).String(),
+		DurationSeconds: duration.Seconds(),
+	}
+	var errs []string
+	for _, result := range report.outcomes {
+		entry := entryEvent(result)
+		event.Entries = append(event.Entries, entry)
+		event.Size += result.size
+		switch result.status {
+		case StatusSucceeded:
+			event.Succeeded++
+		case StatusSkipped:
+			event.Skipped++
+		default:
+			event.Failed++
+		}
+		if entry.Error != "" {
+			errs = append(errs, entry.Error)
+		}
+	}
+	if event.Failed > 0 || event.Skipped > 0 {
+		event.Status = StatusFailed
+	}
+	event.SizeHuman = formatSize(event.Size)
+	event.Error = strings.Join(errs, "\n")
+	return event
+}
+
+// defaultTitle and defaultMessage are used when a target has no templates
+func defaultTitle(event notifyEvent) string {
+	if event.Scope == scopeRun {
+		return fmt.Sprintf("gobackup run on %s %s", event.Host, event.Status)
+	}
+	return fmt.Sprintf("gobackup %s %s", event.Entry, event.Status)
+}
+
+func defaultMessage(event notifyEvent) string {
+	var b strings.Builder
+	if event.Scope == scopeRun {
+		fmt.Fprintf(&b, "%d succeeded, %d failed, %d skipped in %s\n", event.Succeeded, event.Failed, event.Skipped, event.Duration)
+		for _, entry := range event.Entries {
+			fmt.Fprintf(&b, "%s: %s", entry.Entry, entry.Status)
+			if entry.Size > 0 {
+				fmt.Fprintf(&b, " (%s)", entry.SizeHuman)
+			}
+			b.WriteString("\n")
+		}
+	} else {
+		fmt.Fprintf(&b, "Backup of '%s' on %s %s after %s", event.Entry, event.Host, event.Status, event.Duration)
+		if event.Size > 0 {
+			fmt.Fprintf(&b, " (%s)", event.SizeHuman)
+		}
+		b.WriteString("\n")
+	}
+	if event.Error != "" {
+		b.WriteString(event.Error)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// render executes tmpl, falling back to def when no template is configured
+func render(tmpl *template.Template, event notifyEvent, def func(notifyEvent) string) (string, error) {
+	if tmpl == nil {
+		return def(event), nil
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, event); err != nil {
+		return "", fmt.Errorf("failed to render %s template: %w", tmpl.Name(), err)
+	}
+	return b.String(), nil
+}
+
+// send delivers one event to one target, giving up on HTTP requests once
+// ctx is cancelled
+func (n *notifier) send(ctx context.Context, target notifyTarget, event notifyEvent) error {
+	title, err := render(target.title, event, defaultTitle)
+	if err != nil {
+		return err
+	}
+	if target.Type == "email" {
+		body, err := render(target.message, event, emailReport)
+		if err != nil {
+			return err
+		}
+		return sendEmail(ctx, target, title, body)
+	}
+	message, err := render(target.message, event, defaultMessage)
+	if err != nil {
+		return err
+	}
+	token, err := resolveSecret(target.Token)
+	if err != nil {
+		return err
+	}
+
+	var req *http.Request
+	switch target.Type {
+	case "webhook":
+		var body []byte
+		if target.body != nil {
+			rendered, err := render(target.body, event, nil)
+			if err != nil {
+				return err
+			}
+			body = []byte(rendered)
+		} else if body, err = json.Marshal(event); err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+		if req, err = http.NewRequestWithContext(ctx, http.MethodPost, target.URL, bytes.NewReader(body)); err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+	case "ntfy":
+		base := target.URL
+		if base == "" {
+			base = "https://ntfy.sh"
+		}
+		url := strings.TrimSuffix(base, "/") + "/" + target.Topic
+		if req, err = http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(message)); err != nil {
+			return err
+		}
+		req.Header.Set("Title", title)
+		if target.Priority > 0 {
+			req.Header.Set("Priority", fmt.Sprint(target.Priority))
+		}
+		tag := "white_check_mark"
+		if event.Status != StatusSucceeded {
+			tag = "rotating_light"
+		}
+		req.Header.Set("Tags", tag)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+	case "gotify":
+		priority := target.Priority
+		if priority == 0 {
+			priority = 5
+			if event.Status != StatusSucceeded {
+				priority = 8
+			}
+		}
+		body, err := json.Marshal(map[string]any{"title": title, "message": message, "priority": priority})
+		if err != nil {
+			return fmt.Errorf("failed to encode message: %w", err)
+		}
+		url := strings.TrimSuffix(target.URL, "/") + "/message"
+		if req, err = http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body)); err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Gotify-Key", token)
+	}
+
+	for key, value := range target.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", req.URL.Redacted(), resp.Status)
+	}
+	return nil
+}
+
+// resolveSecret returns the secret a reference points at: "env:NAME" reads
+// an environment variable and "file:/path" the first line of a file. Any
+// other value is used as the secret itself.
+func resolveSecret(ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, "env:"):
+		name := strings.TrimPrefix(ref, "env:")
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("secret environment variable %s is not set", name)
+		}
+		return value, nil
+	case strings.HasPrefix(ref, "file:"):
+		data, err := os.ReadFile(strings.TrimPrefix(ref, "file:"))
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret: %w", err)
+		}
+		secret, _, _ := strings.Cut(string(data), "\n")
+		return strings.TrimRight(secret, "\r"), nil
+	}
+	return ref, nil
+}
+
+// formatSize renders a byte count with a binary unit, e.g. 1.5 GiB
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// NotificationResult is how sending a test notification to one target went
+type NotificationResult struct {
+	Target int    // 1-based position in the notification settings
+	Type   string // type of the target, e.g. "email"
+	Err    error
+}
+
+// SendTestNotifications sends a sample report to the notification targets of
+// library, or only to the nth (1-based) if only is not 0. An error means
+// none could be tried; how each target went is in the results.
+func SendTestNotifications(library Library, only int) ([]NotificationResult, error) {
+	// No state path: a test must not affect change detection
+	n, err := newNotifier(library.Settings.Notifications, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid library settings: %w", err)
+	}
+	if len(n.targets) == 0 {
+		return nil, fmt.Errorf("no notifications configured in %s", settingsKey)
+	}
+	if only < 0 || only > len(n.targets) {
+		return nil, fmt.Errorf("target must be between 1 and %d", len(n.targets))
+	}
+
+	report := sampleReport(time.Now())
+	var results []NotificationResult
+	for i, target := range n.targets {
+		if only != 0 && only != i+1 {
+			continue
+		}
+		event := runEvent(report)
+		if target.Scope == scopeEntry {
+			event = entryEvent(report.outcomes[len(report.outcomes)-1])
+		}
+		results = append(results, NotificationResult{Target: i + 1, Type: target.Type, Err: n.send(context.Background(), target, event)})
+	}
+	return results, nil
+}
+
+// sampleReport is a made-up run with one successful and one failed entry
+func sampleReport(now time.Time) runReport {
+	started := now.Add(-5 * time.Minute)
+	return runReport{
+		started:  started,
+		finished: now,
+		outcomes: []outcome{
+			{
+				entry:    "example-documents",
+				backup:   Backup{Name: "example-documents", Type: "tar", Destination: "/backups"},
+				status:   StatusSucceeded,
+				started:  started,
+				duration: 3*time.Minute + 12*time.Second,
+				archive:  "/backups/example-documents_" + started.Format("2006.01.02_15.04.05") + ".tar.gz",
+				size:     512 << 20,
+				removed:  []string{"/backups/example-documents_2000.01.01_00.00.00.tar.gz"},
+			},
+			{
+				entry:    "example-server",
+				backup:   Backup{Name: "example-server", Type: "rsync", Destination: "/backups"},
+				status:   StatusFailed,
+				err:      fmt.Errorf("rsync backup failed for 'example-server': rsync command failed: exit status 255"),
+				started:  started.Add(3 * time.Minute),
+				duration: 14 * time.Second,
+				output:   "Beginning rsync using command rsync -rahz --delete ...\nssh: connect to host example port 22: Connection refused\nrsync: connection unexpectedly closed (0 bytes received so far) [Receiver]",
+			},
+		},
+	}
+}
@@ -0,0 +1,312 @@
+package gobackup
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordedRequest is a notification received by the test server
+type recordedRequest struct {
+	Path    string
+	Headers http.Header
+	Body    string
+}
+
+func notificationServer(t *testing.T) (*httptest.Server, func() []recordedRequest) {
+	t.Helper()
+	var (
+		mu       sync.Mutex
+		requests []recordedRequest
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, recordedRequest{Path: r.URL.Path, Headers: r.Header.Clone(), Body: string(body)})
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []recordedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]recordedRequest{}, requests...)
+	}
+}
+
+func testOutcome(entry, status string) outcome {
+	result := outcome{
+		entry:    entry,
+		backup:   Backup{Name: entry, Type: "tar"},
+		status:   status,
+		started:  time.Now(),
+		duration: 90 * time.Second,
+		archive:  "/backups/" + entry + ".tar.gz",
+		size:     3 * 1024 * 1024,
+	}
+	if status != StatusSucceeded {
+		result.err = errors.New("tar command failed")
+	}
+	return result
+}
+
+func TestNotifierWebhookDefaultBody(t *testing.T) {
+	server, requests := notificationServer(t)
+	n, err := newNotifier([]NotificationTarget{{Type: "webhook", URL: server.URL + "/hook"}}, filepath.Join(t.TempDir(), "notify.json"))
+	if err != nil {
+		t.Fatalf("newNotifier() failed: %v", err)
+	}
+	n.log = discardLog
+
+	n.entryFinished(testOutcome("photos", StatusSucceeded))
+	n.entryFinished(testOutcome("photos", StatusFailed))
+	n.flush(time.Minute)
+
+	got := requests()
+	if len(got) != 1 {
+		t.Fatalf("received %d webhooks, want 1 (failure only)", len(got))
+	}
+	var event notifyEvent
+	if err := json.Unmarshal([]byte(got[0].Body), &event); err != nil {
+		t.Fatalf("webhook body is not JSON: %v\n%s", err, got[0].Body)
+	}
+	if event.Entry != "photos" || event.Status != StatusFailed || event.Error != "tar command failed" || event.Size != 3*1024*1024 {
+		t.Errorf("unexpected event: %+v", event)
+	}
+	if got[0].Headers.Get("Content-Type") != "application/json" {
+		t.Errorf("Content-Type = %s", got[0].Headers.Get("Content-Type"))
+	}
+}
+
+func TestNotifierWebhookTemplate(t *testing.T) {
+	server, requests := notificationServer(t)
+	target := NotificationTarget{
+		Type: "webhook",
+		URL:  server.URL,
+		On:   notifyOnAlways,
+		Body: `{"text": {{json (printf "%s %s (%s)" .Entry .Status .SizeHuman)}}}`,
+	}
+	n, err := newNotifier([]NotificationTarget{target}, "")
+	if err != nil {
+		t.Fatalf("newNotifier() failed: %v", err)
+	}
+	n.log = discardLog
+	n.entryFinished(testOutcome("photos", StatusSucceeded))
+	n.flush(time.Minute)
+
+	got := requests()
+	if len(got) != 1 || got[0].Body != `{"text": "photos succeeded (3.0 MiB)"}` {
+		t.Errorf("webhook requests = %+v", got)
+	}
+}
+
+func TestNotifierNtfyAndGotify(t *testing.T) {
+	server, requests := notificationServer(t)
+	targets := []NotificationTarget{
+		{Type: "ntfy", URL: server.URL, Topic: "backups", Token: "tk_secret", Priority: 4},
+		{Type: "gotify", URL: server.URL + "/", Token: "app-token"},
+	}
+	n, err := newNotifier(targets, "")
+	if err != nil {
+		t.Fatalf("newNotifier() failed: %v", err)
+	}
+	n.log = discardLog
+	n.entryFinished(testOutcome("torado", StatusFailed))
+	n.flush(time.Minute)
+
+	got := requests()
+	if len(got) != 2 {
+		t.Fatalf("received %d requests, want 2", len(got))
+	}
+	ntfy, gotify := got[0], got[1]
+	if ntfy.Path != "/backups" || ntfy.Headers.Get("Title") != "gobackup torado failed" ||
+		ntfy.Headers.Get("Priority") != "4" || ntfy.Headers.Get("Authorization") != "Bearer tk_secret" {
+		t.Errorf("unexpected ntfy request: %+v", ntfy)
+	}
+	if !strings.Contains(ntfy.Body, "tar command failed") {
+		t.Errorf("ntfy message does not include the error: %q", ntfy.Body)
+	}
+
+	if gotify.Path != "/message" || gotify.Headers.Get("X-Gotify-Key") != "app-token" {
+		t.Errorf("unexpected gotify request: %+v", gotify)
+	}
+	var msg struct {
+		Title    string `json:"title"`
+		Message  string `json:"message"`
+		Priority int    `json:"priority"`
+	}
+	if err := json.Unmarshal([]byte(gotify.Body), &msg); err != nil {
+		t.Fatalf("gotify body is not JSON: %v", err)
+	}
+	if msg.Title != "gobackup torado failed" || msg.Priority != 8 {
+		t.Errorf("unexpected gotify message: %+v", msg)
+	}
+}
+
+func TestNotifierStateChange(t *testing.T) {
+	server, requests := notificationServer(t)
+	statePath := filepath.Join(t.TempDir(), "notify.json")
+	target := NotificationTarget{Type: "webhook", URL: server.URL, On: notifyOnChange}
+
+	statuses := []string{StatusSucceeded, StatusSucceeded, StatusFailed, StatusFailed, StatusSucceeded}
+	for _, status := range statuses {
+		// A fresh notifier per run, as with separate gobackup invocations
+		n, err := newNotifier([]NotificationTarget{target}, statePath)
+		if err != nil {
+			t.Fatalf("newNotifier() failed: %v", err)
+		}
+		n.log = discardLog
+		n.entryFinished(testOutcome("photos", status))
+		n.flush(time.Minute)
+	}
+
+	got := requests()
+	if len(got) != 2 {
+		t.Fatalf("received %d notifications, want 2 (failure and recovery)", len(got))
+	}
+	for i, want := range []string{StatusFailed, StatusSucceeded} {
+		var event notifyEvent
+		json.Unmarshal([]byte(got[i].Body), &event)
+		if event.Status != want {
+			t.Errorf("notification %d status = %s, want %s", i, event.Status, want)
+		}
+	}
+}
+
+func TestNotifierRunScope(t *testing.T) {
+	server, requests := notificationServer(t)
+	target := NotificationTarget{Type: "webhook", URL: server.URL, Scope: scopeRun, On: notifyOnAlways}
+	n, err := newNotifier([]NotificationTarget{target}, "")
+	if err != nil {
+		t.Fatalf("newNotifier() failed: %v", err)
+	}
+	n.log = discardLog
+
+	report := runReport{
+		started:  time.Now().Add(-time.Minute),
+		finished: time.Now(),
+		outcomes: []outcome{testOutcome("a", StatusSucceeded), testOutcome("b", StatusFailed)},
+	}
+	for _, result := range report.outcomes {
+		n.entryFinished(result)
+	}
+	n.runFinished(report)
+
+	got := requests()
+	if len(got) != 1 {
+		t.Fatalf("received %d notifications, want only the run summary", len(got))
+	}
+	var event notifyEvent
+	json.Unmarshal([]byte(got[0].Body), &event)
+	if event.Scope != scopeRun || event.Status != StatusFailed || event.Succeeded != 1 || event.Failed != 1 || len(event.Entries) != 2 {
+		t.Errorf("unexpected run event: %+v", event)
+	}
+}
+
+func TestNotifierFailureDoesNotPanic(t *testing.T) {
+	n, err := newNotifier([]NotificationTarget{{Type: "webhook", URL: "http://127.0.0.1:1/unreachable"}}, "")
+	if err != nil {
+		t.Fatalf("newNotifier() failed: %v", err)
+	}
+	var out strings.Builder
+	n.log = slog.New(slog.NewTextHandler(&out, nil))
+	n.entryFinished(testOutcome("photos", StatusFailed))
+	n.flush(time.Minute)
+	if !strings.Contains(out.String(), `msg="Notification failed" type=webhook`) {
+		t.Errorf("missing warning, got %q", out.String())
+	}
+}
+
+func TestNotificationsDoNotHoldUpEntries(t *testing.T) {
+	// The service never answers
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+	n, err := newNotifier([]NotificationTarget{{Type: "webhook", URL: server.URL}}, "")
+	if err != nil {
+		t.Fatalf("newNotifier() failed: %v", err)
+	}
+	var out strings.Builder
+	n.log = slog.New(slog.NewTextHandler(&out, nil))
+	started := time.Now()
+	n.entryFinished(testOutcome("photos", StatusFailed))
+	n.entryFinished(testOutcome("music", StatusFailed))
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("notifications held up the entries for %v", elapsed)
+	}
+	n.flush(50 * time.Millisecond)
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("flush took %v", elapsed)
+	}
+	for _, want := range []string{`msg="Notification failed"`, `msg="Dropping notifications that could not be sent in time" count=1`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("missing %q in %q", want, out.String())
+		}
+	}
+}
+
+func TestNewNotifierValidation(t *testing.T) {
+	invalid := []NotificationTarget{
+		{Type: "pager"},
+		{Type: "webhook"},
+		{Type: "ntfy"},
+		{Type: "gotify"},
+		{Type: "webhook", URL: "http://x", On: "sometimes"},
+		{Type: "webhook", URL: "http://x", Scope: "global"},
+		{Type: "webhook", URL: "http://x", Body: "{{.Entry"},
+	}
+	for _, target := range invalid {
+		if _, err := newNotifier([]NotificationTarget{target}, ""); err == nil {
+			t.Errorf("newNotifier(%+v) succeeded, want error", target)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := map[int64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1536:            "1.5 KiB",
+		5 * 1024 * 1024: "5.0 MiB",
+		3 << 40:         "3.0 TiB",
+	}
+	for size, want := range tests {
+		if got := formatSize(size); got != want {
+			t.Errorf("formatSize(%d) = %s, want %s", size, got, want)
+		}
+	}
+}
+
+func TestResolveSecret(t *testing.T) {
+	t.Setenv("TEST_NOTIFY_SECRET", "from-env")
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secretFile, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"env:TEST_NOTIFY_SECRET": "from-env",
+		"file:" + secretFile:     "from-file",
+		"literal":                "literal",
+		"":                       "",
+	}
+	for ref, want := range tests {
+		got, err := resolveSecret(ref)
+		if err != nil || got != want {
+			t.Errorf("resolveSecret(%q) = %q, %v, want %q", ref, got, err, want)
+		}
+	}
+	if _, err := resolveSecret("env:TEST_NOTIFY_SECRET_UNSET"); err == nil {
+		t.Error("resolveSecret() of an unset variable succeeded, want error")
+	}
+}
@@ -0,0 +1,168 @@
+package gobackup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// pingTimeout bounds each ping request, pingAttempts is how often a
+	// ping is tried and pingRetryDelay the pause before the first retry,
+	// doubling after each failed attempt
+	pingTimeout    = 5 * time.Second
+	pingAttempts   = 3
+	pingRetryDelay = time.Second
+	// pingFlushTimeout is how long the end of a run waits for pings still
+	// being sent before giving up on them
+	pingFlushTimeout = 15 * time.Second
+)
+
+// pingBodyLimit keeps failure bodies within what healthchecks.io accepts
+const pingBodyLimit = 100 * 1024
+
+// pinger reports entries to a healthchecks.io-style dead man's switch: it
+// requests PingURL/start when an entry begins, PingURL when it succeeds and
+// PingURL/fail, with the error as body, when it fails or is skipped.
+// Ping failures are reported but never fail the backup.
+//
+// Pings are sent in order from a goroutine of their own, so a slow or
+// unreachable endpoint does not hold up the entries. The end of the run
+// waits for them, up to pingFlushTimeout.
+type pinger struct {
+	client *http.Client
+	log    *slog.Logger
+	queue  sendQueue[pingRequest]
+}
+
+// pingRequest is a ping waiting to be sent
+type pingRequest struct {
+	entry, pingURL, suffix, body string
+}
+
+func newPinger() *pinger {
+	p := &pinger{client: &http.Client{Timeout: pingTimeout}, log: slog.Default()}
+	p.queue.deliver = p.ping
+	return p
+}
+
+func (p *pinger) entryStarted(backup Backup) {
+	if backup.PingURL != "" {
+		p.queue.enqueue(pingRequest{backup.Name, backup.PingURL, "start", ""})
+	}
+}
+
+func (p *pinger) entryFinished(result outcome) {
+	if result.backup.PingURL == "" {
+		return
+	}
+	if result.status == StatusSucceeded {
+		p.queue.enqueue(pingRequest{result.entry, result.backup.PingURL, "", ""})
+		return
+	}
+	body := result.err.Error()
+	if result.output != "" {
+		body += "\n\n" + result.output
+	}
+	if len(body) > pingBodyLimit {
+		body = body[len(body)-pingBodyLimit:]
+	}
+	p.queue.enqueue(pingRequest{result.entry, result.backup.PingURL, "fail", body})
+}
+
+func (p *pinger) runFinished(report runReport) {
+	p.flush(pingFlushTimeout)
+}
+
+// close sends the pings still queued and drops idle connections
+func (p *pinger) close() {
+	p.flush(pingFlushTimeout)
+	p.client.CloseIdleConnections()
+}
+
+// flush waits up to timeout for the queued pings to be sent, then drops
+// those that are left
+func (p *pinger) flush(timeout time.Duration) {
+	if dropped := p.queue.flush(timeout); dropped > 0 {
+		p.log.Warn("Dropping pings that could not be sent in time", "count", dropped)
+	}
+}
+
+// ping requests the PingURL with suffix appended to its path, retrying with
+// exponential backoff until ctx is cancelled
+func (p *pinger) ping(ctx context.Context, req pingRequest) {
+	target, err := pingTarget(req.pingURL, req.suffix)
+	if err != nil {
+		p.log.Warn("Invalid PingURL", "entry", req.entry, "error", err)
+		return
+	}
+	delay := pingRetryDelay
+	attempt := 1
+	for ; ; attempt++ {
+		err = p.request(ctx, target, req.body)
+		if err == nil {
+			return
+		}
+		if attempt >= pingAttempts {
+			break
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		delay *= 2
+	}
+	p.log.Warn("Ping failed", "entry", req.entry, "attempts", attempt, "error", err)
+}
+
+// request makes a single ping request. Pings with a body are POSTed.
+func (p *pinger) request(ctx context.Context, target, body string) error {
+	method := http.MethodGet
+	if body != "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	req.Header.Set("User-Agent", "gobackup/"+VERSION)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", req.URL.Redacted(), resp.Status)
+	}
+	return nil
+}
+
+// pingTarget appends /suffix to the path of pingURL, keeping any query string
+func pingTarget(pingURL, suffix string) (string, error) {
+	u, err := url.Parse(pingURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if suffix != "" {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + suffix
+		u.RawPath = ""
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,147 @@
+package gobackup
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pingServer records "METHOD path body" for every request and fails the
+// first failures requests
+func pingServer(t *testing.T, failures int) (*httptest.Server, func() []string) {
+	t.Helper()
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, strings.TrimSpace(r.Method+" "+r.URL.RequestURI()+" "+firstLine(string(body))))
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, requests...)
+	}
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+func TestPingsThroughRun(t *testing.T) {
+	server, requests := pingServer(t, 0)
+	t.Setenv("SCRATCH", t.TempDir())
+	library := map[string]Backup{
+		"good": {Type: "tar", Source: t.TempDir(), Destination: t.TempDir(), Retain: 1, ChangeDir: true, PingURL: server.URL + "/good"},
+		"bad":  {Type: "tar", Source: t.TempDir(), Destination: "/nonexistent/gobackup", Retain: 1, ChangeDir: true, PingURL: server.URL + "/bad?create=1"},
+		"after": {Type: "tar", Source: t.TempDir(), Destination: t.TempDir(), Retain: 1, ChangeDir: true,
+			DependsOn: []string{"bad"}, PingURL: server.URL + "/after"},
+		"silent": {Type: "tar", Source: t.TempDir(), Destination: t.TempDir(), Retain: 1, ChangeDir: true},
+	}
+	p := newPinger()
+	p.log = discardLog
+	opts := DefaultRunOptions()
+	opts.observers = []runObserver{p}
+	runSequential(context.Background(), []string{"good", "bad", "after", "silent"}, library, opts)
+	p.runFinished(runReport{})
+
+	want := []string{
+		"GET /good/start",
+		"GET /good",
+		"GET /bad/start?create=1",
+		"POST /bad/fail?create=1 tar backup failed for 'bad': destination directory does not exist: /nonexistent/gobackup",
+		"POST /after/fail skipped 'after': prerequisite 'bad' did not succeed",
+	}
+	if got := requests(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("pings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestPingRetries(t *testing.T) {
+	defer func(delay time.Duration) { pingRetryDelay = delay }(pingRetryDelay)
+	pingRetryDelay = time.Millisecond
+
+	server, requests := pingServer(t, 2)
+	p := newPinger()
+	var out strings.Builder
+	p.log = slog.New(slog.NewTextHandler(&out, nil))
+	p.entryStarted(Backup{Name: "photos", PingURL: server.URL})
+	p.flush(time.Minute)
+	if got := requests(); len(got) != 3 {
+		t.Errorf("made %d attempts, want 3: %v", len(got), got)
+	}
+	if out.Len() != 0 {
+		t.Errorf("unexpected warning after a successful retry: %s", out.String())
+	}
+
+	// Once every attempt has failed only a warning is printed
+	server, requests = pingServer(t, 10)
+	p.entryStarted(Backup{Name: "photos", PingURL: server.URL})
+	p.flush(time.Minute)
+	if got := requests(); len(got) != pingAttempts {
+		t.Errorf("made %d attempts, want %d", len(got), pingAttempts)
+	}
+	if !strings.Contains(out.String(), `msg="Ping failed" entry=photos attempts=3`) {
+		t.Errorf("missing warning, got %q", out.String())
+	}
+}
+
+func TestPingsDoNotHoldUpEntries(t *testing.T) {
+	defer func(delay time.Duration) { pingRetryDelay = delay }(pingRetryDelay)
+	pingRetryDelay = time.Hour
+
+	// The first ping fails, leaving the pinger waiting to retry
+	server, requests := pingServer(t, 1)
+	p := newPinger()
+	var out strings.Builder
+	p.log = slog.New(slog.NewTextHandler(&out, nil))
+	started := time.Now()
+	p.entryStarted(Backup{Name: "photos", PingURL: server.URL})
+	p.entryStarted(Backup{Name: "music", PingURL: server.URL})
+	p.flush(50 * time.Millisecond)
+	if elapsed := time.Since(started); elapsed > 10*time.Second {
+		t.Errorf("pings held up the run for %v", elapsed)
+	}
+	if got := requests(); len(got) != 1 {
+		t.Errorf("made %d requests, want the retry and the queued ping dropped: %v", len(got), got)
+	}
+	for _, want := range []string{`msg="Ping failed" entry=photos attempts=1`, `msg="Dropping pings that could not be sent in time" count=1`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("missing %q in %q", want, out.String())
+		}
+	}
+}
+
+func TestPingTarget(t *testing.T) {
+	tests := []struct {
+		url, suffix, want string
+	}{
+		{"https://hc-ping.com/abc", "start", "https://hc-ping.com/abc/start"},
+		{"https://hc-ping.com/abc/", "fail", "https://hc-ping.com/abc/fail"},
+		{"https://hc-ping.com/abc", "", "https://hc-ping.com/abc"},
+		{"http://kuma.lan/api/push/xyz?status=up", "start", "http://kuma.lan/api/push/xyz/start?status=up"},
+	}
+	for _, tt := range tests {
+		if got, err := pingTarget(tt.url, tt.suffix); err != nil || got != tt.want {
+			t.Errorf("pingTarget(%q, %q) = %q, %v, want %q", tt.url, tt.suffix, got, err, tt.want)
+		}
+	}
+	if _, err := pingTarget("ftp://example.com/x", ""); err == nil {
+		t.Error("pingTarget() accepted an ftp URL")
+	}
+}
@@ -0,0 +1,242 @@
+package gobackup
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RunOptions controls how the entries of a run are executed
+type RunOptions struct {
+	Jobs           int           // global limit on concurrently running entries
+	PerDestination int           // limit per destination disk
+	PerHost        int           // limit per remote rsync host
+	LockWait       time.Duration // how long to wait for an entry held by another run
+	LogFormat      string        // format of per-run entry log files, text or json
+	DryRun         bool          // print what would be done instead of doing it
+	Bar            *ProgressBar  // draws progress on a terminal instead of logging it
+	// StateDir is where the run history and the notification, MQTT,
+	// metrics and schedule state are kept, by default $GOBACKUP_STATE or
+	// ~/.local/state/gobackup
+	StateDir string
+
+	runID     string
+	observers []runObserver
+	limiter   *limiter     // shared by the runs of the daemon, nil for one per run
+	logger    *slog.Logger // the entries log to, slog.Default() if nil
+}
+
+// progress returns where the progress of an entry goes: to the observers
+// that want it and to the progress bar or, without one, to log
+func (o RunOptions) progress(log *slog.Logger) ProgressFunc {
+	render := progressLogger(log)
+	if o.Bar != nil {
+		render = o.Bar.update
+	}
+	return func(e Progress) {
+		for _, observer := range o.observers {
+			if p, ok := observer.(progressObserver); ok {
+				p.entryProgress(e)
+			}
+		}
+		render(e)
+	}
+}
+
+// entryLogger returns the logger for entry, carrying the run ID if set
+func (o RunOptions) entryLogger(entry string) *slog.Logger {
+	log := o.logger
+	if log == nil {
+		log = slog.Default()
+	}
+	if o.runID == "" {
+		return log.With("entry", entry)
+	}
+	return log.With("run_id", o.runID, "entry", entry)
+}
+
+// runObserver is told when every entry starts and finishes and when every
+// run finishes. Entries of a parallel run finish concurrently, so
+// implementations must be safe for concurrent use.
+type runObserver interface {
+	entryStarted(backup Backup)
+	entryFinished(result outcome)
+	runFinished(report runReport)
+}
+
+// observerCloser is implemented by run observers that hold on to work or
+// resources once a run has finished
+type observerCloser interface {
+	close()
+}
+
+// closeObservers closes the observers that need it, once no run reports to
+// them any more
+func closeObservers(observers []runObserver) {
+	for _, observer := range observers {
+		if c, ok := observer.(observerCloser); ok {
+			c.close()
+		}
+	}
+}
+
+func (o RunOptions) entryStarted(backup Backup) {
+	for _, observer := range o.observers {
+		observer.entryStarted(backup)
+	}
+}
+
+func (o RunOptions) entryFinished(result outcome) {
+	for _, observer := range o.observers {
+		observer.entryFinished(result)
+	}
+}
+
+func (o RunOptions) runFinished(report runReport) {
+	for _, observer := range o.observers {
+		observer.runFinished(report)
+	}
+}
+
+// DefaultRunOptions runs entries one at a time, as gobackup always has
+func DefaultRunOptions() RunOptions {
+	return RunOptions{Jobs: 1, PerDestination: 1, PerHost: 2}
+}
+
+// limiter hands out slots for a global job limit plus per-resource limits.
+// A job either gets every slot it asks for or waits, so two jobs can never
+// each hold half of what the other needs.
+type limiter struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	jobs   int
+	max    int
+	counts map[string]int
+	limits map[string]int
+}
+
+func newLimiter(max int) *limiter {
+	if max < 1 {
+		max = 1
+	}
+	l := &limiter{
+		max:    max,
+		counts: make(map[string]int),
+		limits: make(map[string]int),
+	}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// setLimit caps the number of jobs that may hold resource key at once.
+// A limit below 1 means unlimited.
+func (l *limiter) setLimit(key string, limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limit < 1 {
+		delete(l.limits, key)
+		return
+	}
+	l.limits[key] = limit
+}
+
+func (l *limiter) available(keys []string) bool {
+	if l.jobs >= l.max {
+		return false
+	}
+	for _, key := range keys {
+		if limit, ok := l.limits[key]; ok && l.counts[key] >= limit {
+			return false
+		}
+	}
+	return true
+}
+
+// acquire blocks until a global slot and a slot for every key are free. It
+// takes nothing and returns false if ctx is done first.
+func (l *limiter) acquire(ctx context.Context, keys []string) bool {
+	stop := context.AfterFunc(ctx, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.cond.Broadcast()
+	})
+	defer stop()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for ctx.Err() == nil && !l.available(keys) {
+		l.cond.Wait()
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+	l.jobs++
+	for _, key := range keys {
+		l.counts[key]++
+	}
+	return true
+}
+
+// release returns the slots taken by acquire
+func (l *limiter) release(keys []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.jobs--
+	for _, key := range keys {
+		l.counts[key]--
+		if l.counts[key] == 0 {
+			delete(l.counts, key)
+		}
+	}
+	l.cond.Broadcast()
+}
+
+// resourceKeys returns the per-resource limiter keys a backup entry needs
+func resourceKeys(backup *Backup) []string {
+	var keys []string
+	if backup.Destination != "" {
+		keys = append(keys, "dest:"+destinationDevice(backup.Destination))
+	}
+	if host := resourcesOf(backup).Host; host != "" {
+		keys = append(keys, "host:"+host)
+	}
+	return keys
+}
+
+// destinationDevice identifies the disk a destination lives on. Falls back
+// to the cleaned path when the directory cannot be inspected.
+func destinationDevice(dest string) string {
+	info, err := os.Stat(dest)
+	if err == nil {
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			return fmt.Sprintf("dev%d", stat.Dev)
+		}
+	}
+	abs, err := filepath.Abs(dest)
+	if err != nil {
+		return filepath.Clean(dest)
+	}
+	return abs
+}
+
+// remoteHost extracts the host from an rsync source such as
+// user@host:/path or host::module. Local paths return an empty string.
+func remoteHost(source string) string {
+	idx := strings.Index(source, ":")
+	if idx <= 0 {
+		return ""
+	}
+	hostPart := source[:idx]
+	if strings.Contains(hostPart, "/") {
+		return ""
+	}
+	if at := strings.LastIndex(hostPart, "@"); at >= 0 {
+		hostPart = hostPart[at+1:]
+	}
+	return hostPart
+}
@@ -0,0 +1,106 @@
+package gobackup
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRemoteHost(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"snowpea@10.0.0.173:/home/snowpea/torado", "10.0.0.173"},
+		{"host:/path", "host"},
+		{"host::module/path", "host"},
+		{"/local/path", ""},
+		{"./relative:with/colon", ""},
+		{"~/test", ""},
+	}
+
+	for _, tt := range tests {
+		if got := remoteHost(tt.source); got != tt.expected {
+			t.Errorf("remoteHost(%q) = %q, want %q", tt.source, got, tt.expected)
+		}
+	}
+}
+
+func TestResourceKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	local := Backup{Type: "tar", Source: "/src", Destination: dir}
+	keys := resourceKeys(&local)
+	if len(keys) != 1 || !strings.HasPrefix(keys[0], "dest:") {
+		t.Errorf("tar keys = %v, want a single dest key", keys)
+	}
+
+	remote := Backup{Type: "rsync", Source: "user@nas:/data", Destination: dir}
+	keys = resourceKeys(&remote)
+	if len(keys) != 2 || keys[1] != "host:nas" {
+		t.Errorf("rsync keys = %v, want dest key and host:nas", keys)
+	}
+}
+
+func TestLimiterRespectsLimits(t *testing.T) {
+	lim := newLimiter(4)
+	lim.setLimit("dest:a", 1)
+
+	var (
+		mu      sync.Mutex
+		running int
+		peak    int
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			keys := []string{"dest:a"}
+			lim.acquire(context.Background(), keys)
+			mu.Lock()
+			running++
+			if running > peak {
+				peak = running
+			}
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			lim.release(keys)
+		}()
+	}
+	wg.Wait()
+
+	if peak != 1 {
+		t.Errorf("peak concurrency on dest:a = %d, want 1", peak)
+	}
+}
+
+func TestLimiterGlobalJobs(t *testing.T) {
+	lim := newLimiter(2)
+	lim.acquire(context.Background(), nil)
+	lim.acquire(context.Background(), nil)
+
+	acquired := make(chan struct{})
+	go func() {
+		lim.acquire(context.Background(), nil)
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("third job acquired a slot with --jobs 2")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	lim.release(nil)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("third job never acquired a slot after release")
+	}
+}
@@ -0,0 +1,479 @@
+package gobackup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// progressInterval is how often a running phase reports progress and
+	// progressLogInterval how often that is logged when there is no
+	// progress bar
+	progressInterval    = time.Second
+	progressLogInterval = 30 * time.Second
+)
+
+// Progress is a snapshot of how far one phase of an entry has got
+type Progress struct {
+	Entry   string  `json:"Entry"`
+	Phase   string  `json:"Phase"`   // tar, rsync or command
+	Bytes   int64   `json:"Bytes"`   // read from the source, transferred for rsync
+	Total   int64   `json:"Total"`   // expected Bytes, 0 if unknown
+	Files   int64   `json:"Files"`   // files processed
+	Written int64   `json:"Written"` // archive bytes written
+	Rate    float64 `json:"Rate"`    // bytes per second
+	Percent float64 `json:"Percent"` // -1 if unknown
+	Elapsed float64 `json:"Elapsed"` // seconds
+	ETA     float64 `json:"ETA"`     // seconds, -1 if unknown
+	Done    bool    `json:"Done"`    // the phase has finished
+}
+
+// ProgressFunc receives the progress of an entry. A nil ProgressFunc
+// discards it.
+type ProgressFunc func(Progress)
+
+// progressObserver is implemented by run observers that also want the
+// progress of running entries
+type progressObserver interface {
+	entryProgress(event Progress)
+}
+
+// progressMeter counts what a phase has done and reports it every
+// progressInterval until finished
+type progressMeter struct {
+	report       ProgressFunc
+	entry, phase string
+	output       string // file whose size is the bytes written
+	started      time.Time
+
+	bytes, files, total atomic.Int64
+	totalWritten        atomic.Int64 // expected bytes written, used when total is unknown
+	totalFiles          atomic.Int64 // expected files, for phases that count files but not bytes
+
+	stop, done chan struct{}
+}
+
+// startProgress starts reporting the progress of phase. Nothing runs in
+// the background if report is nil.
+func startProgress(report ProgressFunc, entry, phase, output string) *progressMeter {
+	m := &progressMeter{report: report, entry: entry, phase: phase, output: output, started: time.Now(),
+		stop: make(chan struct{}), done: make(chan struct{})}
+	if report == nil {
+		close(m.done)
+		return m
+	}
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.report(m.event(false))
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// finish stops the meter and reports the final state of the phase
+func (m *progressMeter) finish() {
+	select {
+	case <-m.stop:
+		return
+	default:
+	}
+	close(m.stop)
+	<-m.done
+	if m.report != nil {
+		m.report(m.event(true))
+	}
+}
+
+// stopped reports whether finish has been called, so background scans can
+// give up early
+func (m *progressMeter) stopped() bool {
+	select {
+	case <-m.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *progressMeter) event(done bool) Progress {
+	elapsed := time.Since(m.started)
+	e := Progress{
+		Entry: m.entry, Phase: m.phase, Done: done,
+		Bytes: m.bytes.Load(), Total: m.total.Load(), Files: m.files.Load(),
+		Elapsed: elapsed.Seconds(), Percent: -1, ETA: -1,
+	}
+	// Phases that only count files estimate the bytes read from the share
+	// of the expected files they have got through
+	if files := m.totalFiles.Load(); files > 0 && e.Bytes == 0 {
+		e.Bytes = e.Total * min(e.Files, files) / files
+	}
+	if m.output != "" {
+		if info, err := os.Stat(m.output); err == nil {
+			e.Written = info.Size()
+		}
+	}
+	// Without a known source size, compare what has been written with the
+	// size of the previous archive instead
+	current, expected := e.Bytes, e.Total
+	if expected <= 0 {
+		current, expected = e.Written, m.totalWritten.Load()
+	}
+	if elapsed > 0 {
+		e.Rate = float64(current) / elapsed.Seconds()
+	}
+	if expected > 0 {
+		e.Percent = min(100, 100*float64(current)/float64(expected))
+		if current > 0 {
+			e.ETA = max(0, elapsed.Seconds()*float64(expected-current)/float64(current))
+		}
+	}
+	if done {
+		e.ETA = 0
+	}
+	return e
+}
+
+// scanSource sums the size of the files under dir not matched by excludes,
+// setting it and the number of files as the expected totals of m. It gives
+// up once m is finished.
+func scanSource(m *progressMeter, dir string, excludes []string) {
+	var total, files int64
+	err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if m.stopped() {
+			return filepath.SkipAll
+		}
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, file)
+		if rel != "." && excludedPath(filepath.ToSlash(rel), excludes) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			files++
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	if err == nil && !m.stopped() {
+		m.total.Store(total)
+		m.totalFiles.Store(files)
+	}
+}
+
+// progressLogger logs the progress of one entry every progressLogInterval
+func progressLogger(log *slog.Logger) ProgressFunc {
+	last := time.Now()
+	return func(e Progress) {
+		if e.Done || time.Since(last) < progressLogInterval {
+			return
+		}
+		last = time.Now()
+		args := []any{"phase", e.Phase, "files", e.Files}
+		if e.Bytes > 0 {
+			args = append(args, "read", formatSize(e.Bytes))
+		}
+		if e.Written > 0 {
+			args = append(args, "written", formatSize(e.Written))
+		}
+		args = append(args, "rate", formatSize(int64(e.Rate))+"/s")
+		if e.Percent >= 0 {
+			args = append(args, "percent", strconv.FormatFloat(e.Percent, 'f', 1, 64))
+		}
+		if e.ETA >= 0 {
+			args = append(args, "eta", formatETA(e.ETA))
+		}
+		log.Info("Progress", args...)
+	}
+}
+
+// ProgressBar draws the progress of running entries on the last line of a
+// terminal. Log records are written through it, so it can clear the bar
+// before each record and draw it again below.
+type ProgressBar struct {
+	out   io.Writer
+	width int
+
+	mu      sync.Mutex
+	entries map[string]Progress
+	drawn   bool
+}
+
+// NewProgressBar draws on out, usually a terminal
+func NewProgressBar(out io.Writer) *ProgressBar {
+	width := 80
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 20 {
+		width = columns
+	}
+	return &ProgressBar{out: out, width: width, entries: make(map[string]Progress)}
+}
+
+func (b *ProgressBar) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clear()
+	n, err := b.out.Write(p)
+	b.draw()
+	return n, err
+}
+
+// update shows e, removing the entry from the bar once its phase is done
+func (b *ProgressBar) update(e Progress) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e.Done {
+		delete(b.entries, e.Entry)
+	} else {
+		b.entries[e.Entry] = e
+	}
+	b.clear()
+	b.draw()
+}
+
+func (b *ProgressBar) clear() {
+	if b.drawn {
+		io.WriteString(b.out, "\r\033[K")
+		b.drawn = false
+	}
+}
+
+func (b *ProgressBar) draw() {
+	if len(b.entries) == 0 {
+		return
+	}
+	line := b.line()
+	if len(line) > b.width-1 {
+		line = line[:b.width-1]
+	}
+	io.WriteString(b.out, line)
+	b.drawn = true
+}
+
+// line renders a full bar for a single entry, or a short summary of each
+// entry when several run in parallel
+func (b *ProgressBar) line() string {
+	names := make([]string, 0, len(b.entries))
+	for name := range b.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 1 {
+		e := b.entries[names[0]]
+		parts := []string{e.Entry, e.Phase}
+		if e.Percent >= 0 {
+			const slots = 20
+			filled := int(e.Percent / 100 * slots)
+			parts = append(parts, "["+strings.Repeat("#", filled)+strings.Repeat(".", slots-filled)+"]", fmt.Sprintf("%.0f%%", e.Percent))
+		}
+		return strings.Join(append(parts, e.summary()...), " ")
+	}
+	var parts []string
+	for _, name := range names {
+		e := b.entries[name]
+		part := e.Entry + " " + e.Phase
+		if e.Percent >= 0 {
+			part += fmt.Sprintf(" %.0f%%", e.Percent)
+		}
+		if e.ETA >= 0 {
+			part += " ETA " + formatETA(e.ETA)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " | ")
+}
+
+// summary describes the amounts of e for people
+func (e Progress) summary() []string {
+	var parts []string
+	switch {
+	case e.Total > 0:
+		parts = append(parts, formatSize(e.Bytes)+"/"+formatSize(e.Total))
+	case e.Bytes > 0:
+		parts = append(parts, formatSize(e.Bytes))
+	}
+	if e.Files > 0 {
+		parts = append(parts, fmt.Sprintf("%d files", e.Files))
+	}
+	if e.Written > 0 {
+		parts = append(parts, formatSize(e.Written)+" written")
+	}
+	parts = append(parts, formatSize(int64(e.Rate))+"/s")
+	if e.ETA >= 0 {
+		parts = append(parts, "ETA "+formatETA(e.ETA))
+	}
+	return parts
+}
+
+// formatETA renders seconds as h:mm:ss
+func formatETA(seconds float64) string {
+	s := int64(seconds + 0.5)
+	return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+}
+
+// IsTerminal reports whether f is a character device such as a terminal
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// lastArchiveSize returns the size of the last archive written for backup,
+// from the run history, or 0 if none is known
+func lastArchiveSize(backup *Backup) int64 {
+	entry := backup.Name
+	records, err := loadHistory(historyPath(backup.stateDir))
+	if err != nil {
+		return 0
+	}
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].Entry == entry && records[i].Status == StatusSucceeded && records[i].Size > 0 {
+			return records[i].Size
+		}
+	}
+	return 0
+}
+
+// progressTracker keeps the latest progress of every running entry for the
+// daemon's API. /progress returns it as JSON and /events streams started,
+// progress and finished events as server-sent events.
+type progressTracker struct {
+	mu          sync.Mutex
+	running     map[string]Progress
+	subscribers map[chan trackerEvent]struct{}
+}
+
+// trackerEvent is one server-sent event
+type trackerEvent struct {
+	kind string
+	data any
+}
+
+// entryOutcome is the data of a finished event
+type entryOutcome struct {
+	Entry  string `json:"Entry"`
+	Status string `json:"Status"`
+	Error  string `json:"Error,omitempty"`
+}
+
+// trackerBuffer is how many events a slow /events client may fall behind
+// before events are dropped for it
+const trackerBuffer = 64
+
+func newProgressTracker() *progressTracker {
+	return &progressTracker{running: make(map[string]Progress), subscribers: make(map[chan trackerEvent]struct{})}
+}
+
+func (t *progressTracker) entryStarted(backup Backup) {
+	e := Progress{Entry: backup.Name, Percent: -1, ETA: -1}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.running[backup.Name] = e
+	t.publish(trackerEvent{"started", e})
+}
+
+func (t *progressTracker) entryProgress(e Progress) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.running[e.Entry]; ok {
+		t.running[e.Entry] = e
+	}
+	t.publish(trackerEvent{"progress", e})
+}
+
+func (t *progressTracker) entryFinished(result outcome) {
+	data := entryOutcome{Entry: result.entry, Status: result.status}
+	if result.err != nil {
+		data.Error = result.err.Error()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.running, result.entry)
+	t.publish(trackerEvent{"finished", data})
+}
+
+func (t *progressTracker) runFinished(report runReport) {}
+
+// publish hands e to every subscriber that has room for it. The caller
+// must hold t.mu.
+func (t *progressTracker) publish(e trackerEvent) {
+	for ch := range t.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// serveProgress returns the latest progress of every running entry
+func (t *progressTracker) serveProgress(w http.ResponseWriter, r *http.Request) {
+	t.mu.Lock()
+	events := make([]Progress, 0, len(t.running))
+	for _, name := range sortedKeys(t.running) {
+		events = append(events, t.running[name])
+	}
+	t.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// serveEvents streams events until the client goes away
+func (t *progressTracker) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	ch := make(chan trackerEvent, trackerBuffer)
+	t.mu.Lock()
+	t.subscribers[ch] = struct{}{}
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(t.subscribers, ch)
+		t.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case e := <-ch:
+			data, err := json.Marshal(e.data)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.kind, data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package gobackup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProgressMeterPercentAndETA(t *testing.T) {
+	m := startProgress(nil, "photos", "tar", "")
+	m.started = time.Now().Add(-10 * time.Second)
+	m.bytes.Store(250)
+	m.total.Store(1000)
+	m.files.Store(3)
+
+	e := m.event(false)
+	if e.Entry != "photos" || e.Phase != "tar" || e.Files != 3 {
+		t.Errorf("event = %+v", e)
+	}
+	if e.Percent != 25 {
+		t.Errorf("Percent = %v, want 25", e.Percent)
+	}
+	// A quarter took 10s, so the rest should take about 30s
+	if e.ETA < 29 || e.ETA > 31 {
+		t.Errorf("ETA = %v, want about 30", e.ETA)
+	}
+	if e.Rate < 24 || e.Rate > 26 {
+		t.Errorf("Rate = %v, want about 25", e.Rate)
+	}
+}
+
+func TestProgressMeterFallsBackToWrittenSize(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "archive")
+	os.WriteFile(output, make([]byte, 400), 0644)
+	m := startProgress(nil, "db", "command", output)
+	m.totalWritten.Store(800)
+
+	e := m.event(false)
+	if e.Written != 400 || e.Percent != 50 {
+		t.Errorf("Written = %d, Percent = %v, want 400 and 50", e.Written, e.Percent)
+	}
+
+	m = startProgress(nil, "db", "command", output)
+	if e := m.event(false); e.Percent != -1 || e.ETA != -1 {
+		t.Errorf("without an expected size Percent = %v, ETA = %v, want -1", e.Percent, e.ETA)
+	}
+}
+
+func TestProgressMeterEstimatesBytesFromFiles(t *testing.T) {
+	m := startProgress(nil, "photos", "tar", "")
+	m.total.Store(1000)
+	m.totalFiles.Store(4)
+	m.files.Store(1)
+
+	if e := m.event(false); e.Bytes != 250 || e.Percent != 25 {
+		t.Errorf("Bytes = %d, Percent = %v, want 250 and 25", e.Bytes, e.Percent)
+	}
+	// tar can list more than the scan found if files appear meanwhile
+	m.files.Store(6)
+	if e := m.event(false); e.Bytes != 1000 {
+		t.Errorf("Bytes = %d, want at most the scanned 1000", e.Bytes)
+	}
+}
+
+func TestTarReportsProgress(t *testing.T) {
+	t.Setenv("SCRATCH", t.TempDir())
+	source := t.TempDir()
+	os.WriteFile(filepath.Join(source, "a.txt"), make([]byte, 1500), 0644)
+	os.MkdirAll(filepath.Join(source, "sub"), 0755)
+	os.WriteFile(filepath.Join(source, "sub", "b.txt"), make([]byte, 500), 0644)
+	backup := Backup{Name: "photos", Type: "tar", Source: source, Destination: t.TempDir(), ChangeDir: true, Retain: 1}
+
+	var mu sync.Mutex
+	var events []Progress
+	_, err := tar(context.Background(), &backup, discardLog, func(e Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, e)
+	})
+	if err != nil {
+		t.Fatalf("tar() failed: %v", err)
+	}
+	if len(events) == 0 {
+		t.Fatal("no progress reported")
+	}
+	last := events[len(events)-1]
+	if !last.Done || last.Files != 2 || last.Bytes != 2000 || last.Written == 0 {
+		t.Errorf("final event = %+v, want done with 2 files, 2000 bytes read and something written", last)
+	}
+}
+
+func TestParseRsyncProgress(t *testing.T) {
+	tests := []struct {
+		line string
+		want rsyncProgress
+		ok   bool
+	}{
+		{"      1,234,567  12%    1.05MB/s    0:00:09", rsyncProgress{bytes: 1234567, percent: 12, files: -1}, true},
+		{"  3.50G  85%   10.00MB/s    0:01:02 (xfr#42, ir-chk=1000/1234)", rsyncProgress{bytes: 3500000000, percent: 85, files: 234}, true},
+		{"    524288 100%  512.00kB/s    0:00:00 (xfr#7, to-chk=0/7)", rsyncProgress{bytes: 524288, percent: 100, files: 7}, true},
+		{"receiving incremental file list", rsyncProgress{}, false},
+		{"photos/2024/a.jpg", rsyncProgress{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRsyncProgress(tt.line)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseRsyncProgress(%q) = %+v, %v, want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestProgressBarRedrawsAroundLogLines(t *testing.T) {
+	var out bytes.Buffer
+	bar := NewProgressBar(&out)
+	bar.update(Progress{Entry: "photos", Phase: "tar", Bytes: 512, Total: 1024, Percent: 50, ETA: 65})
+	if !strings.Contains(out.String(), "photos tar [##########..........] 50%") || !strings.Contains(out.String(), "ETA 0:01:05") {
+		t.Errorf("bar = %q", out.String())
+	}
+
+	out.Reset()
+	bar.Write([]byte("log line\n"))
+	if !strings.HasPrefix(out.String(), "\r\033[Klog line\nphotos tar") {
+		t.Errorf("log line was not written above the bar: %q", out.String())
+	}
+
+	out.Reset()
+	bar.update(Progress{Entry: "photos", Done: true})
+	bar.Write([]byte("done\n"))
+	if out.String() != "\r\033[Kdone\n" {
+		t.Errorf("bar was not removed when the entry finished: %q", out.String())
+	}
+}
+
+func TestProgressTrackerAPI(t *testing.T) {
+	tracker := newProgressTracker()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/progress", tracker.serveProgress)
+	mux.HandleFunc("/events", tracker.serveEvents)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	tracker.entryStarted(Backup{Name: "photos"})
+	tracker.entryProgress(Progress{Entry: "photos", Phase: "tar", Bytes: 100, Percent: -1, ETA: -1})
+
+	get := func() []Progress {
+		resp, err := http.Get(srv.URL + "/progress")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var events []Progress
+		if err := json.NewDecoder(resp.Body).Decode(&events); err != nil {
+			t.Fatal(err)
+		}
+		return events
+	}
+	if events := get(); len(events) != 1 || events[0].Bytes != 100 {
+		t.Errorf("/progress = %+v, want photos at 100 bytes", events)
+	}
+
+	tracker.entryFinished(outcome{entry: "photos", status: StatusFailed, err: errors.New("disk full")})
+	if events := get(); len(events) != 0 {
+		t.Errorf("/progress after finishing = %+v, want none", events)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	var kinds []string
+	for len(kinds) < 3 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading events: %v", err)
+		}
+		if kind, ok := strings.CutPrefix(line, "event: "); ok {
+			kinds = append(kinds, strings.TrimSpace(kind))
+		}
+		if strings.Contains(line, `"Status"`) && !strings.Contains(line, `"Error":"disk full"`) {
+			t.Errorf("finished event = %q", line)
+		}
+	}
+	if strings.Join(kinds, ",") != "started,progress,finished" {
+		t.Errorf("events = %v", kinds)
+	}
+}
@@ -0,0 +1,73 @@
+package gobackup
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sendQueue hands items to deliver in order to a goroutine of its own, so a
+// slow or unreachable service does not hold up the entries. The goroutine
+// only runs while there is something to deliver.
+type sendQueue[T any] struct {
+	deliver func(ctx context.Context, item T) // cancelled when a flush gives up
+
+	mu      sync.Mutex
+	items   []T
+	sending chan struct{}      // closed once the queue is empty, nil while idle
+	cancel  context.CancelFunc // stops the item being delivered
+}
+
+// enqueue queues an item, starting a goroutine to deliver it if none is
+// running
+func (q *sendQueue[T]) enqueue(item T) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.items = append(q.items, item)
+	if q.sending != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	q.sending, q.cancel = make(chan struct{}), cancel
+	go q.send(ctx, q.sending)
+}
+
+// send delivers the queued items until there are none left, then closes done
+func (q *sendQueue[T]) send(ctx context.Context, done chan struct{}) {
+	for {
+		q.mu.Lock()
+		if len(q.items) == 0 {
+			q.sending, q.cancel = nil, nil
+			q.mu.Unlock()
+			close(done)
+			return
+		}
+		item := q.items[0]
+		q.items = q.items[1:]
+		q.mu.Unlock()
+		q.deliver(ctx, item)
+	}
+}
+
+// flush waits up to timeout for the queued items to be delivered, then
+// drops those that are left and returns how many it dropped
+func (q *sendQueue[T]) flush(timeout time.Duration) int {
+	q.mu.Lock()
+	done, cancel := q.sending, q.cancel
+	q.mu.Unlock()
+	if done == nil {
+		return 0
+	}
+	select {
+	case <-done:
+		return 0
+	case <-time.After(timeout):
+	}
+	q.mu.Lock()
+	dropped := len(q.items)
+	q.items = nil
+	q.mu.Unlock()
+	cancel()
+	<-done
+	return dropped
+}
@@ -0,0 +1,153 @@
+package gobackup
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// BackupType is a kind of backup, selected by the Type of an entry. The
+// built-in tar, rsync and command types register themselves; other types
+// are added with RegisterBackupType.
+type BackupType interface {
+	// Validate returns every problem with the settings of backup that
+	// belong to this type, including missing fields it requires such as
+	// Source and Destination, without touching the filesystem or network
+	Validate(backup *Backup) []error
+	// Plan fills in what Run would do for backup, without writing anything.
+	// The Tar, Store and ListLocal helpers of Plan describe the common steps.
+	Plan(backup *Backup, plan *Plan)
+	// Run backs up backup. Cancelling ctx must stop it and clean up.
+	Run(ctx context.Context, backup *Backup, log *slog.Logger, progress ProgressFunc) (*BackupResult, error)
+	// Snapshots returns the stored backups of backup, oldest first
+	Snapshots(backup *Backup) ([]Snapshot, error)
+	// Restore puts the contents of snapshot into the directory target
+	Restore(ctx context.Context, backup *Backup, snapshot Snapshot, target string, log *slog.Logger) error
+}
+
+// Resources are what an entry uses besides its Destination. Entries with
+// the same ScratchDir never run at the same time, and --per-host limits how
+// many entries read from a Host at once.
+type Resources struct {
+	ScratchDir string // directory kept between runs, empty for none
+	Host       string // remote host the entry reads from, empty for none
+}
+
+// ResourceUser is implemented by backup types whose entries use Resources
+type ResourceUser interface {
+	Resources(backup *Backup) Resources
+}
+
+var (
+	backupTypesMu sync.RWMutex
+	backupTypes   = make(map[string]BackupType)
+)
+
+// RegisterBackupType makes t run entries whose Type is name. Like
+// database/sql.Register, it panics if name is empty or already registered,
+// as that is a programming error.
+func RegisterBackupType(name string, t BackupType) {
+	backupTypesMu.Lock()
+	defer backupTypesMu.Unlock()
+	if name == "" || t == nil {
+		panic("gobackup: RegisterBackupType needs a name and a type")
+	}
+	if _, dup := backupTypes[name]; dup {
+		panic(fmt.Sprintf("gobackup: backup type %q registered twice", name))
+	}
+	backupTypes[name] = t
+}
+
+// BackupTypes returns the names of the registered backup types, sorted
+func BackupTypes() []string {
+	backupTypesMu.RLock()
+	defer backupTypesMu.RUnlock()
+	names := make([]string, 0, len(backupTypes))
+	for name := range backupTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// UnknownBackupTypeError is returned for an entry whose Type is not
+// registered
+type UnknownBackupTypeError struct {
+	Type       string
+	Registered []string
+}
+
+func (e *UnknownBackupTypeError) Error() string {
+	return fmt.Sprintf("unknown Type %q (registered: %s)", e.Type, strings.Join(e.Registered, ", "))
+}
+
+// backupTypeFor returns the registered type called name
+func backupTypeFor(name string) (BackupType, error) {
+	backupTypesMu.RLock()
+	t, ok := backupTypes[name]
+	backupTypesMu.RUnlock()
+	if !ok {
+		return nil, &UnknownBackupTypeError{Type: name, Registered: BackupTypes()}
+	}
+	return t, nil
+}
+
+// resourcesOf returns the Resources backup uses, none if its type does not
+// declare any
+func resourcesOf(backup *Backup) Resources {
+	t, err := backupTypeFor(backup.Type)
+	if err != nil {
+		return Resources{}
+	}
+	if user, ok := t.(ResourceUser); ok {
+		return user.Resources(backup)
+	}
+	return Resources{}
+}
+
+// Restore puts the contents of snapshot, a stored backup of entry, into the
+// directory target, creating it if needed
+func (r *Runner) Restore(ctx context.Context, entry string, snapshot Snapshot, target string) error {
+	backup, exists := r.Library.Entries[entry]
+	if !exists {
+		return fmt.Errorf("no backup found with name '%s'", entry)
+	}
+	backup.Name = entry
+	t, err := backupTypeFor(backup.Type)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return fmt.Errorf("unable to create restore target: %w", err)
+	}
+	log := r.logger().With("entry", entry, "phase", "restore")
+	log.Info("Restoring snapshot", "snapshot", snapshot.Path, "target", target)
+	if err := t.Restore(ctx, &backup, snapshot, target, log); err != nil {
+		return fmt.Errorf("restoring %s failed: %w", snapshot.Path, err)
+	}
+	return nil
+}
+
+// runRestoreCommand runs the shell command that restores a snapshot with
+// program, which names it in errors. If ctx is cancelled it is killed.
+func runRestoreCommand(ctx context.Context, program, cmdString string, log *slog.Logger) error {
+	log.Info("Executing command", "command", cmdString)
+	tail := newTailBuffer(outputTailLines)
+	cmd := exec.CommandContext(ctx, "sh", "-c", cmdString)
+	cmd.Stdout = tail
+	cmd.Stderr = tail
+	killGroupOnCancel(cmd)
+	err := cmd.Run()
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("%s interrupted: %w", program, ctx.Err())
+	}
+	if err != nil {
+		return withOutput(fmt.Errorf("%s command failed: %w", program, err), tail)
+	}
+	return nil
+}
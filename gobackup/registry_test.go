@@ -0,0 +1,193 @@
+package gobackup
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// copyType is a backup type that copies a single file, registered to check
+// that types added outside the package are dispatched to everywhere
+type copyType struct{}
+
+func (copyType) Validate(backup *Backup) []error {
+	if backup.Source == "" {
+		return []error{errors.New("copy backup requires a Source")}
+	}
+	return nil
+}
+
+func (copyType) Plan(backup *Backup, plan *Plan) {
+	plan.Steps = append(plan.Steps, "copy "+backup.Source)
+	plan.Store(backup, "copy")
+}
+
+// Resources gives copies a mirror like rsync's, and a host read from when
+// the Source names one
+func (copyType) Resources(backup *Backup) Resources {
+	host, _, _ := strings.Cut(backup.Source, ":")
+	if host == backup.Source {
+		host = ""
+	}
+	return Resources{ScratchDir: filepath.Join(os.Getenv("SCRATCH"), backup.Name), Host: host}
+}
+
+func (copyType) Run(ctx context.Context, backup *Backup, log *slog.Logger, progress ProgressFunc) (*BackupResult, error) {
+	data, err := os.ReadFile(backup.Source)
+	if err != nil {
+		return nil, err
+	}
+	archive := filepath.Join(backup.Destination, backup.Name+"_2026.01.02_03.04.05.copy")
+	return &BackupResult{ArchivePath: archive}, os.WriteFile(archive, data, 0644)
+}
+
+func (copyType) Snapshots(backup *Backup) ([]Snapshot, error) {
+	return archiveSnapshots(backup)
+}
+
+func (copyType) Restore(ctx context.Context, backup *Backup, snapshot Snapshot, target string, log *slog.Logger) error {
+	data, err := os.ReadFile(snapshot.Path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(target, filepath.Base(backup.Source)), data, 0644)
+}
+
+func init() {
+	RegisterBackupType("test-copy", copyType{})
+}
+
+func TestRegisteredBackupType(t *testing.T) {
+	t.Setenv("GOBACKUP_STATE", t.TempDir())
+	source := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(source, []byte("notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	library := Library{Entries: map[string]Backup{
+		"notes": {Type: "test-copy", Source: source, Destination: t.TempDir()},
+	}}
+	runner := NewRunner(library, DefaultRunOptions())
+
+	if _, err := runner.Run(context.Background(), []string{"notes"}); err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	snapshots, err := runner.Snapshots("notes")
+	if err != nil || len(snapshots) != 1 {
+		t.Fatalf("Snapshots() = %v, %v, want one", snapshots, err)
+	}
+	target := filepath.Join(t.TempDir(), "restore")
+	if err := runner.Restore(context.Background(), "notes", snapshots[0], target); err != nil {
+		t.Fatalf("Restore() failed: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(target, "notes.txt")); string(data) != "notes" {
+		t.Errorf("restored %q, want %q", data, "notes")
+	}
+
+	backup := library.Entries["notes"]
+	backup.Name = "notes"
+	plan := planEntry(&backup, snapshots[0].Time)
+	if len(plan.Steps) != 1 || plan.Steps[0] != "copy "+source {
+		t.Errorf("plan steps = %q", plan.Steps)
+	}
+	if plan.Archive != snapshots[0].Path || len(plan.Problems) != 0 {
+		t.Errorf("plan archive = %q, problems %q, want %q", plan.Archive, plan.Problems, snapshots[0].Path)
+	}
+	backup.Source = ""
+	if problems := validateEntry(&backup); len(problems) != 1 || !strings.Contains(problems[0].Error(), "requires a Source") {
+		t.Errorf("validateEntry() = %v, want the type's own problem", problems)
+	}
+}
+
+func TestBackupTypeResources(t *testing.T) {
+	t.Setenv("GOBACKUP_STATE", t.TempDir())
+	t.Setenv("SCRATCH", t.TempDir())
+	backup := Backup{Name: "notes", Type: "test-copy", Source: "files.lan:/srv/notes", Destination: t.TempDir()}
+	if keys := resourceKeys(&backup); !slices.Contains(keys, "host:files.lan") {
+		t.Errorf("resourceKeys() = %q, want the host of the type's Resources", keys)
+	}
+
+	release, err := lockEntry(context.Background(), &backup, 0, discardLog)
+	if err != nil {
+		t.Fatalf("lockEntry() failed: %v", err)
+	}
+	defer release()
+	if _, err := acquireLock(context.Background(), scratchLockPath(resourcesOf(&backup).ScratchDir), 0); err == nil {
+		t.Error("lockEntry() did not lock the type's scratch directory")
+	}
+	other := Backup{Name: "notes-tar", Type: "tar", Source: "files.lan:/srv/notes", Destination: backup.Destination}
+	if keys := resourceKeys(&other); slices.ContainsFunc(keys, func(key string) bool { return strings.HasPrefix(key, "host:") }) {
+		t.Errorf("resourceKeys() of a type without Resources = %q", keys)
+	}
+}
+
+func TestUnknownBackupType(t *testing.T) {
+	backup := Backup{Name: "photos", Type: "zip", Source: "/srv/photos", Destination: "/backups"}
+	problems := validateEntry(&backup)
+	var unknown *UnknownBackupTypeError
+	if len(problems) != 1 || !errors.As(problems[0], &unknown) {
+		t.Fatalf("validateEntry() = %v, want an unknown type error", problems)
+	}
+	for _, name := range []string{"tar", "rsync", "command", "test-copy"} {
+		if !slices.Contains(unknown.Registered, name) || !strings.Contains(unknown.Error(), name) {
+			t.Errorf("error %q does not list registered type %q", unknown, name)
+		}
+	}
+
+	// Entries that get past validation still fail rather than doing nothing
+	if _, err := runBackup(context.Background(), &backup, discardLog, nil); !errors.As(err, &unknown) {
+		t.Errorf("runBackup() = %v, want an unknown type error", err)
+	}
+	if _, err := ListSnapshots(backup); !errors.As(err, &unknown) {
+		t.Errorf("ListSnapshots() = %v, want an unknown type error", err)
+	}
+}
+
+func TestRegisterBackupTypeTwicePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("registering tar again did not panic")
+		}
+	}()
+	RegisterBackupType("tar", tarType{})
+}
+
+func TestRestoreBuiltinTypes(t *testing.T) {
+	t.Setenv("GOBACKUP_STATE", t.TempDir())
+	source := t.TempDir()
+	if err := os.WriteFile(filepath.Join(source, "file.txt"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	library := Library{Entries: map[string]Backup{
+		"docs": {Type: "tar", Source: source, Destination: t.TempDir(), ChangeDir: true, Retain: 1},
+		"db":   {Type: "command", Command: "printf dump", CommandFileName: "all.sql", Destination: t.TempDir(), CompressionType: "xz", Retain: 1},
+	}}
+	runner := NewRunner(library, DefaultRunOptions())
+	if _, err := runner.Run(context.Background(), []string{"docs", "db"}); err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	restored := map[string][2]string{"docs": {"file.txt", "data"}, "db": {"all.sql", "dump"}}
+	for entry, want := range restored {
+		snapshots, err := runner.Snapshots(entry)
+		if err != nil || len(snapshots) != 1 {
+			t.Fatalf("Snapshots(%s) = %v, %v, want one", entry, snapshots, err)
+		}
+		target := t.TempDir()
+		if err := runner.Restore(context.Background(), entry, snapshots[0], target); err != nil {
+			t.Fatalf("Restore(%s) failed: %v", entry, err)
+		}
+		if data, err := os.ReadFile(filepath.Join(target, want[0])); err != nil || string(data) != want[1] {
+			t.Errorf("restored %s/%s = %q, %v, want %q", entry, want[0], data, err, want[1])
+		}
+	}
+
+	snapshot := Snapshot{Entry: "docs", Path: filepath.Join(t.TempDir(), "docs_2026.01.02_03.04.05.tar.gz")}
+	if err := runner.Restore(context.Background(), "docs", snapshot, t.TempDir()); err == nil || !strings.Contains(err.Error(), "tar command failed") {
+		t.Errorf("Restore() of a missing snapshot = %v, want tar to fail", err)
+	}
+}
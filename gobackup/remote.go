@@ -0,0 +1,75 @@
+package gobackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// sshOptions are shared by the rsync transport and remote commands so both
+// connect to the source host the same way
+var sshOptions = []string{
+	"-o", "StrictHostKeyChecking=no",
+	"-o", "UserKnownHostsFile=/dev/null",
+	"-o", "LogLevel=ERROR",
+}
+
+// sshTarget returns the [user@]host part of an rsync source reached over
+// SSH. Local paths and rsync daemon sources (host::module) have none.
+func sshTarget(source string) (string, error) {
+	if remoteHost(source) == "" {
+		return "", fmt.Errorf("source %s is not a remote host", source)
+	}
+	target, rest, _ := strings.Cut(source, ":")
+	if strings.HasPrefix(rest, ":") {
+		return "", fmt.Errorf("source %s uses the rsync daemon protocol, remote commands need SSH", source)
+	}
+	return target, nil
+}
+
+// runRemoteCommands runs each command on target over SSH, logging its
+// output. With stopOnError the first failure ends the list, otherwise every
+// command runs and all failures are returned together.
+func runRemoteCommands(ctx context.Context, kind, target string, commands []string, timeout time.Duration, stopOnError bool, log *slog.Logger) error {
+	log = log.With("phase", "remote-"+kind, "host", target)
+	var errs []error
+	for _, command := range commands {
+		log.Info("Running remote "+kind+" command", "command", command)
+		if err := runRemoteCommand(ctx, target, command, timeout, log); err != nil {
+			err = fmt.Errorf("remote %s command %q on %s failed: %w", kind, command, target, err)
+			log.Error(err.Error())
+			errs = append(errs, err)
+			if stopOnError {
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runRemoteCommand runs a single command on target through ssh, killing it
+// once timeout has elapsed or ctx is done
+func runRemoteCommand(parent context.Context, target, command string, timeout time.Duration, log *slog.Logger) error {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	args := append(append([]string{}, sshOptions...), target, command)
+	cmd := exec.CommandContext(ctx, "ssh", args...)
+	out := newLogWriter(log, slog.LevelInfo)
+	defer out.Flush()
+	cmd.Stdout = out
+	cmd.Stderr = out
+	killGroupOnCancel(cmd)
+	err := cmd.Run()
+	if err != nil && parent.Err() != nil {
+		return fmt.Errorf("interrupted: %w", parent.Err())
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	return err
+}
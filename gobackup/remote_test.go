@@ -0,0 +1,106 @@
+package gobackup
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSHTarget(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+		wantErr  bool
+	}{
+		{source: "snowpea@10.0.0.173:/home/snowpea/torado", expected: "snowpea@10.0.0.173"},
+		{source: "nas:/data", expected: "nas"},
+		{source: "nas::module", wantErr: true},
+		{source: "/local/path", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := sshTarget(tt.source)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("sshTarget(%q) succeeded, want error", tt.source)
+			}
+			continue
+		}
+		if err != nil || got != tt.expected {
+			t.Errorf("sshTarget(%q) = %q, %v, want %q", tt.source, got, err, tt.expected)
+		}
+	}
+}
+
+// fakeSSH puts an ssh stub first in PATH that logs its arguments and fails
+// for any command containing "fail"
+func fakeSSH(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "ssh.log")
+	script := "#!/bin/sh\n" +
+		"echo \"$@\" >> " + logFile + "\n" +
+		"case \"$*\" in *fail*) echo remote error >&2; exit 1;; esac\n" +
+		"echo remote output\n"
+	if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write ssh stub: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return logFile
+}
+
+func TestRunRemoteCommands(t *testing.T) {
+	logFile := fakeSSH(t)
+	var out bytes.Buffer
+
+	err := runRemoteCommands(context.Background(), "pre", "user@nas", []string{"pg_dump db > /tmp/db.sql", "fail now", "never"}, time.Minute, true, slog.New(slog.NewTextHandler(&out, nil)))
+	if err == nil {
+		t.Fatal("runRemoteCommands() succeeded despite failing command")
+	}
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("failed to read ssh log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("ssh ran %d times, want 2: %q", len(lines), lines)
+	}
+	if !strings.Contains(lines[0], "StrictHostKeyChecking=no") || !strings.HasSuffix(lines[0], "user@nas pg_dump db > /tmp/db.sql") {
+		t.Errorf("unexpected ssh invocation: %s", lines[0])
+	}
+	for _, want := range []string{"remote output", "remote error"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output %q does not contain %q", out.String(), want)
+		}
+	}
+}
+
+func TestRsyncRemotePreCommandFailureAbortsPull(t *testing.T) {
+	logFile := fakeSSH(t)
+	t.Setenv("SCRATCH", t.TempDir())
+	backup := Backup{
+		Name:               "torado",
+		Type:               "rsync",
+		Source:             "user@nas:/data",
+		Destination:        t.TempDir(),
+		RemotePreCommands:  []string{"docker compose stop", "fail to dump"},
+		RemotePostCommands: []string{"docker compose start"},
+	}
+
+	var out bytes.Buffer
+	if _, err := rsync(context.Background(), &backup, slog.New(slog.NewTextHandler(&out, nil)), nil); err == nil || !strings.Contains(err.Error(), "not pulling") {
+		t.Fatalf("rsync() error = %v, want pull to be aborted", err)
+	}
+	if strings.Contains(out.String(), "Beginning rsync") {
+		t.Error("rsync pull ran after a failing remote pre-command")
+	}
+	data, _ := os.ReadFile(logFile)
+	if !strings.Contains(string(data), "docker compose start") {
+		t.Error("remote post-command did not run after the aborted pull")
+	}
+}
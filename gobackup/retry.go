@@ -0,0 +1,92 @@
+package gobackup
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	// defaultRetryBackoff is the delay before the first retry unless
+	// RetryBackoff is set. It doubles with every further retry.
+	defaultRetryBackoff = 30 * time.Second
+	// maxRetryBackoff caps the delay between two attempts
+	maxRetryBackoff = 10 * time.Minute
+)
+
+// retryPolicy is how often and how patiently a transient failure is retried
+type retryPolicy struct {
+	attempts int           // attempts in total, at least 1
+	backoff  time.Duration // delay before the first retry
+}
+
+// retryPolicyFor returns the retry policy of an entry. Without RetryAttempts
+// every step is tried once.
+func retryPolicyFor(backup *Backup) (retryPolicy, error) {
+	policy := retryPolicy{attempts: 1, backoff: defaultRetryBackoff}
+	if backup.RetryAttempts < 0 {
+		return policy, fmt.Errorf("invalid RetryAttempts %d", backup.RetryAttempts)
+	}
+	if backup.RetryAttempts > 0 {
+		policy.attempts = backup.RetryAttempts
+	}
+	if backup.RetryBackoff != "" {
+		backoff, err := time.ParseDuration(backup.RetryBackoff)
+		if err != nil || backoff < 0 {
+			return policy, fmt.Errorf("invalid RetryBackoff %q", backup.RetryBackoff)
+		}
+		policy.backoff = backoff
+	}
+	return policy, nil
+}
+
+// delay returns how long to wait after the given failed attempt, counting
+// from 1: the backoff doubled for every earlier retry, capped, and then
+// randomly shortened by up to half so entries failing together do not retry
+// in lockstep
+func (p retryPolicy) delay(attempt int) time.Duration {
+	d := p.backoff
+	for i := 1; i < attempt && d < maxRetryBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, maxRetryBackoff)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// Attempt records one try at a step that may be retried
+type Attempt struct {
+	Started  time.Time
+	Duration time.Duration
+	Err      error // nil for the attempt that succeeded
+}
+
+// retry runs fn until it succeeds, fails permanently, the policy runs out of
+// attempts or ctx is done. fn reports whether its failure is worth retrying.
+// Every attempt is returned, along with the error of the last one.
+func retry(ctx context.Context, p retryPolicy, log *slog.Logger, fn func() (retryable bool, err error)) ([]Attempt, error) {
+	var attempts []Attempt
+	for n := 1; ; n++ {
+		a := Attempt{Started: time.Now()}
+		retryable, err := fn()
+		a.Duration, a.Err = time.Since(a.Started), err
+		attempts = append(attempts, a)
+		if err == nil || !retryable || n >= p.attempts || ctx.Err() != nil {
+			return attempts, err
+		}
+
+		wait := p.delay(n)
+		log.Warn("Attempt failed, retrying", "attempt", n, "attempts", p.attempts, "delay", wait.Round(time.Second).String(), "error", err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return attempts, err
+		}
+	}
+}
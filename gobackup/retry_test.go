@@ -0,0 +1,72 @@
+package gobackup
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := retryPolicy{attempts: 10, backoff: time.Second}
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 20: maxRetryBackoff} {
+		for i := 0; i < 20; i++ {
+			if d := p.delay(attempt); d < want/2 || d > want {
+				t.Errorf("delay(%d) = %s, want between %s and %s", attempt, d, want/2, want)
+			}
+		}
+	}
+}
+
+func TestRetryPolicyFor(t *testing.T) {
+	p, err := retryPolicyFor(&Backup{})
+	if err != nil || p.attempts != 1 {
+		t.Errorf("default policy = %+v, %v, want a single attempt", p, err)
+	}
+	p, err = retryPolicyFor(&Backup{RetryAttempts: 4, RetryBackoff: "5s"})
+	if err != nil || p.attempts != 4 || p.backoff != 5*time.Second {
+		t.Errorf("policy = %+v, %v", p, err)
+	}
+	if _, err := retryPolicyFor(&Backup{RetryBackoff: "later"}); err == nil {
+		t.Error("invalid RetryBackoff accepted")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	p := retryPolicy{attempts: 3, backoff: time.Millisecond}
+	transient := errors.New("connection reset")
+
+	calls := 0
+	attempts, err := retry(context.Background(), p, discardLog, func() (bool, error) {
+		calls++
+		if calls < 2 {
+			return true, transient
+		}
+		return false, nil
+	})
+	if err != nil || len(attempts) != 2 || attempts[0].Err != transient || attempts[1].Err != nil {
+		t.Errorf("transient failure: attempts = %+v, err = %v", attempts, err)
+	}
+
+	attempts, err = retry(context.Background(), p, discardLog, func() (bool, error) { return true, transient })
+	if err != transient || len(attempts) != 3 {
+		t.Errorf("persistent failure: %d attempts, err = %v, want 3 attempts", len(attempts), err)
+	}
+
+	permanent := errors.New("no such file or directory")
+	attempts, err = retry(context.Background(), p, discardLog, func() (bool, error) { return false, permanent })
+	if err != permanent || len(attempts) != 1 {
+		t.Errorf("permanent failure: %d attempts, err = %v, want 1 attempt", len(attempts), err)
+	}
+
+	// Cancelling stops the wait before the next attempt
+	ctx, cancel := context.WithCancel(context.Background())
+	start := time.Now()
+	attempts, _ = retry(ctx, retryPolicy{attempts: 3, backoff: time.Hour}, discardLog, func() (bool, error) {
+		cancel()
+		return true, transient
+	})
+	if len(attempts) != 1 || time.Since(start) > 5*time.Second {
+		t.Errorf("retry went on after cancellation: %d attempts", len(attempts))
+	}
+}
@@ -0,0 +1,258 @@
+package gobackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// shellQuote properly quotes a string for use in shell commands
+func shellQuote(s string) string {
+	// Replace single quotes with '\'' (close quote, escaped quote, open quote)
+	quoted := strings.ReplaceAll(s, "'", "'\\''")
+	return "'" + quoted + "'"
+}
+
+func init() {
+	RegisterBackupType("rsync", rsyncType{})
+}
+
+// rsyncType pulls a remote directory with rsync and archives the mirror
+// with tar
+type rsyncType struct{}
+
+func (rsyncType) Validate(backup *Backup) []error {
+	return tarType{}.Validate(backup)
+}
+
+func (rsyncType) Plan(backup *Backup, plan *Plan) {
+	if _, err := retryPolicyFor(backup); err != nil {
+		plan.Problem(err)
+	}
+	scratchDir := rsyncScratchDir(backup)
+	target := "<source host>"
+	if len(backup.RemotePreCommands) > 0 || len(backup.RemotePostCommands) > 0 {
+		var err error
+		if target, err = sshTarget(backup.Source); err != nil {
+			plan.Problem(err)
+		}
+	}
+	for _, command := range backup.RemotePreCommands {
+		plan.Steps = append(plan.Steps, fmt.Sprintf("ssh %s: %s", target, command))
+	}
+	plan.Steps = append(plan.Steps, rsyncCommand(backup, scratchDir))
+	for _, command := range backup.RemotePostCommands {
+		plan.Steps = append(plan.Steps, fmt.Sprintf("ssh %s: %s", target, command))
+	}
+	plan.listRsync(backup)
+	archive := *backup
+	archive.Source = scratchDir
+	plan.Tar(&archive)
+}
+
+// Resources are the mirror kept in scratch between runs and the host it is
+// pulled from
+func (rsyncType) Resources(backup *Backup) Resources {
+	return Resources{ScratchDir: rsyncScratchDir(backup), Host: remoteHost(backup.Source)}
+}
+
+func (rsyncType) Run(ctx context.Context, backup *Backup, log *slog.Logger, progress ProgressFunc) (*BackupResult, error) {
+	return rsync(ctx, backup, log, progress)
+}
+
+func (rsyncType) Snapshots(backup *Backup) ([]Snapshot, error) {
+	return archiveSnapshots(backup)
+}
+
+func (rsyncType) Restore(ctx context.Context, backup *Backup, snapshot Snapshot, target string, log *slog.Logger) error {
+	return extractArchive(ctx, snapshot.Path, target, log)
+}
+
+// rsyncScratchDir returns the local mirror directory an rsync entry pulls into
+func rsyncScratchDir(backup *Backup) string {
+	//Check if scratch dir is defined
+	var scratch string = GetEnv("SCRATCH", "/tmp/")
+	return scratch + "/" + backup.Name
+}
+
+// rsyncCommand returns the shell command that mirrors the entry's Source
+// into scratchDir
+func rsyncCommand(backup *Backup, scratchDir string) string {
+	verboseFlag := ""
+	if backup.Verbose {
+		verboseFlag = "v"
+	}
+	return fmt.Sprintf("rsync%s -rahz%s --delete --partial --info=progress2 -e %s %s %s",
+		excludeFlags(backup),
+		verboseFlag,
+		shellQuote("ssh "+strings.Join(sshOptions, " ")),
+		shellQuote(backup.Source),
+		shellQuote(scratchDir),
+	)
+}
+
+// rsync pulls backup.Source into its scratch mirror and archives that with
+// tar. Transient failures of the pull are retried according to the entry's
+// retry policy, each attempt resuming into the mirror. If ctx is cancelled
+// rsync is killed, leaving the mirror to be completed by the next run.
+func rsync(ctx context.Context, backup *Backup, log *slog.Logger, progress ProgressFunc) (*BackupResult, error) {
+	scratchDir := rsyncScratchDir(backup)
+	cmdString := rsyncCommand(backup, scratchDir)
+	policy, err := retryPolicyFor(backup)
+	if err != nil {
+		return nil, err
+	}
+	// Commands to prepare the source host, e.g. dump a database to disk
+	var target string
+	timeout := defaultHookTimeout
+	if len(backup.RemotePreCommands) > 0 || len(backup.RemotePostCommands) > 0 {
+		if target, err = sshTarget(backup.Source); err != nil {
+			return nil, err
+		}
+		if timeout, err = hookTimeout(backup); err != nil {
+			return nil, err
+		}
+	}
+	var attempts []Attempt
+	pullErr := runRemoteCommands(ctx, "pre", target, backup.RemotePreCommands, timeout, true, log)
+	if pullErr != nil {
+		pullErr = fmt.Errorf("not pulling: %w", pullErr)
+	} else {
+		rsyncLog := log.With("phase", "rsync")
+		attempts, pullErr = retry(ctx, policy, rsyncLog, func() (bool, error) {
+			return pull(ctx, backup, cmdString, rsyncLog, progress)
+		})
+	}
+	// Remote post commands run even if the pull failed or was cancelled, so
+	// anything stopped by a pre command is started again
+	postErr := runRemoteCommands(context.WithoutCancel(ctx), "post", target, backup.RemotePostCommands, timeout, false, log)
+	if err := errors.Join(pullErr, postErr); err != nil {
+		return &BackupResult{Attempts: attempts}, err
+	}
+
+	//Now the rsync is completed, we tar the resultant dir
+	log.Info("Rsync completed, beginning tar")
+	backup.Source = scratchDir
+	result, err := tar(ctx, backup, log, progress)
+	if err != nil {
+		return &BackupResult{Attempts: attempts}, fmt.Errorf("tar after rsync failed: %w", err)
+	}
+	result.Attempts = attempts
+	return result, nil
+}
+
+// retryableRsyncCodes are the rsync exit codes of failures that may go away
+// by themselves: socket I/O, protocol data stream, partial transfer and
+// timeout errors. 255 is ssh failing to reach the host.
+var retryableRsyncCodes = map[int]bool{10: true, 12: true, 23: true, 30: true, 35: true, 255: true}
+
+// pull runs one rsync attempt and reports whether its failure is worth
+// retrying. Permanent failures, such as a bad path, are not.
+func pull(ctx context.Context, backup *Backup, cmdString string, log *slog.Logger, progress ProgressFunc) (bool, error) {
+	log.Info("Beginning rsync", "command", cmdString)
+	meter := startProgress(progress, backup.Name, "rsync", "")
+	stdout := newLineWriter(func(line string) {
+		if p, ok := parseRsyncProgress(line); ok {
+			p.apply(meter)
+			return
+		}
+		log.Info(line)
+	})
+	tail := newTailBuffer(outputTailLines)
+	stderr := newLineWriter(func(line string) {
+		tail.addLine(line)
+		log.Log(context.Background(), rsyncMessageLevel(line), line)
+	})
+	cmd := exec.CommandContext(ctx, "sh", "-c", cmdString)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	killGroupOnCancel(cmd)
+	err := cmd.Run()
+	stdout.Flush()
+	stderr.Flush()
+	meter.finish()
+	switch {
+	case err == nil:
+		return false, nil
+	case ctx.Err() != nil:
+		return false, fmt.Errorf("rsync interrupted: %w", ctx.Err())
+	}
+	var exitErr *exec.ExitError
+	retryable := errors.As(err, &exitErr) && retryableRsyncCodes[exitErr.ExitCode()]
+	return retryable, withOutput(fmt.Errorf("rsync command failed: %w", err), tail)
+}
+
+// rsyncMessageLevel classifies a line rsync writes to stderr. Files
+// vanishing under rsync are expected on live systems; rsync's own error
+// summaries and failed operations are errors; anything else is a warning.
+func rsyncMessageLevel(line string) slog.Level {
+	switch {
+	case strings.HasPrefix(line, "file has vanished"):
+		return slog.LevelWarn
+	case strings.HasPrefix(line, "rsync error:"), strings.Contains(line, " failed: "):
+		return slog.LevelError
+	}
+	return slog.LevelWarn
+}
+
+// rsyncProgressLine matches the overall progress rsync prints with
+// --info=progress2: bytes, percent, rate, ETA and, once files have been
+// transferred, the number of files still to check out of all found so far
+var rsyncProgressLine = regexp.MustCompile(`^\s*([\d.,]+[KMGTP]?)\s+(\d+)%\s+\S+\s+\d+:\d{2}:\d{2}(?:\s+\(xfr#\d+, (?:ir|to)-chk=(\d+)/(\d+)\))?`)
+
+// rsyncProgress is one progress line of rsync
+type rsyncProgress struct {
+	bytes   int64
+	percent int64
+	files   int64 // files checked so far, -1 if not reported
+}
+
+// parseRsyncProgress parses a line of --info=progress2 output
+func parseRsyncProgress(line string) (rsyncProgress, bool) {
+	m := rsyncProgressLine.FindStringSubmatch(line)
+	if m == nil {
+		return rsyncProgress{}, false
+	}
+	p := rsyncProgress{bytes: parseRsyncNumber(m[1]), files: -1}
+	p.percent, _ = strconv.ParseInt(m[2], 10, 64)
+	if m[3] != "" {
+		remaining, _ := strconv.ParseInt(m[3], 10, 64)
+		total, _ := strconv.ParseInt(m[4], 10, 64)
+		p.files = total - remaining
+	}
+	return p, true
+}
+
+// parseRsyncNumber parses a number as printed by rsync -h: digits with
+// separators, or units of 1000 with a K, M, G, T or P suffix
+func parseRsyncNumber(s string) int64 {
+	multiplier := 1.0
+	if i := strings.IndexAny(s, "KMGTP"); i >= 0 {
+		multiplier = math.Pow(1000, float64(strings.IndexByte("KMGTP", s[i])+1))
+		s = s[:i]
+	} else {
+		s = strings.NewReplacer(",", "", ".", "").Replace(s)
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return int64(n * multiplier)
+}
+
+// apply records the progress on m, working out the total from the percent
+func (p rsyncProgress) apply(m *progressMeter) {
+	m.bytes.Store(p.bytes)
+	if p.percent > 0 {
+		m.total.Store(p.bytes * 100 / p.percent)
+	}
+	if p.files >= 0 {
+		m.files.Store(p.files)
+	}
+}
@@ -1,8 +1,10 @@
-package main
+package gobackup
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -196,3 +198,58 @@ func TestRsyncDefaultScratchDirectory(t *testing.T) {
 		t.Errorf("Scratch directory = %s, want %s", scratchDir, expectedDir)
 	}
 }
+
+// fakeRsync puts an rsync on PATH that exits with the next of codes on each
+// call, copying nothing, and returns the file counting the calls
+func fakeRsync(t *testing.T, codes ...int) string {
+	t.Helper()
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := "#!/bin/sh\necho call >> " + calls + "\nn=$(wc -l < " + calls + ")\ncase $n in\n"
+	for i, code := range codes {
+		script += fmt.Sprintf("%d) echo 'rsync error: attempt %d' >&2; exit %d;;\n", i+1, i+1, code)
+	}
+	script += "esac\nexit 0\n"
+	if err := os.WriteFile(filepath.Join(dir, "rsync"), []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write rsync stub: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return calls
+}
+
+func TestRsyncRetriesTransientFailures(t *testing.T) {
+	t.Setenv("SCRATCH", t.TempDir())
+	calls := fakeRsync(t, 10, 23)
+	backup := Backup{Name: "nas", Type: "rsync", Source: "nas:/srv", Destination: t.TempDir(), Retain: 1,
+		RetryAttempts: 3, RetryBackoff: "1ms"}
+	os.MkdirAll(rsyncScratchDir(&backup), 0755)
+
+	result, err := rsync(context.Background(), &backup, discardLog, nil)
+	if err != nil {
+		t.Fatalf("rsync() failed despite succeeding on the third attempt: %v", err)
+	}
+	if len(result.Attempts) != 3 || result.Attempts[0].Err == nil || result.Attempts[2].Err != nil {
+		t.Errorf("attempts = %+v, want two failures and a success", result.Attempts)
+	}
+	if data, _ := os.ReadFile(calls); strings.Count(string(data), "call") != 3 {
+		t.Errorf("rsync ran %d times, want 3", strings.Count(string(data), "call"))
+	}
+}
+
+func TestRsyncDoesNotRetryPermanentFailures(t *testing.T) {
+	t.Setenv("SCRATCH", t.TempDir())
+	calls := fakeRsync(t, 3, 3)
+	backup := Backup{Name: "nas", Type: "rsync", Source: "nas:/missing", Destination: t.TempDir(), Retain: 1,
+		RetryAttempts: 3, RetryBackoff: "1ms"}
+
+	result, err := rsync(context.Background(), &backup, discardLog, nil)
+	if err == nil || !strings.Contains(err.Error(), "rsync error: attempt 1") {
+		t.Fatalf("rsync() error = %v, want the failure of the first attempt", err)
+	}
+	if result == nil || len(result.Attempts) != 1 {
+		t.Errorf("result = %+v, want a single attempt", result)
+	}
+	if data, _ := os.ReadFile(calls); strings.Count(string(data), "call") != 1 {
+		t.Errorf("rsync ran %d times, want 1", strings.Count(string(data), "call"))
+	}
+}
@@ -0,0 +1,182 @@
+package gobackup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// Runner runs entries of a library
+type Runner struct {
+	Library Library
+	Options RunOptions
+
+	// Logger is what the entries log to, slog.Default() if nil. The
+	// journald and syslog sinks of the library are added to it for the run.
+	Logger *slog.Logger
+	// Output is where a dry run prints its plan. If nil, it is discarded.
+	Output io.Writer
+
+	// OnEvent, if set, is called with every event of a run. Entries of a
+	// parallel run report concurrently, so it must be safe for concurrent
+	// use.
+	OnEvent func(Event)
+	// Events, if set, is sent every event of a run as well. Sends block, so
+	// it must be drained until Run returns. Run does not close it.
+	Events chan<- Event
+}
+
+// NewRunner returns a Runner for entries of library
+func NewRunner(library Library, opts RunOptions) *Runner {
+	return &Runner{Library: library, Options: opts}
+}
+
+// Run runs entries, prerequisites among them first. Cancelling ctx kills the
+// running entries, cleans up after them and records them as cancelled.
+//
+// The error is nil if every entry succeeded without warnings; ExitCode turns
+// it into the exit code of the gobackup command. Only entries and their
+// prerequisites are validated, so problems elsewhere in the library do not
+// stop them. If they do not validate, or one is not in the library, nothing
+// runs and the error is a configuration error.
+func (r *Runner) Run(ctx context.Context, entries []string) (RunResult, error) {
+	for _, entry := range entries {
+		if _, exists := r.Library.Entries[entry]; !exists {
+			return RunResult{}, configError{fmt.Errorf("no backup found with name '%s'", entry)}
+		}
+	}
+	if problems := validateSelected(r.Library, entries); len(problems) > 0 {
+		return RunResult{}, &LibraryValidationError{Problems: problems}
+	}
+	library, settings := r.Library.Entries, r.Library.Settings
+	entries = orderEntries(entries, library)
+	opts := r.Options
+	if opts.DryRun {
+		output := r.Output
+		if output == nil {
+			output = io.Discard
+		}
+		return RunResult{}, dryRun(output, entries, library)
+	}
+
+	sinks, err := openLogSinks(settings.Logging, r.logger())
+	if err != nil {
+		return RunResult{}, configError{fmt.Errorf("invalid library settings: %w", err)}
+	}
+	defer sinks.Close()
+	log := sinks.logger(r.logger())
+	observers, err := newObservers(settings, library, opts.StateDir, log)
+	if err != nil {
+		return RunResult{}, configError{fmt.Errorf("invalid library settings: %w", err)}
+	}
+	defer closeObservers(observers)
+	opts.logger = log
+	opts.runID = newRunID()
+	opts.observers = append(slices.Clip(opts.observers), observers...)
+	if r.OnEvent != nil || r.Events != nil {
+		opts.observers = append(opts.observers, &eventEmitter{runID: opts.runID, emit: r.emit})
+	}
+
+	//Begin
+	started := time.Now()
+	var outcomes map[string]outcome
+	if opts.Jobs <= 1 {
+		outcomes = runSequential(ctx, entries, library, opts)
+	} else {
+		outcomes = runParallel(ctx, entries, library, opts)
+	}
+
+	report := newRunReport(started, entries, outcomes)
+	opts.runFinished(report)
+	err = resultError(report)
+	result := newRunResult(report, err)
+	result.RunID = opts.runID
+	return result, err
+}
+
+// Snapshots returns the stored backups of entry, oldest first
+func (r *Runner) Snapshots(entry string) ([]Snapshot, error) {
+	backup, exists := r.Library.Entries[entry]
+	if !exists {
+		return nil, fmt.Errorf("no backup found with name '%s'", entry)
+	}
+	backup.Name = entry
+	return ListSnapshots(backup)
+}
+
+// logger returns the Logger of the runner or the default one
+func (r *Runner) logger() *slog.Logger {
+	if r.Logger != nil {
+		return r.Logger
+	}
+	return slog.Default()
+}
+
+func (r *Runner) emit(e Event) {
+	if r.OnEvent != nil {
+		r.OnEvent(e)
+	}
+	if r.Events != nil {
+		r.Events <- e
+	}
+}
+
+// EventType is what an Event reports
+type EventType string
+
+const (
+	EventStarted  EventType = "started"  // an entry started running
+	EventProgress EventType = "progress" // a running entry got further
+	EventWarning  EventType = "warning"  // an entry succeeded, but not cleanly
+	EventFinished EventType = "finished" // an entry ended, however it went
+)
+
+// Event is something that happened to an entry during a run. Every entry
+// of a run finishes, but skipped entries and those the run was cancelled
+// before never start. Warnings come just before the entry finishes.
+type Event struct {
+	Type     EventType
+	RunID    string
+	Entry    string
+	Time     time.Time
+	Progress *Progress    // for EventProgress
+	Warning  string       // for EventWarning
+	Result   *EntryResult // for EventFinished
+}
+
+// eventEmitter turns what a run observes into Events
+type eventEmitter struct {
+	runID string
+	emit  func(Event)
+}
+
+func (e *eventEmitter) event(typ EventType, entry string) Event {
+	return Event{Type: typ, RunID: e.runID, Entry: entry, Time: time.Now()}
+}
+
+func (e *eventEmitter) entryStarted(backup Backup) {
+	e.emit(e.event(EventStarted, backup.Name))
+}
+
+func (e *eventEmitter) entryProgress(progress Progress) {
+	event := e.event(EventProgress, progress.Entry)
+	event.Progress = &progress
+	e.emit(event)
+}
+
+func (e *eventEmitter) entryFinished(result outcome) {
+	entry := newEntryResult(result)
+	for _, warning := range entry.Warnings {
+		event := e.event(EventWarning, result.entry)
+		event.Warning = warning
+		e.emit(event)
+	}
+	event := e.event(EventFinished, result.entry)
+	event.Result = &entry
+	e.emit(event)
+}
+
+func (e *eventEmitter) runFinished(report runReport) {}
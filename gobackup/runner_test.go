@@ -0,0 +1,175 @@
+package gobackup
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRunnerRun(t *testing.T) {
+	t.Setenv("GOBACKUP_STATE", t.TempDir())
+	source := t.TempDir()
+	if err := os.WriteFile(filepath.Join(source, "file.txt"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	destination := t.TempDir()
+	library := Library{Entries: map[string]Backup{
+		"docs":   {Type: "tar", Source: source, Destination: destination, Retain: 1, ChangeDir: true},
+		"broken": {Type: "tar", Source: filepath.Join(source, "missing"), Destination: destination, ChangeDir: true},
+		"after":  {Type: "tar", Source: source, Destination: destination, ChangeDir: true, DependsOn: []string{"broken"}},
+	}}
+
+	var mu sync.Mutex
+	var events []Event
+	runner := NewRunner(library, DefaultRunOptions())
+	runner.OnEvent = func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, e)
+	}
+	result, err := runner.Run(context.Background(), []string{"docs", "broken", "after"})
+	if ExitCode(err) != ExitPartialFailure {
+		t.Errorf("Run() error = %v, want a partial failure", err)
+	}
+	if result.RunID == "" || result.ExitCode != ExitPartialFailure || len(result.Entries) != 3 {
+		t.Fatalf("result = %+v", result)
+	}
+	statuses := map[string]string{}
+	for _, entry := range result.Entries {
+		statuses[entry.Entry] = entry.Status
+	}
+	if statuses["docs"] != StatusSucceeded || statuses["broken"] != StatusFailed || statuses["after"] != StatusSkipped {
+		t.Errorf("statuses = %v", statuses)
+	}
+
+	var started, finished []string
+	for _, e := range events {
+		if e.RunID != result.RunID {
+			t.Errorf("event %+v has run ID %q, want %q", e, e.RunID, result.RunID)
+		}
+		switch e.Type {
+		case EventStarted:
+			started = append(started, e.Entry)
+		case EventFinished:
+			finished = append(finished, e.Entry+" "+e.Result.Status)
+		}
+	}
+	if len(started) != 2 || len(finished) != 3 || finished[2] != "after skipped" {
+		t.Errorf("started %v, finished %v", started, finished)
+	}
+
+	snapshots, err := runner.Snapshots("docs")
+	if err != nil || len(snapshots) != 1 {
+		t.Fatalf("Snapshots() = %v, %v, want one", snapshots, err)
+	}
+	if snapshots[0].Time.IsZero() || snapshots[0].Size == 0 || snapshots[0].Path != result.Entries[0].Archive {
+		t.Errorf("snapshot = %+v, archive %s", snapshots[0], result.Entries[0].Archive)
+	}
+}
+
+func TestRunnerEventsChannel(t *testing.T) {
+	t.Setenv("GOBACKUP_STATE", t.TempDir())
+	library := Library{Entries: map[string]Backup{
+		"db": {Type: "command", Command: "printf dump", Destination: t.TempDir(), RetryAttempts: 1},
+	}}
+	events := make(chan Event, 16)
+	runner := NewRunner(library, DefaultRunOptions())
+	runner.Events = events
+	if _, err := runner.Run(context.Background(), []string{"db"}); err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	close(events)
+	var types []EventType
+	for e := range events {
+		if e.Type != EventProgress {
+			types = append(types, e.Type)
+		}
+	}
+	if len(types) != 2 || types[0] != EventStarted || types[1] != EventFinished {
+		t.Errorf("event types = %v, want started and finished", types)
+	}
+}
+
+func TestRunnerInvalidLibrary(t *testing.T) {
+	library := Library{Entries: map[string]Backup{"docs": {Type: "tar"}}}
+	_, err := NewRunner(library, DefaultRunOptions()).Run(context.Background(), []string{"docs"})
+	var invalid *LibraryValidationError
+	if !errors.As(err, &invalid) || ExitCode(err) != ExitConfigError {
+		t.Errorf("Run() with an invalid library = %v, want a validation error", err)
+	}
+}
+
+func TestRunnerValidatesOnlySelectedEntries(t *testing.T) {
+	t.Setenv("GOBACKUP_STATE", t.TempDir())
+	t.Setenv("SCRATCH", t.TempDir())
+	library := Library{Entries: map[string]Backup{
+		"docs":   {Type: "tar", Source: t.TempDir(), Destination: t.TempDir(), Retain: 1, ChangeDir: true},
+		"report": {Type: "command", Command: "true", Destination: t.TempDir(), Retain: 1, DependsOn: []string{"docs"}},
+		"typo":   {Type: "tarr"},
+		"broken": {Type: "tar", Source: t.TempDir(), DependsOn: []string{"docs"}},
+	}}
+	runner := NewRunner(library, DefaultRunOptions())
+	runner.Logger = discardLog
+	if _, err := runner.Run(context.Background(), []string{"report", "docs"}); err != nil {
+		t.Errorf("Run() = %v, want problems with other entries ignored", err)
+	}
+
+	// A prerequisite of a selected entry is validated even if not selected
+	library.Entries["report"] = Backup{Type: "command", Command: "true", Destination: t.TempDir(), DependsOn: []string{"broken"}}
+	_, err := runner.Run(context.Background(), []string{"report"})
+	var invalid *LibraryValidationError
+	if !errors.As(err, &invalid) || len(invalid.Problems) != 1 || !strings.Contains(err.Error(), "entry 'broken'") {
+		t.Errorf("Run() = %v, want only the problem with the prerequisite", err)
+	}
+}
+
+func TestRunnerUnknownEntry(t *testing.T) {
+	library := Library{Entries: map[string]Backup{"docs": {Type: "tar", Source: t.TempDir(), Destination: t.TempDir()}}}
+	result, err := NewRunner(library, DefaultRunOptions()).Run(context.Background(), []string{"docs", "doc"})
+	if err == nil || !strings.Contains(err.Error(), "no backup found with name 'doc'") || ExitCode(err) != ExitConfigError {
+		t.Errorf("Run() = %v, want a configuration error for the unknown entry", err)
+	}
+	if len(result.Entries) != 0 {
+		t.Errorf("Run() ran %d entries, want none", len(result.Entries))
+	}
+}
+
+func TestRunnerStateDir(t *testing.T) {
+	defaultState := t.TempDir()
+	t.Setenv("GOBACKUP_STATE", defaultState)
+	library := Library{
+		Entries: map[string]Backup{
+			"docs": {Type: "tar", Source: t.TempDir(), Destination: t.TempDir(), Retain: 1, ChangeDir: true},
+		},
+		Settings: Settings{Metrics: &MetricsSettings{Listen: "127.0.0.1:0"}},
+	}
+	opts := DefaultRunOptions()
+	opts.StateDir = t.TempDir()
+	if _, err := NewRunner(library, opts).Run(context.Background(), []string{"docs"}); err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	for _, name := range []string{"history.jsonl", "metrics.json"} {
+		if _, err := os.Stat(filepath.Join(opts.StateDir, name)); err != nil {
+			t.Errorf("%s not kept in StateDir: %v", name, err)
+		}
+	}
+	if files, _ := os.ReadDir(defaultState); len(files) > 0 {
+		t.Errorf("state written to the default directory: %v", files)
+	}
+}
+
+func TestEventEmitterWarnings(t *testing.T) {
+	var events []Event
+	emitter := &eventEmitter{runID: "run", emit: func(e Event) { events = append(events, e) }}
+	emitter.entryFinished(outcome{entry: "photos", status: StatusSucceeded, changed: 2, attempts: make([]Attempt, 3)})
+	if len(events) != 3 || events[0].Type != EventWarning || events[1].Type != EventWarning || events[2].Type != EventFinished {
+		t.Fatalf("events = %+v, want two warnings and finished", events)
+	}
+	if events[2].Result.Entry != "photos" || len(events[2].Result.Warnings) != 2 {
+		t.Errorf("result = %+v", events[2].Result)
+	}
+}
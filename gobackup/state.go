@@ -0,0 +1,124 @@
+package gobackup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// stateDir returns the directory gobackup keeps persistent state in.
+// GOBACKUP_STATE overrides the XDG default of ~/.local/state/gobackup.
+func stateDir() string {
+	if dir := GetEnv("GOBACKUP_STATE", ""); dir != "" {
+		return dir
+	}
+	if xdg := GetEnv("XDG_STATE_HOME", ""); xdg != "" {
+		return filepath.Join(xdg, "gobackup")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "gobackup-state")
+	}
+	return filepath.Join(home, ".local", "state", "gobackup")
+}
+
+// statePath returns the path of a state file in dir, or in the default
+// state directory if dir is empty
+func statePath(dir, name string) string {
+	if dir == "" {
+		dir = stateDir()
+	}
+	return filepath.Join(dir, name)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so readers never see a partially written file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write %s: %w", tmpPath, err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write %s: %w", tmpPath, err)
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to set permissions on %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to move %s into place: %w", path, err)
+	}
+	return nil
+}
+
+// withFileLock runs fn holding an exclusive flock on the file at path, which
+// is created if needed. The lock is released if the process dies.
+func withFileLock(path string, fn func() error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(path), err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open lock %s: %w", path, err)
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	return fn()
+}
+
+// scheduleState remembers when the daemon last started each entry so runs
+// missed while it was not running can be caught up
+type scheduleState struct {
+	LastRun map[string]time.Time `json:"LastRun"`
+}
+
+func scheduleStatePath(dir string) string {
+	return statePath(dir, "schedule.json")
+}
+
+// loadScheduleState reads the schedule state, returning an empty state if
+// none has been written yet
+func loadScheduleState(path string) (scheduleState, error) {
+	state := scheduleState{LastRun: make(map[string]time.Time)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("failed to read schedule state: %w", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("failed to parse schedule state %s: %w", path, err)
+	}
+	if state.LastRun == nil {
+		state.LastRun = make(map[string]time.Time)
+	}
+	return state, nil
+}
+
+// save writes the schedule state atomically
+func (s scheduleState) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode schedule state: %w", err)
+	}
+	return writeFileAtomic(path, data, 0644)
+}
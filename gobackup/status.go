@@ -0,0 +1,182 @@
+package gobackup
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// entryHealth is what the run history says about one entry
+type entryHealth struct {
+	entry       string
+	lastSuccess *historyRecord
+	lastFailure *historyRecord
+	failures    int       // consecutive failures since the last success
+	expected    string    // MaxAge, Schedule or --max-age the entry is checked against
+	deadline    time.Time // when the entry becomes overdue, zero if unchecked
+	overdue     bool
+	err         error // invalid MaxAge or Schedule
+}
+
+// checkEntryHealth works out from records, oldest first, how entry is doing.
+// An entry is overdue once its MaxAge has passed since its last success, or
+// grace after the first scheduled time following it. Entries without MaxAge
+// or Schedule are checked against defaultMaxAge if it is set. An entry that
+// is checked but has never succeeded is overdue.
+func checkEntryHealth(entry string, backup Backup, records []historyRecord, defaultMaxAge, grace time.Duration, now time.Time) entryHealth {
+	h := entryHealth{entry: entry}
+	for i := range records {
+		if records[i].Entry != entry {
+			continue
+		}
+		switch records[i].Status {
+		case StatusSucceeded:
+			h.lastSuccess = &records[i]
+			h.failures = 0
+		case StatusCancelled:
+			// Stopped on purpose, not a failure of the entry
+		default:
+			h.lastFailure = &records[i]
+			h.failures++
+		}
+	}
+
+	var next func(time.Time) time.Time
+	switch {
+	case backup.MaxAge != "":
+		maxAge, err := time.ParseDuration(backup.MaxAge)
+		if err != nil || maxAge <= 0 {
+			h.err = fmt.Errorf("invalid MaxAge %q", backup.MaxAge)
+			return h
+		}
+		h.expected = backup.MaxAge
+		next = func(t time.Time) time.Time { return t.Add(maxAge) }
+	case backup.Schedule != "":
+		sched, err := parseSchedule(backup.Schedule)
+		if err != nil {
+			h.err = fmt.Errorf("invalid schedule: %w", err)
+			return h
+		}
+		h.expected = backup.Schedule
+		next = func(t time.Time) time.Time { return sched.Next(t).Add(grace) }
+	case defaultMaxAge > 0:
+		h.expected = defaultMaxAge.String()
+		next = func(t time.Time) time.Time { return t.Add(defaultMaxAge) }
+	default:
+		return h
+	}
+	if h.lastSuccess == nil {
+		h.overdue = true
+		return h
+	}
+	h.deadline = next(h.lastSuccess.Finished)
+	h.overdue = now.After(h.deadline)
+	return h
+}
+
+// state sums up the health of the entry in one word
+func (h entryHealth) state() string {
+	switch {
+	case h.err != nil:
+		return "INVALID"
+	case h.overdue:
+		return "OVERDUE"
+	case h.failures > 0:
+		return "failing"
+	case h.lastSuccess == nil:
+		return "never run"
+	}
+	return "ok"
+}
+
+// writeStatus prints a table of entry health followed by the last error of
+// every failing entry
+func writeStatus(w io.Writer, healths []entryHealth, now time.Time) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENTRY\tLAST SUCCESS\tAGE\tLAST FAILURE\tFAILURES\tEXPECTED\tSTATUS")
+	for _, h := range healths {
+		success, age, failure, expected := "never", "-", "never", "-"
+		if h.lastSuccess != nil {
+			success = h.lastSuccess.Finished.Local().Format("2006-01-02 15:04")
+			age = formatAge(now.Sub(h.lastSuccess.Finished))
+		}
+		if h.lastFailure != nil {
+			failure = h.lastFailure.Finished.Local().Format("2006-01-02 15:04")
+		}
+		if h.expected != "" {
+			expected = h.expected
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", h.entry, success, age, failure, h.failures, expected, h.state())
+	}
+	tw.Flush()
+	for _, h := range healths {
+		switch {
+		case h.err != nil:
+			fmt.Fprintf(w, "\n%s: %v\n", h.entry, h.err)
+		case h.failures > 0 && h.lastFailure.Error != "":
+			fmt.Fprintf(w, "\n%s: %s\n", h.entry, h.lastFailure.Error)
+		}
+	}
+}
+
+// formatAge renders a duration to the minute, using days for long ones
+func formatAge(d time.Duration) string {
+	d = d.Round(time.Minute)
+	days := int(d / (24 * time.Hour))
+	hours := int(d % (24 * time.Hour) / time.Hour)
+	minutes := int(d % time.Hour / time.Minute)
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd%dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh%dm", hours, minutes)
+	}
+	return fmt.Sprintf("%dm", minutes)
+}
+
+// StatusOptions configures Status
+type StatusOptions struct {
+	// MaxAge is how old the last success of entries without MaxAge or
+	// Schedule may be, 0 for not checked
+	MaxAge time.Duration
+	// Grace is how long a scheduled entry may take to succeed after its
+	// scheduled time
+	Grace time.Duration
+	// StateDir is the StateDir the entries were run with
+	StateDir string
+}
+
+// Status writes to w how entries of library are doing according to the
+// run history, all of them if none are given. It fails when any entry is
+// overdue or misconfigured, so it can serve as a monitoring check.
+func Status(w io.Writer, library Library, entries []string, opts StatusOptions) error {
+	if len(entries) == 0 {
+		entries = sortedKeys(library.Entries)
+	}
+	records, err := loadHistory(historyPath(opts.StateDir))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var healths []entryHealth
+	var bad []string
+	for _, entry := range entries {
+		backup, ok := library.Entries[entry]
+		if !ok {
+			return configError{fmt.Errorf("no entry '%s' in the library", entry)}
+		}
+		h := checkEntryHealth(entry, backup, records, opts.MaxAge, opts.Grace, now)
+		if h.overdue || h.err != nil {
+			bad = append(bad, entry)
+		}
+		healths = append(healths, h)
+	}
+	writeStatus(w, healths, now)
+	if len(bad) > 0 {
+		return fmt.Errorf("%d of %d entries overdue or invalid: %s", len(bad), len(entries), strings.Join(bad, ", "))
+	}
+	return nil
+}
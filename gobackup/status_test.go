@@ -0,0 +1,89 @@
+package gobackup
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckEntryHealth(t *testing.T) {
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	at := func(hoursAgo int) time.Time { return now.Add(-time.Duration(hoursAgo) * time.Hour) }
+	records := []historyRecord{
+		{Entry: "db", Status: StatusSucceeded, Finished: at(50)},
+		{Entry: "web", Status: StatusFailed, Finished: at(40), Error: "old"},
+		{Entry: "db", Status: StatusFailed, Finished: at(30), Error: "disk full"},
+		{Entry: "web", Status: StatusSucceeded, Finished: at(20)},
+		{Entry: "db", Status: StatusSkipped, Finished: at(5), Error: "skipped"},
+	}
+
+	tests := []struct {
+		name     string
+		entry    string
+		backup   Backup
+		maxAge   time.Duration
+		failures int
+		overdue  bool
+		state    string
+	}{
+		{"MaxAge exceeded", "db", Backup{MaxAge: "26h"}, 0, 2, true, "OVERDUE"},
+		{"MaxAge met", "web", Backup{MaxAge: "26h"}, 0, 0, false, "ok"},
+		{"schedule missed", "web", Backup{Schedule: "0 2 * * *"}, 0, 0, true, "OVERDUE"},
+		{"unchecked failing", "db", Backup{}, 0, 2, false, "failing"},
+		{"default max age", "db", Backup{}, 72 * time.Hour, 2, false, "failing"},
+		{"never succeeded", "new", Backup{MaxAge: "24h"}, 0, 0, true, "OVERDUE"},
+		{"never run unchecked", "new", Backup{}, 0, 0, false, "never run"},
+		{"invalid MaxAge", "db", Backup{MaxAge: "daily"}, 0, 2, false, "INVALID"},
+	}
+	for _, tt := range tests {
+		h := checkEntryHealth(tt.entry, tt.backup, records, tt.maxAge, time.Hour, now)
+		if h.failures != tt.failures || h.overdue != tt.overdue || h.state() != tt.state {
+			t.Errorf("%s: failures=%d overdue=%v state=%s, want %d %v %s", tt.name, h.failures, h.overdue, h.state(), tt.failures, tt.overdue, tt.state)
+		}
+	}
+
+	// Scheduled daily at 02:00 with an hour of grace: a success at 01:00
+	// today is not yet overdue at 02:30 tomorrow, but is at 03:30
+	h := checkEntryHealth("web", Backup{Schedule: "0 2 * * *"}, []historyRecord{
+		{Entry: "web", Status: StatusSucceeded, Finished: time.Date(2024, 6, 10, 3, 0, 0, 0, time.Local)},
+	}, 0, time.Hour, time.Date(2024, 6, 11, 2, 30, 0, 0, time.Local))
+	if h.overdue || !h.deadline.Equal(time.Date(2024, 6, 11, 3, 0, 0, 0, time.Local)) {
+		t.Errorf("scheduled entry overdue=%v deadline=%v", h.overdue, h.deadline)
+	}
+}
+
+func TestWriteStatus(t *testing.T) {
+	now := time.Now()
+	records := []historyRecord{
+		{Entry: "torado", Status: StatusSucceeded, Finished: now.Add(-50 * time.Hour)},
+		{Entry: "torado", Status: StatusFailed, Finished: now.Add(-2 * time.Hour), Error: "rsync backup failed: exit status 23"},
+	}
+	h := checkEntryHealth("torado", Backup{MaxAge: "26h"}, records, 0, time.Hour, now)
+	var out strings.Builder
+	writeStatus(&out, []entryHealth{h}, now)
+
+	lines := strings.Split(out.String(), "\n")
+	if !strings.HasPrefix(lines[0], "ENTRY") || !strings.Contains(lines[0], "STATUS") {
+		t.Errorf("missing header: %q", lines[0])
+	}
+	fields := strings.Fields(lines[1])
+	if fields[0] != "torado" || fields[3] != "2d2h" || fields[6] != "1" || fields[7] != "26h" || fields[8] != "OVERDUE" {
+		t.Errorf("unexpected row: %q", lines[1])
+	}
+	if !strings.Contains(out.String(), "torado: rsync backup failed: exit status 23") {
+		t.Errorf("last error missing:\n%s", out.String())
+	}
+}
+
+func TestFormatAge(t *testing.T) {
+	tests := map[time.Duration]string{
+		90 * time.Second:              "2m",
+		5*time.Hour + 12*time.Minute:  "5h12m",
+		50*time.Hour + 29*time.Minute: "2d2h",
+	}
+	for d, expected := range tests {
+		if got := formatAge(d); got != expected {
+			t.Errorf("formatAge(%v) = %q, want %q", d, got, expected)
+		}
+	}
+}
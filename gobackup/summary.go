@@ -0,0 +1,212 @@
+package gobackup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Exit codes of gobackup, so scripts and service managers can tell what
+// went wrong without parsing the log
+const (
+	ExitOK             = 0
+	ExitTotalFailure   = 1   // every entry failed, or gobackup itself did
+	ExitConfigError    = 2   // bad command line or library
+	ExitPartialFailure = 3   // some entries failed, others succeeded
+	ExitWarnings       = 4   // every entry succeeded, some with warnings
+	ExitLockBusy       = 5   // entries failed only because another run holds them
+	ExitCancelled      = 130 // interrupted by SIGINT or SIGTERM
+)
+
+// configError marks a problem with the command line or the library rather
+// than with running a backup
+type configError struct {
+	err error
+}
+
+func (e configError) Error() string { return e.err.Error() }
+func (e configError) Unwrap() error { return e.err }
+
+// runError is how a run ended when not every entry succeeded cleanly
+type runError struct {
+	code int
+	msg  string
+}
+
+func (e *runError) Error() string { return e.msg }
+
+// ExitCode returns the process exit code for an error returned by a command
+func ExitCode(err error) int {
+	var runErr *runError
+	var cfgErr configError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &runErr):
+		return runErr.code
+	case errors.As(err, &cfgErr), isLibraryError(err):
+		return ExitConfigError
+	case errors.Is(err, context.Canceled):
+		return ExitCancelled
+	}
+	return ExitTotalFailure
+}
+
+// warnings returns what went wrong in an entry that still succeeded
+func (o outcome) warnings() []string {
+	var warnings []string
+	if o.changed > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d file(s) changed while archiving", o.changed))
+	}
+	if len(o.attempts) > 1 {
+		warnings = append(warnings, fmt.Sprintf("succeeded after %d attempts", len(o.attempts)))
+	}
+	return append(warnings, o.warned...)
+}
+
+// resultError works out the exit code of a run from its outcomes. It returns
+// nil if every entry succeeded without warnings.
+func resultError(report runReport) error {
+	var failed, skipped, cancelled, busy, warned int
+	for _, result := range report.outcomes {
+		var lockErr *LockBusyError
+		switch result.status {
+		case StatusFailed:
+			failed++
+			if errors.As(result.err, &lockErr) {
+				busy++
+			}
+		case StatusSkipped:
+			skipped++
+		case StatusCancelled:
+			cancelled++
+		case StatusSucceeded:
+			if len(result.warnings()) > 0 {
+				warned++
+			}
+		}
+	}
+	total := len(report.outcomes)
+	switch {
+	case cancelled > 0:
+		return &runError{ExitCancelled, fmt.Sprintf("run cancelled: %d backup(s) cancelled, %d failed", cancelled, failed)}
+	case failed > 0 && busy == failed && skipped == 0:
+		return &runError{ExitLockBusy, fmt.Sprintf("%d backup(s) already running in another process", busy)}
+	case failed+skipped == total && total > 0:
+		return &runError{ExitTotalFailure, fmt.Sprintf("all %d backup(s) failed or skipped: %d failed, %d skipped", total, failed, skipped)}
+	case skipped > 0:
+		return &runError{ExitPartialFailure, fmt.Sprintf("%d backup(s) failed, %d skipped", failed, skipped)}
+	case failed > 0:
+		return &runError{ExitPartialFailure, fmt.Sprintf("%d backup(s) failed", failed)}
+	case warned > 0:
+		return &runError{ExitWarnings, fmt.Sprintf("%d backup(s) succeeded with warnings", warned)}
+	}
+	return nil
+}
+
+// RunResult is how a run ended, as returned by Runner.Run and written by
+// --summary-json
+type RunResult struct {
+	RunID           string        `json:"RunID,omitempty"`
+	Started         time.Time     `json:"Started"`
+	Finished        time.Time     `json:"Finished"`
+	DurationSeconds float64       `json:"DurationSeconds"`
+	ExitCode        int           `json:"ExitCode"`
+	Entries         []EntryResult `json:"Entries"`
+}
+
+// EntryResult is how one entry of a run ended. Status is one of
+// StatusSucceeded, StatusFailed, StatusSkipped or StatusCancelled.
+type EntryResult struct {
+	Entry           string    `json:"Entry"`
+	Type            string    `json:"Type,omitempty"`
+	Status          string    `json:"Status"`
+	Started         time.Time `json:"Started"`
+	DurationSeconds float64   `json:"DurationSeconds"`
+	Archive         string    `json:"Archive,omitempty"`
+	Size            int64     `json:"Size,omitempty"`
+	Attempts        int       `json:"Attempts,omitempty"`
+	Removed         []string  `json:"Removed,omitempty"`
+	Warnings        []string  `json:"Warnings,omitempty"`
+	Error           string    `json:"Error,omitempty"`
+}
+
+// newRunResult describes report, which ended with err
+func newRunResult(report runReport, err error) RunResult {
+	summary := RunResult{
+		Started:         report.started,
+		Finished:        report.finished,
+		DurationSeconds: report.finished.Sub(report.started).Seconds(),
+		ExitCode:        ExitCode(err),
+		Entries:         []EntryResult{},
+	}
+	for _, result := range report.outcomes {
+		if summary.RunID == "" {
+			summary.RunID = result.runID
+		}
+		summary.Entries = append(summary.Entries, newEntryResult(result))
+	}
+	return summary
+}
+
+// newEntryResult describes how one entry ended
+func newEntryResult(result outcome) EntryResult {
+	entry := EntryResult{
+		Entry:           result.entry,
+		Type:            result.backup.Type,
+		Status:          result.status,
+		Started:         result.started,
+		DurationSeconds: result.duration.Seconds(),
+		Archive:         result.archive,
+		Size:            result.size,
+		Attempts:        len(result.attempts),
+		Removed:         result.removed,
+	}
+	if result.status == StatusSucceeded {
+		entry.Warnings = result.warnings()
+	}
+	if result.err != nil {
+		entry.Error = result.err.Error()
+	}
+	return entry
+}
+
+// WriteSummary prints a table of how every entry of the run ended
+func WriteSummary(w io.Writer, summary RunResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENTRY\tSTATUS\tDURATION\tSIZE\tATTEMPTS\tNOTES")
+	for _, e := range summary.Entries {
+		size, attempts := "-", "-"
+		if e.Archive != "" {
+			size = formatSize(e.Size)
+		}
+		if e.Attempts > 0 {
+			attempts = fmt.Sprint(e.Attempts)
+		}
+		notes := strings.Join(e.Warnings, "; ")
+		if e.Error != "" {
+			// Errors can carry command output; the first line says what failed
+			notes, _, _ = strings.Cut(e.Error, "\n")
+		}
+		duration := (time.Duration(e.DurationSeconds * float64(time.Second))).Round(time.Second)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Entry, e.Status, duration, size, attempts, notes)
+	}
+	tw.Flush()
+}
+
+// WriteSummaryJSON stores the summary at path for automation
+func WriteSummaryJSON(path string, summary RunResult) error {
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write run summary: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package gobackup
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunResultExitCodes(t *testing.T) {
+	ok := outcome{entry: "ok", status: StatusSucceeded}
+	warned := outcome{entry: "warned", status: StatusSucceeded, changed: 2}
+	retried := outcome{entry: "retried", status: StatusSucceeded, attempts: make([]Attempt, 2)}
+	failed := outcome{entry: "failed", status: StatusFailed, err: errors.New("disk full")}
+	busy := outcome{entry: "busy", status: StatusFailed, err: fmt.Errorf("backup 'busy' is already running: %w", &LockBusyError{Path: "x.lock"})}
+	skipped := outcome{entry: "skipped", status: StatusSkipped, err: errors.New("prerequisite failed")}
+	cancelled := outcome{entry: "cancelled", status: StatusCancelled, err: errors.New("context canceled")}
+
+	tests := []struct {
+		name     string
+		outcomes []outcome
+		want     int
+	}{
+		{"all succeeded", []outcome{ok, ok}, ExitOK},
+		{"tar warnings", []outcome{ok, warned}, ExitWarnings},
+		{"retried", []outcome{retried}, ExitWarnings},
+		{"one of two failed", []outcome{ok, failed}, ExitPartialFailure},
+		{"failed and skipped", []outcome{ok, failed, skipped}, ExitPartialFailure},
+		{"all failed", []outcome{failed, skipped}, ExitTotalFailure},
+		{"lock busy", []outcome{ok, busy}, ExitLockBusy},
+		{"lock busy and failed", []outcome{busy, failed, ok}, ExitPartialFailure},
+		{"cancelled", []outcome{ok, failed, cancelled}, ExitCancelled},
+	}
+	for _, tt := range tests {
+		err := resultError(runReport{outcomes: tt.outcomes})
+		if got := ExitCode(err); got != tt.want {
+			t.Errorf("%s: exit code = %d (%v), want %d", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	if got := ExitCode(configError{errors.New("no library")}); got != ExitConfigError {
+		t.Errorf("config error exit code = %d, want %d", got, ExitConfigError)
+	}
+	if got := ExitCode(fmt.Errorf("wrapped: %w", configError{errors.New("bad")})); got != ExitConfigError {
+		t.Errorf("wrapped config error exit code = %d, want %d", got, ExitConfigError)
+	}
+	if got := ExitCode(errors.New("boom")); got != ExitTotalFailure {
+		t.Errorf("other error exit code = %d, want %d", got, ExitTotalFailure)
+	}
+}
+
+func TestRunSummary(t *testing.T) {
+	started := time.Date(2024, 5, 1, 2, 0, 0, 0, time.UTC)
+	report := runReport{started: started, finished: started.Add(90 * time.Second), outcomes: []outcome{
+		{runID: "r1", entry: "photos", backup: Backup{Type: "tar"}, status: StatusSucceeded, started: started,
+			duration: time.Minute, archive: "/backups/photos.tar.gz", size: 2048, changed: 1},
+		{runID: "r1", entry: "nas", backup: Backup{Type: "rsync"}, status: StatusFailed, started: started,
+			duration: 30 * time.Second, attempts: make([]Attempt, 3), err: errors.New("rsync command failed: exit status 10\nOutput: connection reset")},
+	}}
+	err := resultError(report)
+	summary := newRunResult(report, err)
+
+	var out bytes.Buffer
+	WriteSummary(&out, summary)
+	table := out.String()
+	for _, want := range []string{
+		"ENTRY   STATUS     DURATION  SIZE     ATTEMPTS  NOTES",
+		"photos  succeeded  1m0s      2.0 KiB  -         1 file(s) changed while archiving",
+		"nas     failed     30s       -        3         rsync command failed: exit status 10",
+	} {
+		if !strings.Contains(table, want+"\n") {
+			t.Errorf("summary table does not contain %q:\n%s", want, table)
+		}
+	}
+	if strings.Contains(table, "connection reset") {
+		t.Error("summary table includes command output")
+	}
+
+	path := filepath.Join(t.TempDir(), "summary.json")
+	if err := WriteSummaryJSON(path, summary); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got RunResult
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("summary is not valid JSON: %v", err)
+	}
+	if got.RunID != "r1" || got.ExitCode != ExitPartialFailure || got.DurationSeconds != 90 || len(got.Entries) != 2 {
+		t.Errorf("summary = %+v", got)
+	}
+	if e := got.Entries[1]; e.Entry != "nas" || e.Attempts != 3 || !strings.Contains(e.Error, "connection reset") {
+		t.Errorf("nas entry = %+v", e)
+	}
+}
@@ -0,0 +1,375 @@
+package gobackup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslogDialTimeout bounds connecting to a syslog daemon and
+// syslogWriteTimeout sending one message to it
+const (
+	syslogDialTimeout  = 5 * time.Second
+	syslogWriteTimeout = time.Second
+)
+
+// syslogRetryDelay is how long a sink drops records after failing to
+// connect before it tries again, and syslogPendingLimit how many records it
+// keeps while connecting
+var (
+	syslogRetryDelay   = 30 * time.Second
+	syslogPendingLimit = 1000
+)
+
+// errSinkDisconnected is returned for records dropped while a sink cannot
+// reach its daemon
+var errSinkDisconnected = errors.New("log sink is not connected")
+
+// syslogSDID names the RFC 5424 structured data element carrying the record
+// attributes. 32473 is the private enterprise number reserved for examples.
+const syslogSDID = "gobackup@32473"
+
+// localSyslogSockets are tried in order when no syslog Address is configured
+var localSyslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// syslogFacilities maps facility names to their RFC 5424 codes
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSeverity maps a slog level to a syslog severity, which journald
+// uses as PRIORITY too
+func syslogSeverity(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3 // err
+	case level >= slog.LevelWarn:
+		return 4 // warning
+	case level >= slog.LevelInfo:
+		return 6 // info
+	}
+	return 7 // debug
+}
+
+// sinkConn is the connection to a log daemon shared by every handler
+// derived from one sink. Logging never waits for the daemon: the connection
+// is made in the background, holding the records logged meanwhile, and is
+// made again when a write fails, so a restarted daemon does not silence the
+// sink. After a failed attempt records are dropped until syslogRetryDelay
+// has passed.
+type sinkConn struct {
+	candidates [][2]string // network, address pairs tried in order
+
+	mu      sync.Mutex
+	conn    net.Conn
+	network string
+	closed  bool
+	dialing bool
+	retryAt time.Time // when to connect again after a failed attempt
+	pending [][]byte  // records waiting for the connection
+}
+
+// dial connects to the first candidate that accepts
+func (c *sinkConn) dial() (net.Conn, string, error) {
+	var errs []error
+	for _, candidate := range c.candidates {
+		conn, err := net.DialTimeout(candidate[0], candidate[1], syslogDialTimeout)
+		if err == nil {
+			return conn, candidate[0], nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, "", errors.Join(errs...)
+}
+
+// start connects in the background, holding the records logged meanwhile,
+// and passes report the error if the daemon cannot be reached
+func (c *sinkConn) start(report func(error)) {
+	c.mu.Lock()
+	c.dialing = true
+	c.mu.Unlock()
+	go func() {
+		if err := c.connect(); err != nil && report != nil {
+			report(err)
+		}
+	}()
+}
+
+// write sends one message, or holds it while connecting
+func (c *sinkConn) write(msg []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	if c.conn != nil {
+		if err := c.send(msg); err == nil {
+			return nil
+		}
+		c.conn.Close()
+		c.conn = nil
+	}
+	if !c.dialing && time.Now().Before(c.retryAt) {
+		return errSinkDisconnected
+	}
+	if len(c.pending) >= syslogPendingLimit {
+		return errSinkDisconnected
+	}
+	c.pending = append(c.pending, slices.Clone(msg))
+	if !c.dialing {
+		c.dialing = true
+		go c.connect()
+	}
+	return nil
+}
+
+// connect dials the daemon and sends the pending records, or drops them if
+// it cannot be reached
+func (c *sinkConn) connect() error {
+	conn, network, err := c.dial()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dialing = false
+	if err == nil && c.closed {
+		conn.Close()
+	}
+	if err != nil || c.closed {
+		c.pending = nil
+		c.retryAt = time.Now().Add(syslogRetryDelay)
+		return err
+	}
+	c.conn, c.network = conn, network
+	for _, msg := range c.pending {
+		if err := c.send(msg); err != nil {
+			c.conn.Close()
+			c.conn = nil
+			c.retryAt = time.Now().Add(syslogRetryDelay)
+			break
+		}
+	}
+	c.pending = nil
+	return nil
+}
+
+// send writes msg to the connection, framed for stream connections: octet
+// counting over TCP (RFC 6587) and newline termination over unix stream
+// sockets, where newlines within the message are escaped as #012 like
+// rsyslog does so they do not split it
+func (c *sinkConn) send(msg []byte) error {
+	frame := msg
+	switch c.network {
+	case "tcp":
+		frame = fmt.Appendf(nil, "%d %s", len(msg), msg)
+	case "unix":
+		frame = append(bytes.ReplaceAll(msg, []byte("\n"), []byte("#012")), '\n')
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func (c *sinkConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	c.pending = nil
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// syslogCandidates turns a syslog Address into the connections to try
+func syslogCandidates(address string) ([][2]string, error) {
+	var candidates [][2]string
+	if address == "" {
+		for _, path := range localSyslogSockets {
+			candidates = append(candidates, [2]string{"unixgram", path}, [2]string{"unix", path})
+		}
+		return candidates, nil
+	}
+	if strings.HasPrefix(address, "/") {
+		return [][2]string{{"unixgram", address}, {"unix", address}}, nil
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "unix":
+		return [][2]string{{"unixgram", u.Path}, {"unix", u.Path}}, nil
+	case "udp", "tcp":
+		if u.Hostname() == "" {
+			return nil, fmt.Errorf("missing host in %q", address)
+		}
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "514")
+		}
+		return [][2]string{{u.Scheme, host}}, nil
+	}
+	return nil, fmt.Errorf("unsupported scheme %q (supported: unix, udp, tcp)", u.Scheme)
+}
+
+// syslogHandler formats records as RFC 5424 messages with their attributes,
+// such as entry and run_id, as structured data
+type syslogHandler struct {
+	conn       *sinkConn
+	level      slog.Leveler
+	facility   int
+	identifier string
+	hostname   string
+	attrs      flatAttrs
+}
+
+func newSyslogHandler(conn *sinkConn, level slog.Leveler, facility int, identifier string) *syslogHandler {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &syslogHandler{conn: conn, level: level, facility: facility, identifier: identifier, hostname: hostname}
+}
+
+func (h *syslogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.conn.write(h.format(r))
+}
+
+// format renders r as
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD-ID PARAM="VALUE"...] MSG
+func (h *syslogHandler) format(r slog.Record) []byte {
+	var b bytes.Buffer
+	timestamp := r.Time
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d - ", h.facility*8+syslogSeverity(r.Level),
+		timestamp.Format("2006-01-02T15:04:05.000000Z07:00"), h.hostname, h.identifier, os.Getpid())
+	attrs := h.attrs.record(r)
+	if len(attrs) == 0 {
+		b.WriteByte('-')
+	} else {
+		b.WriteString("[" + syslogSDID)
+		for _, attr := range attrs {
+			fmt.Fprintf(&b, ` %s="%s"`, sdParamName(attr.key), sdParamEscaper.Replace(attr.value))
+		}
+		b.WriteByte(']')
+	}
+	b.WriteString(" " + r.Message)
+	return b.Bytes()
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = h.attrs.with(attrs)
+	return &clone
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.attrs = h.attrs.group(name)
+	return &clone
+}
+
+// sdParamEscaper escapes the characters RFC 5424 reserves in PARAM-VALUE
+var sdParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// sdParamName makes key a valid PARAM-NAME: at most 32 printable ASCII
+// characters other than '=', ' ', ']' and '"'
+func sdParamName(key string) string {
+	name := []byte(key)
+	for i, c := range name {
+		if c <= ' ' || c > '~' || c == '=' || c == ']' || c == '"' {
+			name[i] = '_'
+		}
+	}
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	return string(name)
+}
+
+// flatAttrs accumulates handler attributes for sinks that need them as flat
+// key/value pairs. Keys inside groups are named group.key.
+type flatAttrs struct {
+	prefix string
+	attrs  []flatAttr
+}
+
+type flatAttr struct {
+	key, value string
+}
+
+func (f flatAttrs) with(attrs []slog.Attr) flatAttrs {
+	out := flatAttrs{prefix: f.prefix, attrs: slices.Clip(f.attrs)}
+	for _, attr := range attrs {
+		out.attrs = appendFlatAttr(out.attrs, f.prefix, attr)
+	}
+	return out
+}
+
+func (f flatAttrs) group(name string) flatAttrs {
+	if name == "" {
+		return f
+	}
+	return flatAttrs{prefix: f.prefix + name + ".", attrs: f.attrs}
+}
+
+// record returns the handler attributes followed by those of r
+func (f flatAttrs) record(r slog.Record) []flatAttr {
+	attrs := slices.Clip(f.attrs)
+	r.Attrs(func(attr slog.Attr) bool {
+		attrs = appendFlatAttr(attrs, f.prefix, attr)
+		return true
+	})
+	return attrs
+}
+
+func appendFlatAttr(attrs []flatAttr, prefix string, attr slog.Attr) []flatAttr {
+	value := attr.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, member := range value.Group() {
+			attrs = appendFlatAttr(attrs, prefix, member)
+		}
+		return attrs
+	}
+	if attr.Key == "" {
+		return attrs
+	}
+	return append(attrs, flatAttr{key: prefix + attr.Key, value: value.String()})
+}
+
+// parseFacility returns the code of a syslog facility name or number
+func parseFacility(name string) (int, error) {
+	if name == "" {
+		return syslogFacilities["daemon"], nil
+	}
+	if code, ok := syslogFacilities[strings.ToLower(name)]; ok {
+		return code, nil
+	}
+	if code, err := strconv.Atoi(name); err == nil && code >= 0 && code <= 23 {
+		return code, nil
+	}
+	return 0, fmt.Errorf("unknown syslog facility %q", name)
+}
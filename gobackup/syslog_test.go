@@ -0,0 +1,233 @@
+package gobackup
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogCandidates(t *testing.T) {
+	tests := []struct {
+		address  string
+		expected [][2]string
+	}{
+		{"/dev/log", [][2]string{{"unixgram", "/dev/log"}, {"unix", "/dev/log"}}},
+		{"unix:///run/syslog.sock", [][2]string{{"unixgram", "/run/syslog.sock"}, {"unix", "/run/syslog.sock"}}},
+		{"udp://logs.lan", [][2]string{{"udp", "logs.lan:514"}}},
+		{"tcp://logs.lan:6514", [][2]string{{"tcp", "logs.lan:6514"}}},
+	}
+	for _, tt := range tests {
+		got, err := syslogCandidates(tt.address)
+		if err != nil || !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("syslogCandidates(%q) = %v, %v, want %v", tt.address, got, err, tt.expected)
+		}
+	}
+	if got, _ := syslogCandidates(""); len(got) != 2*len(localSyslogSockets) {
+		t.Errorf("default candidates = %v", got)
+	}
+	for _, address := range []string{"http://logs.lan", "udp://", "tcp://:514"} {
+		if _, err := syslogCandidates(address); err == nil {
+			t.Errorf("syslogCandidates(%q) succeeded", address)
+		}
+	}
+}
+
+func TestSyslogSinkUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer pc.Close()
+	sinks, err := openLogSinks([]LogSink{{Type: "syslog", Address: "udp://" + pc.LocalAddr().String(), Facility: "local3", Identifier: "gb"}}, discardLog)
+	if err != nil {
+		t.Fatalf("openLogSinks() failed: %v", err)
+	}
+	defer sinks.Close()
+	log := sinks.logger(discardLog)
+
+	log.With("run_id", "0a1b", "entry", "photos").Warn("Tar finished with warnings", "note", `say "hi" [ok]`)
+	log.Debug("below the sink level")
+
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4096)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("no syslog message: %v", err)
+	}
+	msg := string(buf[:n])
+	if !strings.HasPrefix(msg, "<156>1 ") {
+		t.Errorf("wrong PRI or version: %s", msg)
+	}
+	fields := strings.SplitN(msg, " ", 7)
+	if len(fields) < 7 || fields[3] != "gb" || fields[5] != "-" {
+		t.Fatalf("malformed header: %s", msg)
+	}
+	expected := `[gobackup@32473 run_id="0a1b" entry="photos" note="say \"hi\" [ok\]"] Tar finished with warnings`
+	if fields[6] != expected {
+		t.Errorf("structured data and message = %s, want %s", fields[6], expected)
+	}
+	pc.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := pc.ReadFrom(buf); err == nil {
+		t.Error("debug record sent despite info level")
+	}
+}
+
+func TestSyslogSinkTCPFraming(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer ln.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		prefix, err := r.ReadString(' ')
+		if err != nil {
+			return
+		}
+		length, err := strconv.Atoi(strings.TrimSpace(prefix))
+		if err != nil {
+			return
+		}
+		msg := make([]byte, length)
+		if _, err := io.ReadFull(r, msg); err == nil {
+			received <- string(msg)
+		}
+	}()
+
+	conn := &sinkConn{candidates: [][2]string{{"tcp", ln.Addr().String()}}}
+	defer conn.Close()
+	log := slog.New(newSyslogHandler(conn, slog.LevelInfo, 3, "gobackup")).With("entry", "db")
+	log.Error("rsync failed\nexit status 23")
+
+	select {
+	case msg := <-received:
+		if !strings.HasPrefix(msg, "<27>1 ") || !strings.HasSuffix(msg, `[gobackup@32473 entry="db"] rsync failed`+"\nexit status 23") {
+			t.Errorf("unexpected message %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received over TCP")
+	}
+}
+
+func TestOpenLogSinksValidation(t *testing.T) {
+	for _, sinks := range [][]LogSink{
+		{{Type: "loki"}},
+		{{Type: "syslog", Facility: "printer"}},
+		{{Type: "syslog", Address: "http://logs.lan"}},
+		{{Type: "journald", Level: "loud"}},
+	} {
+		if _, err := openLogSinks(sinks, discardLog); err == nil {
+			t.Errorf("openLogSinks(%+v) succeeded", sinks)
+		}
+	}
+}
+
+func TestOpenLogSinksDoesNotWaitForTheDaemon(t *testing.T) {
+	// A TEST-NET address, which nothing answers
+	started := time.Now()
+	sinks, err := openLogSinks([]LogSink{{Type: "syslog", Address: "tcp://192.0.2.1:514"}}, discardLog)
+	if err != nil {
+		t.Fatalf("openLogSinks() failed: %v", err)
+	}
+	defer sinks.Close()
+	sinks.logger(discardLog).Info("held while connecting")
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("openLogSinks() and the first record took %v", elapsed)
+	}
+}
+
+func TestSyslogSinkUnixStreamEscapesNewlines(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "log.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer ln.Close()
+	received := make(chan string, 2)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			received <- line
+		}
+	}()
+
+	conn := &sinkConn{candidates: [][2]string{{"unix", socket}}}
+	defer conn.Close()
+	conn.start(func(err error) { t.Errorf("start() failed: %v", err) })
+	log := slog.New(newSyslogHandler(conn, slog.LevelInfo, 3, "gobackup"))
+	log.Error("rsync failed\nexit status 23")
+	log.Info("next")
+
+	for _, want := range []string{"rsync failed#012exit status 23\n", "next\n"} {
+		select {
+		case line := <-received:
+			if !strings.HasSuffix(line, want) {
+				t.Errorf("line %q, want it to end in %q", line, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no message received over the unix socket")
+		}
+	}
+}
+
+func TestSinkConnDropsWhileUnreachable(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "log.sock")
+	conn := &sinkConn{candidates: [][2]string{{"unixgram", socket}}}
+	defer conn.Close()
+	failed := make(chan error, 1)
+	conn.start(func(err error) { failed <- err })
+	select {
+	case <-failed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("start() did not report the missing socket")
+	}
+	started := time.Now()
+	if err := conn.write([]byte("dropped")); !errors.Is(err, errSinkDisconnected) {
+		t.Errorf("write() = %v, want the record dropped", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("write() blocked for %v", elapsed)
+	}
+
+	// Once the retry delay has passed the next record reconnects
+	pc, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer pc.Close()
+	conn.mu.Lock()
+	conn.retryAt = time.Time{}
+	conn.mu.Unlock()
+	if err := conn.write([]byte("delivered")); err != nil {
+		t.Errorf("write() after the retry delay = %v", err)
+	}
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	n, err := pc.Read(buf)
+	if err != nil || string(buf[:n]) != "delivered" {
+		t.Errorf("received %q, %v, want the record held while connecting", buf[:n], err)
+	}
+}
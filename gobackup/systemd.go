@@ -0,0 +1,340 @@
+package gobackup
+
+import (
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// UnitFile is a generated systemd unit
+type UnitFile struct {
+	Name    string
+	Content string
+}
+
+// UnitConfig holds the settings shared by every generated unit
+type UnitConfig struct {
+	User             bool          // user units rather than system units
+	Binary           string        // absolute path of the gobackup binary
+	LibraryFile      string        // absolute path of the library the units run
+	Group            bool          // one unit per schedule rather than per entry
+	Jitter           time.Duration // RandomizedDelaySec= of the timers
+	Nice             int
+	CPUWeight        int    // 0 to omit
+	IOWeight         int    // 0 to omit
+	MemoryMax        string // empty to omit
+	OnFailureCommand string // run by FailureUnitName, with %i the failed unit
+}
+
+// FailureUnitName is the template unit the services start through
+// OnFailure= when they fail
+const FailureUnitName = "gobackup-failure@.service"
+
+// WriteUnits writes units into the systemd unit directory dir and returns
+// the paths written
+func WriteUnits(dir string, units []UnitFile) ([]string, error) {
+	var paths []string
+	for _, unit := range units {
+		path := filepath.Join(dir, unit.Name)
+		if err := writeFileAtomic(path, []byte(unit.Content), 0644); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// GenerateUnits builds a service and timer for every selected entry with a
+// Schedule (or for every distinct schedule and User when cfg.Group is set),
+// plus the template unit used by OnFailure=. With no selection all scheduled
+// entries are included.
+func GenerateUnits(library map[string]Backup, entries []string, cfg UnitConfig) ([]UnitFile, error) {
+	if len(entries) == 0 {
+		for _, name := range sortedKeys(library) {
+			if library[name].Schedule != "" {
+				entries = append(entries, name)
+			}
+		}
+		if len(entries) == 0 {
+			return nil, fmt.Errorf("no library entries have a Schedule")
+		}
+	}
+
+	type group struct {
+		unit     string
+		schedule string
+		entries  []string
+	}
+	var groups []*group
+	byKey := make(map[string]*group)
+	byUnit := make(map[string]*group)
+	for _, name := range entries {
+		backup, exists := library[name]
+		if !exists {
+			return nil, fmt.Errorf("no backup found with name '%s'", name)
+		}
+		if backup.Schedule == "" {
+			return nil, fmt.Errorf("entry '%s' has no Schedule", name)
+		}
+		if _, err := parseSchedule(backup.Schedule); err != nil {
+			return nil, fmt.Errorf("invalid schedule for '%s': %w", name, err)
+		}
+		key := "entry:" + name
+		unit := "gobackup-" + unitNameEscape(name)
+		if cfg.Group {
+			key = "schedule:" + backup.Schedule
+			unit = "gobackup-" + scheduleSlug(backup.Schedule)
+			// A system unit runs as one user, so entries of other users
+			// sharing the schedule get a unit of their own
+			if !cfg.User && backup.User != "" {
+				key += "\x00" + backup.User
+				unit += "-" + unitNameEscape(backup.User)
+			}
+		}
+		g, ok := byKey[key]
+		if !ok {
+			// Escaping can map different names to the same unit, which
+			// would silently overwrite one of them
+			if other, taken := byUnit[unit]; taken {
+				return nil, fmt.Errorf("entries '%s' and '%s' would both use unit %s; rename one of them", other.entries[0], name, unit)
+			}
+			g = &group{unit: unit, schedule: backup.Schedule}
+			byUnit[unit] = g
+			byKey[key] = g
+			groups = append(groups, g)
+		}
+		g.entries = append(g.entries, name)
+	}
+
+	var units []UnitFile
+	for _, g := range groups {
+		sched, _ := parseSchedule(g.schedule)
+		units = append(units,
+			UnitFile{Name: g.unit + ".service", Content: serviceUnit(library, g.entries, cfg)},
+			UnitFile{Name: g.unit + ".timer", Content: timerUnit(g.unit, g.entries, sched, cfg)},
+		)
+	}
+	units = append(units, UnitFile{Name: FailureUnitName, Content: failureUnit(cfg)})
+	return units, nil
+}
+
+// serviceUnit renders the oneshot service that runs entries
+func serviceUnit(library map[string]Backup, entries []string, cfg UnitConfig) string {
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	fmt.Fprintf(&b, "Description=gobackup %s\n", strings.Join(entries, ", "))
+	fmt.Fprintf(&b, "OnFailure=%s\n", strings.Replace(FailureUnitName, "@.", "@%n.", 1))
+	remote := false
+	for _, name := range entries {
+		backup := library[name]
+		if resourcesOf(&backup).Host != "" {
+			remote = true
+		}
+	}
+	if remote {
+		b.WriteString("Wants=network-online.target\n")
+		b.WriteString("After=network-online.target\n")
+	}
+
+	b.WriteString("\n[Service]\n")
+	b.WriteString("Type=oneshot\n")
+	fmt.Fprintf(&b, "ExecStart=%s %s %s\n",
+		systemdQuote(cfg.Binary), systemdQuote(strings.Join(entries, ",")), systemdQuote(cfg.LibraryFile))
+	// A run whose entries only had warnings succeeded, so it must not
+	// trigger OnFailure=
+	fmt.Fprintf(&b, "SuccessExitStatus=%d\n", ExitWarnings)
+	// WorkingDirectory= takes the path as is, quotes would be part of it
+	fmt.Fprintf(&b, "WorkingDirectory=%s\n", strings.ReplaceAll(filepath.Dir(cfg.LibraryFile), "%", "%%"))
+	if scratch := os.Getenv("SCRATCH"); scratch != "" {
+		// Environment= does not expand variables, so $ stays as it is
+		fmt.Fprintf(&b, "Environment=%s\n", strings.ReplaceAll(systemdQuote("SCRATCH="+scratch), "$$", "$"))
+	}
+	if !cfg.User {
+		// generateUnits only groups entries of the same user
+		if user := library[entries[0]].User; user != "" {
+			fmt.Fprintf(&b, "User=%s\n", user)
+		}
+	}
+	fmt.Fprintf(&b, "Nice=%d\n", cfg.Nice)
+	b.WriteString("IOSchedulingClass=best-effort\n")
+	b.WriteString("IOSchedulingPriority=7\n")
+	if cfg.CPUWeight > 0 {
+		fmt.Fprintf(&b, "CPUWeight=%d\n", cfg.CPUWeight)
+	}
+	if cfg.IOWeight > 0 {
+		fmt.Fprintf(&b, "IOWeight=%d\n", cfg.IOWeight)
+	}
+	if cfg.MemoryMax != "" {
+		fmt.Fprintf(&b, "MemoryMax=%s\n", cfg.MemoryMax)
+	}
+	return b.String()
+}
+
+// timerUnit renders the timer that activates a service on its schedule
+func timerUnit(unit string, entries []string, sched *cronSchedule, cfg UnitConfig) string {
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	fmt.Fprintf(&b, "Description=Schedule for gobackup %s\n", strings.Join(entries, ", "))
+	b.WriteString("\n[Timer]\n")
+	if sched.every > 0 {
+		fmt.Fprintf(&b, "OnBootSec=%s\n", systemdDuration(sched.every))
+		fmt.Fprintf(&b, "OnUnitActiveSec=%s\n", systemdDuration(sched.every))
+	} else {
+		for _, calendar := range onCalendar(sched) {
+			fmt.Fprintf(&b, "OnCalendar=%s\n", calendar)
+		}
+	}
+	b.WriteString("Persistent=true\n")
+	if cfg.Jitter > 0 {
+		fmt.Fprintf(&b, "RandomizedDelaySec=%s\n", systemdDuration(cfg.Jitter))
+	}
+	fmt.Fprintf(&b, "Unit=%s.service\n", unit)
+	b.WriteString("\n[Install]\n")
+	b.WriteString("WantedBy=timers.target\n")
+	return b.String()
+}
+
+// failureUnit renders the template unit that OnFailure= starts, with %i set
+// to the name of the failed unit
+func failureUnit(cfg UnitConfig) string {
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	b.WriteString("Description=gobackup failure handler for %i\n")
+	b.WriteString("\n[Service]\n")
+	b.WriteString("Type=oneshot\n")
+	// Specifiers such as %i are left for systemd to expand, variables for
+	// the shell
+	command := strings.ReplaceAll(cfg.OnFailureCommand, `\`, `\\`)
+	command = strings.ReplaceAll(command, `"`, `\"`)
+	command = strings.ReplaceAll(command, "$", "$$")
+	fmt.Fprintf(&b, "ExecStart=/bin/sh -c \"%s\"\n", command)
+	return b.String()
+}
+
+// onCalendar converts a cron schedule into systemd OnCalendar= expressions.
+// Cron runs when either day field matches if both are restricted, whereas
+// systemd requires both, so that case becomes two expressions.
+func onCalendar(sched *cronSchedule) []string {
+	minutes := calendarField(sched.minute, 0, 59, 2)
+	hours := calendarField(sched.hour, 0, 23, 2)
+	months := calendarField(sched.month, 1, 12, 2)
+	days := calendarField(sched.dom, 1, 31, 2)
+	weekdays := weekdayField(sched.dow)
+
+	format := func(weekdays, days string) string {
+		expr := fmt.Sprintf("*-%s-%s %s:%s:00", months, days, hours, minutes)
+		if weekdays != "" {
+			expr = weekdays + " " + expr
+		}
+		return expr
+	}
+	if !sched.domStar && !sched.dowStar {
+		return []string{format("", days), format(weekdays, "*")}
+	}
+	if sched.dowStar {
+		weekdays = ""
+	}
+	return []string{format(weekdays, days)}
+}
+
+// calendarField renders a cron bit set as a systemd list, collapsing runs of
+// three or more values into a range
+func calendarField(set uint64, min, max, width int) string {
+	if bits.OnesCount64(set>>uint(min)) == max-min+1 {
+		return "*"
+	}
+	var parts []string
+	for v := min; v <= max; v++ {
+		if set&(1<<uint(v)) == 0 {
+			continue
+		}
+		end := v
+		for end+1 <= max && set&(1<<uint(end+1)) != 0 {
+			end++
+		}
+		switch {
+		case end-v >= 2:
+			parts = append(parts, fmt.Sprintf("%0*d..%0*d", width, v, width, end))
+		case end > v:
+			parts = append(parts, fmt.Sprintf("%0*d,%0*d", width, v, width, end))
+		default:
+			parts = append(parts, fmt.Sprintf("%0*d", width, v))
+		}
+		v = end
+	}
+	return strings.Join(parts, ",")
+}
+
+// weekdayField renders the cron day-of-week bit set using systemd day names
+func weekdayField(set uint64) string {
+	names := []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+	// Reorder so Monday is bit 0 and Sunday bit 6, as systemd ranges expect
+	var week uint64
+	for i := 0; i < 7; i++ {
+		if set&(1<<uint((i+1)%7)) != 0 {
+			week |= 1 << uint(i)
+		}
+	}
+	if week == 0x7f {
+		return ""
+	}
+	var parts []string
+	for v := 0; v < 7; v++ {
+		if week&(1<<uint(v)) == 0 {
+			continue
+		}
+		end := v
+		for end+1 < 7 && week&(1<<uint(end+1)) != 0 {
+			end++
+		}
+		switch {
+		case end-v >= 2:
+			parts = append(parts, names[v]+".."+names[end])
+		case end > v:
+			parts = append(parts, names[v]+","+names[end])
+		default:
+			parts = append(parts, names[v])
+		}
+		v = end
+	}
+	return strings.Join(parts, ",")
+}
+
+// systemdDuration formats a duration as a systemd time span
+func systemdDuration(d time.Duration) string {
+	return fmt.Sprintf("%ds", int64(d.Round(time.Second)/time.Second))
+}
+
+var invalidUnitChars = regexp.MustCompile(`[^A-Za-z0-9:_.\\-]`)
+
+// unitNameEscape replaces characters that are not valid in unit names
+func unitNameEscape(name string) string {
+	return invalidUnitChars.ReplaceAllString(name, "_")
+}
+
+// scheduleSlug turns a schedule into something usable in a unit name, e.g.
+// "@daily" becomes "daily" and "30 2 * * *" becomes "30-2-any-any-any"
+func scheduleSlug(schedule string) string {
+	slug := strings.TrimPrefix(strings.TrimSpace(schedule), "@")
+	slug = strings.ReplaceAll(slug, "*", "any")
+	slug = regexp.MustCompile(`[^A-Za-z0-9]+`).ReplaceAllString(slug, "-")
+	return strings.Trim(slug, "-")
+}
+
+// systemdQuote quotes a word for an Exec line if needed, escaping % so
+// systemd does not treat it as a specifier and $ so it is not expanded as a
+// variable
+func systemdQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\;$%") {
+		return s
+	}
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "%", "%%")
+	s = strings.ReplaceAll(s, "$", "$$")
+	return `"` + s + `"`
+}
@@ -0,0 +1,195 @@
+package gobackup
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestOnCalendar(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected []string
+	}{
+		{"@daily", []string{"*-*-* 00:00:00"}},
+		{"30 2 * * *", []string{"*-*-* 02:30:00"}},
+		{"*/15 * * * *", []string{"*-*-* *:00,15,30,45:00"}},
+		{"0 9-17 * * mon-fri", []string{"Mon..Fri *-*-* 09..17:00:00"}},
+		{"0 3 * * 0,6", []string{"Sat,Sun *-*-* 03:00:00"}},
+		{"0 0 1 jan,jul *", []string{"*-01,07-01 00:00:00"}},
+		{"0 4 1 * sun", []string{"*-*-01 04:00:00", "Sun *-*-* 04:00:00"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			sched, err := parseSchedule(tt.spec)
+			if err != nil {
+				t.Fatalf("parseSchedule(%q) failed: %v", tt.spec, err)
+			}
+			got := onCalendar(sched)
+			if strings.Join(got, "|") != strings.Join(tt.expected, "|") {
+				t.Errorf("onCalendar() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGenerateUnits(t *testing.T) {
+	library := map[string]Backup{
+		"photos": {Source: "/srv/photos", Type: "tar", Schedule: "@daily", User: "backup"},
+		"torado": {Source: "snowpea@10.0.0.173:/home/snowpea/torado", Type: "rsync", Schedule: "@daily"},
+		"manual": {Source: "/srv/manual", Type: "tar"},
+	}
+	cfg := UnitConfig{
+		Binary:           "/usr/local/bin/gobackup",
+		LibraryFile:      "/etc/gobackup/library.json",
+		Nice:             10,
+		CPUWeight:        20,
+		MemoryMax:        "1G",
+		OnFailureCommand: "notify %i",
+	}
+
+	units, err := GenerateUnits(library, nil, cfg)
+	if err != nil {
+		t.Fatalf("GenerateUnits() failed: %v", err)
+	}
+	names := make(map[string]string)
+	for _, unit := range units {
+		names[unit.Name] = unit.Content
+	}
+	for _, name := range []string{"gobackup-photos.service", "gobackup-photos.timer", "gobackup-torado.service", "gobackup-torado.timer", FailureUnitName} {
+		if _, ok := names[name]; !ok {
+			t.Errorf("missing unit %s", name)
+		}
+	}
+	if _, ok := names["gobackup-manual.service"]; ok {
+		t.Error("generated a unit for an entry without a Schedule")
+	}
+
+	service := names["gobackup-photos.service"]
+	for _, want := range []string{
+		"ExecStart=/usr/local/bin/gobackup photos /etc/gobackup/library.json",
+		"OnFailure=gobackup-failure@%n.service",
+		"User=backup",
+		"Nice=10",
+		"CPUWeight=20",
+		"MemoryMax=1G",
+	} {
+		if !strings.Contains(service, want) {
+			t.Errorf("photos service missing %q:\n%s", want, service)
+		}
+	}
+	if !strings.Contains(names["gobackup-torado.service"], "After=network-online.target") {
+		t.Error("rsync service does not wait for the network")
+	}
+	timer := names["gobackup-photos.timer"]
+	for _, want := range []string{"OnCalendar=*-*-* 00:00:00", "Persistent=true", "Unit=gobackup-photos.service", "WantedBy=timers.target"} {
+		if !strings.Contains(timer, want) {
+			t.Errorf("photos timer missing %q:\n%s", want, timer)
+		}
+	}
+	if !strings.Contains(names[FailureUnitName], `ExecStart=/bin/sh -c "notify %i"`) {
+		t.Errorf("failure unit does not run the configured command:\n%s", names[FailureUnitName])
+	}
+}
+
+func TestServiceUnitTreatsWarningsAsSuccess(t *testing.T) {
+	library := map[string]Backup{"photos": {Source: "/srv/photos", Type: "tar", Schedule: "@daily"}}
+	service := serviceUnit(library, []string{"photos"}, UnitConfig{Binary: "gobackup", LibraryFile: "/lib.json"})
+	if want := fmt.Sprintf("SuccessExitStatus=%d\n", ExitWarnings); !strings.Contains(service, want) {
+		t.Errorf("service missing %q:\n%s", want, service)
+	}
+}
+
+func TestGenerateUnitsGrouped(t *testing.T) {
+	library := map[string]Backup{
+		"a": {Type: "tar", Schedule: "30 2 * * *"},
+		"b": {Type: "tar", Schedule: "30 2 * * *"},
+		"c": {Type: "tar", Schedule: "@weekly"},
+	}
+	units, err := GenerateUnits(library, nil, UnitConfig{Binary: "gobackup", LibraryFile: "/lib.json", Group: true})
+	if err != nil {
+		t.Fatalf("GenerateUnits() failed: %v", err)
+	}
+	if len(units) != 5 {
+		t.Fatalf("generated %d units, want 5", len(units))
+	}
+	if units[0].Name != "gobackup-30-2-any-any-any.service" {
+		t.Errorf("group unit name = %s", units[0].Name)
+	}
+	if !strings.Contains(units[0].Content, "ExecStart=gobackup a,b /lib.json") {
+		t.Errorf("grouped service does not run both entries:\n%s", units[0].Content)
+	}
+}
+
+func TestGenerateUnitsRejectsUnscheduledSelection(t *testing.T) {
+	library := map[string]Backup{"manual": {Type: "tar"}}
+	if _, err := GenerateUnits(library, []string{"manual"}, UnitConfig{}); err == nil {
+		t.Error("GenerateUnits() accepted an entry without a Schedule")
+	}
+	if _, err := GenerateUnits(library, []string{"missing"}, UnitConfig{}); err == nil {
+		t.Error("GenerateUnits() accepted an unknown entry")
+	}
+}
+
+func TestGenerateUnitsGroupsByUser(t *testing.T) {
+	library := map[string]Backup{
+		"a": {Type: "tar", Schedule: "@daily", User: "alice"},
+		"b": {Type: "tar", Schedule: "@daily", User: "bob"},
+		"c": {Type: "tar", Schedule: "@daily"},
+	}
+	units, err := GenerateUnits(library, nil, UnitConfig{Binary: "gobackup", LibraryFile: "/lib.json", Group: true})
+	if err != nil {
+		t.Fatalf("GenerateUnits() failed: %v", err)
+	}
+	services := make(map[string]string)
+	for _, unit := range units {
+		services[unit.Name] = unit.Content
+	}
+	for name, want := range map[string]string{
+		"gobackup-daily-alice.service": "User=alice",
+		"gobackup-daily-bob.service":   "User=bob",
+		"gobackup-daily.service":       "ExecStart=gobackup c /lib.json",
+	} {
+		if !strings.Contains(services[name], want) {
+			t.Errorf("%s missing %q:\n%s", name, want, services[name])
+		}
+	}
+	if strings.Contains(services["gobackup-daily.service"], "User=") {
+		t.Errorf("service of entries without a User switches user:\n%s", services["gobackup-daily.service"])
+	}
+}
+
+func TestServiceUnitQuoting(t *testing.T) {
+	t.Setenv("SCRATCH", "/var/tmp/$scratch")
+	library := map[string]Backup{"db": {Type: "command", Schedule: "@daily"}}
+	cfg := UnitConfig{Binary: "/opt/gobackup $HOME/bin", LibraryFile: "/srv/my backups/100%/library.json"}
+	service := serviceUnit(library, []string{"db"}, cfg)
+	for _, want := range []string{
+		`ExecStart="/opt/gobackup $$HOME/bin" db "/srv/my backups/100%%/library.json"`,
+		"WorkingDirectory=/srv/my backups/100%%\n",
+		`Environment="SCRATCH=/var/tmp/$scratch"`,
+	} {
+		if !strings.Contains(service, want) {
+			t.Errorf("service missing %q:\n%s", want, service)
+		}
+	}
+}
+
+func TestFailureUnitEscapesVariables(t *testing.T) {
+	unit := failureUnit(UnitConfig{OnFailureCommand: `curl -d "$HOSTNAME %i" https://example.com`})
+	if want := `ExecStart=/bin/sh -c "curl -d \"$$HOSTNAME %i\" https://example.com"`; !strings.Contains(unit, want) {
+		t.Errorf("failure unit missing %q:\n%s", want, unit)
+	}
+}
+
+func TestGenerateUnitsRejectsUnitNameCollisions(t *testing.T) {
+	library := map[string]Backup{
+		"a b": {Type: "tar", Schedule: "@daily"},
+		"a_b": {Type: "tar", Schedule: "@daily"},
+	}
+	_, err := GenerateUnits(library, nil, UnitConfig{})
+	if err == nil || !strings.Contains(err.Error(), "gobackup-a_b") {
+		t.Errorf("GenerateUnits() = %v, want an error naming the shared unit", err)
+	}
+}
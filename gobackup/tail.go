@@ -0,0 +1,81 @@
+package gobackup
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// outputTailLines is how many lines of an entry's output are kept for
+// failure reports
+const outputTailLines = 40
+
+// tailBuffer is an io.Writer that keeps only the last lines written to it,
+// in a ring of fixed size, so long output never grows memory
+type tailBuffer struct {
+	mu      sync.Mutex
+	lines   []string // ring of the last len(lines) complete lines
+	next    int      // where the next line goes
+	full    bool     // whether the ring has wrapped
+	partial string
+}
+
+func newTailBuffer(max int) *tailBuffer {
+	return &tailBuffer{lines: make([]string, max)}
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	text := t.partial + string(p)
+	parts := strings.Split(text, "\n")
+	t.partial = parts[len(parts)-1]
+	for _, line := range parts[:len(parts)-1] {
+		t.push(line)
+	}
+	return len(p), nil
+}
+
+// addLine keeps line as a complete line
+func (t *tailBuffer) addLine(line string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.push(line)
+}
+
+func (t *tailBuffer) push(line string) {
+	if len(t.lines) == 0 {
+		return
+	}
+	t.lines[t.next] = line
+	t.next = (t.next + 1) % len(t.lines)
+	if t.next == 0 {
+		t.full = true
+	}
+}
+
+// String returns the retained lines, including an unterminated last line
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var lines []string
+	if t.full {
+		lines = append(lines, t.lines[t.next:]...)
+	}
+	lines = append(lines, t.lines[:t.next]...)
+	if t.partial != "" {
+		lines = append(lines, t.partial)
+		if len(lines) > len(t.lines) {
+			lines = lines[1:]
+		}
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
+
+// withOutput appends the retained output of a failed command to err
+func withOutput(err error, tail *tailBuffer) error {
+	if output := tail.String(); output != "" {
+		return fmt.Errorf("%w\nOutput: %s", err, output)
+	}
+	return err
+}
@@ -0,0 +1,36 @@
+package gobackup
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTailBuffer(t *testing.T) {
+	tail := newTailBuffer(3)
+	for i := 1; i <= 5; i++ {
+		fmt.Fprintf(tail, "line %d\n", i)
+	}
+	if got, want := tail.String(), "line 3\nline 4\nline 5"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	// Lines may arrive in pieces, and the last one may be unterminated
+	tail.Write([]byte("line 6 sta"))
+	tail.Write([]byte("rts\nline 7"))
+	if got, want := tail.String(), "line 5\nline 6 starts\nline 7"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestTailBufferWrapsRing(t *testing.T) {
+	tail := newTailBuffer(3)
+	for i := 1; i <= 1000; i++ {
+		tail.addLine(fmt.Sprintf("line %d", i))
+	}
+	if got, want := tail.String(), "line 998\nline 999\nline 1000"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if len(tail.lines) != 3 {
+		t.Errorf("ring grew to %d lines", len(tail.lines))
+	}
+}
@@ -0,0 +1,509 @@
+package gobackup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// BackupResult describes the backup stored by running an entry
+type BackupResult struct {
+	ArchivePath  string
+	Removed      []string  // old backups deleted by retention
+	ChangedFiles int       // files tar reported as changed while reading
+	Attempts     []Attempt // tries at pulling an rsync source
+	Warnings     []string  // problems that did not fail the backup
+}
+
+func init() {
+	RegisterBackupType("tar", tarType{})
+}
+
+// tarType archives a local directory with tar
+type tarType struct{}
+
+func (tarType) Validate(backup *Backup) []error {
+	var problems []error
+	if backup.Source == "" {
+		problems = append(problems, fmt.Errorf("%s backup requires a Source", backup.Type))
+	}
+	if backup.Destination == "" {
+		problems = append(problems, fmt.Errorf("Destination is required"))
+	}
+	if _, _, err := tarCompression(backup); err != nil {
+		problems = append(problems, err)
+	}
+	return problems
+}
+
+func (tarType) Plan(backup *Backup, plan *Plan) {
+	if backup.ChangeDir {
+		if _, err := os.Stat(backup.Source); os.IsNotExist(err) {
+			plan.Problem(fmt.Errorf("source directory does not exist: %s", backup.Source))
+		}
+	}
+	plan.ListLocal(backup)
+	plan.Tar(backup)
+}
+
+func (tarType) Run(ctx context.Context, backup *Backup, log *slog.Logger, progress ProgressFunc) (*BackupResult, error) {
+	return tar(ctx, backup, log, progress)
+}
+
+func (tarType) Snapshots(backup *Backup) ([]Snapshot, error) {
+	return archiveSnapshots(backup)
+}
+
+func (tarType) Restore(ctx context.Context, backup *Backup, snapshot Snapshot, target string, log *slog.Logger) error {
+	return extractArchive(ctx, snapshot.Path, target, log)
+}
+
+// tar archives backup.Source into Destination. If ctx is cancelled tar is
+// killed and the partial archive removed.
+func tar(ctx context.Context, backup *Backup, log *slog.Logger, progress ProgressFunc) (*BackupResult, error) {
+	log = log.With("phase", "tar")
+	//Build the command
+	timestamp := time.Now().Format("2006.01.02_15.04.05")
+	if backup.ChangeDir == true {
+		log.Debug("Changing directory", "dir", backup.Source)
+	}
+	tarFlags, fileExtension, err := tarCompression(backup)
+	if err != nil {
+		return nil, err
+	}
+	if progress != nil && !backup.Verbose {
+		// tar lists the files it archives so they can be counted
+		tarFlags = strings.Replace(tarFlags, "-c", "-cv", 1)
+	}
+
+	// Create temporary file for the backup to avoid partial files in destination
+	var scratch string = GetEnv("SCRATCH", "/tmp")
+	tempFile, err := os.CreateTemp(scratch, fmt.Sprintf("gobackup_%s_%s_*.%s", backup.Name, timestamp, fileExtension))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tempFilePath := tempFile.Name()
+	tempFile.Close() // Close immediately, tar will write to it via command
+
+	// Clean up the temp file on any failure
+	defer removeIfExists(tempFilePath)
+
+	// Final destination path
+	finalPath := filepath.Join(backup.Destination, fmt.Sprintf("%s_%s.%s", backup.Name, timestamp, fileExtension))
+
+	// Build command to write to temp file first
+	cmdString := tarCommand(backup, tarFlags, tempFilePath)
+
+	//Run the command
+	log.Info("Beginning tar", "temp", tempFilePath)
+	log.Info("Executing command", "command", cmdString)
+
+	// Validate paths before running
+	if backup.ChangeDir {
+		if _, err := os.Stat(backup.Source); os.IsNotExist(err) {
+			return nil, fmt.Errorf("source directory does not exist: %s", backup.Source)
+		}
+	}
+
+	// Check if destination directory exists and is writable
+	if err := checkDestination(backup.Destination); err != nil {
+		return nil, err
+	}
+
+	// Progress comes from the number of files tar lists on stdout and the
+	// size of the archive so far, against the files and bytes of the source
+	// scanned alongside. Listed files are only counted, never stat'ed again.
+	meter := startProgress(progress, backup.Name, "tar", tempFilePath)
+	scanned := make(chan struct{})
+	if progress != nil {
+		go func() {
+			defer close(scanned)
+			scanSource(meter, backup.Source, backup.Excludes)
+		}()
+	} else {
+		close(scanned)
+	}
+	listing := newLineWriter(func(name string) {
+		if backup.Verbose {
+			log.Info(name)
+		}
+		if progress != nil && !strings.HasSuffix(name, "/") {
+			meter.files.Add(1)
+		}
+	})
+	// tar's messages are logged as they arrive and classified on the way,
+	// keeping only the last lines for the error message
+	tail := newTailBuffer(outputTailLines)
+	changedFiles, errorLines := 0, 0
+	stderr := newLineWriter(func(line string) {
+		tail.addLine(line)
+		level := tarMessageLevel(line)
+		switch {
+		case strings.Contains(line, fileChangedWarning):
+			changedFiles++
+		case level == slog.LevelError:
+			errorLines++
+		}
+		log.Log(context.Background(), level, line)
+	})
+	cmd := exec.CommandContext(ctx, "sh", "-c", cmdString)
+	cmd.Stdout = listing
+	cmd.Stderr = stderr
+	killGroupOnCancel(cmd)
+	err = cmd.Run()
+	listing.Flush()
+	stderr.Flush()
+	if err != nil {
+		// Stop the scan early, there is nothing left to report it against
+		meter.finish()
+	}
+	<-scanned
+	meter.finish()
+	if err != nil && ctx.Err() != nil {
+		return nil, fmt.Errorf("tar interrupted: %w", ctx.Err())
+	}
+
+	if err != nil {
+		// Try to get exit code if available
+		exitCode := "unknown"
+		if exitError, ok := err.(*exec.ExitError); ok {
+			exitCode = fmt.Sprintf("%d", exitError.ExitCode())
+		}
+
+		// Exit code 1 with nothing worse than warnings means files changed
+		// during the read. This is a common warning for live systems and
+		// doesn't mean the backup failed
+		if exitCode == "1" && errorLines == 0 {
+			log.Warn("Tar completed with warnings (backup is valid)", "changed", changedFiles)
+			// Continue to move file - don't return error
+		} else {
+			// This is a real error - return (defer will clean up temp file)
+			return nil, fmt.Errorf("tar command failed with exit code %s: %w\nCommand: %s\nOutput: %s",
+				exitCode, err, cmdString, tail.String())
+		}
+	} else {
+		// No error - normal success case
+		log.Info("Tar completed")
+	}
+
+	if err := moveIntoPlace(tempFilePath, finalPath, log); err != nil {
+		return nil, err
+	}
+
+	//Cleanup old backups
+	removed, err := cleanupOldBackups(backup, fileExtension, log)
+	if err != nil {
+		return nil, err
+	}
+	return &BackupResult{ArchivePath: finalPath, Removed: removed, ChangedFiles: changedFiles}, nil
+}
+
+// tarCompression returns the tar flags and archive file extension for the
+// entry's CompressionType
+func tarCompression(backup *Backup) (tarFlags, fileExtension string, err error) {
+	verboseFlag := ""
+	if backup.Verbose {
+		verboseFlag = "v"
+	}
+	// Determine compression type and tar flags
+	compressionType := backup.CompressionType
+	if compressionType == "" {
+		compressionType = "gzip" // default to gzip
+	}
+	switch compressionType {
+	case "gzip":
+		return fmt.Sprintf("-c%sf", verboseFlag), "tar.gz", nil
+	case "bzip2":
+		return fmt.Sprintf("-cj%sf", verboseFlag), "tar.bz2", nil
+	case "xz":
+		return fmt.Sprintf("-cJ%sf", verboseFlag), "tar.xz", nil
+	case "zstd":
+		return fmt.Sprintf("--zstd -c%sf", verboseFlag), "tar.zst", nil
+	}
+	return "", "", fmt.Errorf("invalid compression type: %s (supported: gzip, bzip2, xz, zstd)", compressionType)
+}
+
+// tarCommand returns the shell command that archives the entry's Source
+// into archivePath
+func tarCommand(backup *Backup, tarFlags, archivePath string) string {
+	changeDirFlag := ""
+	if backup.ChangeDir == true {
+		changeDirFlag = "-C "
+	}
+	return fmt.Sprintf("tar%s %s %s %s%s .",
+		excludeFlags(backup),
+		tarFlags,
+		shellQuote(archivePath),
+		changeDirFlag,
+		backup.Source,
+	)
+}
+
+// excludeFlags returns the --exclude options for the entry's Excludes,
+// which tar and rsync share
+func excludeFlags(backup *Backup) string {
+	flags := ""
+	for _, exclude := range backup.Excludes {
+		// Properly quote the exclude pattern for shell safety
+		flags += fmt.Sprintf(" --exclude=%s", shellQuote(exclude))
+	}
+	return flags
+}
+
+// removeIfExists removes a temporary file unless it has already been moved
+func removeIfExists(path string) {
+	if _, err := os.Stat(path); err == nil {
+		os.Remove(path)
+	}
+}
+
+// checkDestination verifies the destination is an existing, writable directory
+func checkDestination(destination string) error {
+	destInfo, err := os.Stat(destination)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("destination directory does not exist: %s", destination)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check destination directory: %w", err)
+	}
+	if !destInfo.IsDir() {
+		return fmt.Errorf("destination path is not a directory: %s", destination)
+	}
+	// Check if destination is writable
+	if destInfo.Mode().Perm()&0200 == 0 {
+		return fmt.Errorf("destination directory is not writable: %s", destination)
+	}
+	return nil
+}
+
+// moveIntoPlace moves a finished backup from scratch to its final path,
+// copying instead when the two are on different filesystems
+func moveIntoPlace(tempFilePath, finalPath string, log *slog.Logger) error {
+	// Move temp file to final destination (atomic operation on same filesystem)
+	log.Info("Moving backup from temporary location", "path", finalPath)
+	if err := os.Rename(tempFilePath, finalPath); err != nil {
+		// If rename fails due to cross-device link, fall back to copy
+		// Check for EXDEV error (invalid cross-device link)
+		isCrossDevice := false
+		if linkErr, ok := err.(*os.LinkError); ok {
+			if errno, ok := linkErr.Err.(syscall.Errno); ok && errno == syscall.EXDEV {
+				isCrossDevice = true
+			}
+		}
+		// Also check error message as fallback (for different error types)
+		if !isCrossDevice && strings.Contains(err.Error(), "invalid cross-device link") {
+			isCrossDevice = true
+		}
+
+		if !isCrossDevice {
+			return fmt.Errorf("failed to move backup to destination: %w", err)
+		}
+		log.Info("Cross-device move detected, copying file instead")
+		if err := copyFile(tempFilePath, finalPath, log); err != nil {
+			return fmt.Errorf("failed to copy backup to destination: %w", err)
+		}
+		// Remove temp file after successful copy
+		if err := os.Remove(tempFilePath); err != nil {
+			log.Warn("Failed to remove temporary file", "path", tempFilePath, "error", err)
+		}
+		log.Info("Backup successfully copied", "path", finalPath)
+		return nil
+	}
+	log.Info("Backup successfully moved", "path", finalPath)
+	return nil
+}
+
+// cleanupOldBackups removes the oldest backups of an entry with the given
+// file extension so that only Retain of them are kept, and returns the
+// files it removed
+func cleanupOldBackups(backup *Backup, extension string, log *slog.Logger) ([]string, error) {
+	log = log.With("phase", "retention")
+	pattern := filepath.Join(backup.Destination, backup.Name+"_*."+extension)
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to glob backup files: %w", err)
+	}
+
+	var removed []string
+	if filesToRemove := retentionVictims(files, backup.Retain); len(filesToRemove) > 0 {
+		log.Info("Removing old backup files", "count", len(filesToRemove), "retain", backup.Retain)
+
+		for _, file := range filesToRemove {
+			if err := os.Remove(file); err != nil {
+				log.Warn("Failed to remove old backup", "path", file, "error", err)
+				continue
+			}
+			log.Debug("Removed old backup", "path", file)
+			removed = append(removed, file)
+			// Its log file goes with it
+			if err := os.Remove(file + ".log"); err != nil && !os.IsNotExist(err) {
+				log.Warn("Failed to remove old backup log", "path", file+".log", "error", err)
+			}
+		}
+	}
+	if backup.Retain > 0 && len(files) > 0 {
+		oldestKept := files[len(files)-min(len(files), backup.Retain)]
+		cleanupFailedLogs(backup, filepath.Base(oldestKept), log)
+	}
+	return removed, nil
+}
+
+// retentionVictims returns the oldest of files, which are sorted oldest
+// first, so that only retain of them are kept
+func retentionVictims(files []string, retain int) []string {
+	if len(files) <= retain {
+		return nil
+	}
+	return files[:len(files)-retain]
+}
+
+// cleanupFailedLogs removes the logs of failed runs that are older than the
+// oldest backup still kept, so they are rotated along with the backups
+func cleanupFailedLogs(backup *Backup, oldestKept string, log *slog.Logger) {
+	logs, err := filepath.Glob(filepath.Join(backup.Destination, backup.Name+"_*.failed.log"))
+	if err != nil {
+		return
+	}
+	pattern := snapshotPattern(backup.Name)
+	for _, path := range logs {
+		// Timestamps sort lexically, so comparing the names compares times
+		name := filepath.Base(path)
+		if pattern.MatchString(name) && name < oldestKept {
+			if err := os.Remove(path); err != nil {
+				log.Warn("Failed to remove old failure log", "path", path, "error", err)
+			}
+		}
+	}
+}
+
+// snapshotPattern matches the file names of an entry's backups:
+// <Name>_<timestamp>.<extension>
+func snapshotPattern(name string) *regexp.Regexp {
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(name) + `_\d{4}\.\d{2}\.\d{2}_\d{2}\.\d{2}\.\d{2}\.`)
+}
+
+// listSnapshots returns the backups of an entry in its Destination, oldest
+// first. Log files stored next to them are not backups.
+func listSnapshots(backup *Backup) ([]string, error) {
+	dirEntries, err := os.ReadDir(backup.Destination)
+	if err != nil {
+		return nil, err
+	}
+	pattern := snapshotPattern(backup.Name)
+	var snapshots []string
+	for _, entry := range dirEntries {
+		if !entry.IsDir() && pattern.MatchString(entry.Name()) && !strings.HasSuffix(entry.Name(), ".log") {
+			snapshots = append(snapshots, filepath.Join(backup.Destination, entry.Name()))
+		}
+	}
+	return snapshots, nil
+}
+
+// Snapshot is one stored backup of an entry
+type Snapshot struct {
+	Entry string    `json:"Entry"`
+	Path  string    `json:"Path"`
+	Time  time.Time `json:"Time"` // when the backup was taken, from its name
+	Size  int64     `json:"Size"`
+}
+
+// ListSnapshots returns the stored backups of an entry, oldest first, as
+// its backup type finds them. The Name of backup must be set.
+func ListSnapshots(backup Backup) ([]Snapshot, error) {
+	t, err := backupTypeFor(backup.Type)
+	if err != nil {
+		return nil, err
+	}
+	return t.Snapshots(&backup)
+}
+
+// archiveSnapshots describes the files listSnapshots finds for backup
+func archiveSnapshots(backup *Backup) ([]Snapshot, error) {
+	paths, err := listSnapshots(backup)
+	if err != nil {
+		return nil, err
+	}
+	const layout = "2006.01.02_15.04.05"
+	snapshots := make([]Snapshot, 0, len(paths))
+	for _, path := range paths {
+		snapshot := Snapshot{Entry: backup.Name, Path: path}
+		// snapshotPattern guarantees the timestamp follows the name
+		stamp := strings.TrimPrefix(filepath.Base(path), backup.Name+"_")
+		snapshot.Time, _ = time.ParseInLocation(layout, stamp[:len(layout)], time.Local)
+		if info, err := os.Stat(path); err == nil {
+			snapshot.Size = info.Size()
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+// extractArchive unpacks the tar archive at path into target. tar
+// recognises the compression by itself.
+func extractArchive(ctx context.Context, path, target string, log *slog.Logger) error {
+	return runRestoreCommand(ctx, "tar", fmt.Sprintf("tar -xf %s -C %s", shellQuote(path), shellQuote(target)), log)
+}
+
+// fileChangedWarning is how tar reports a file that changed while it was
+// being archived
+const fileChangedWarning = "file changed as we read it"
+
+// tarMessageLevel classifies a line tar writes to stderr. Files changing or
+// disappearing under tar and skipped sockets still leave a valid archive;
+// notes such as removing the leading / from member names are harmless.
+// Anything else is an error.
+func tarMessageLevel(line string) slog.Level {
+	switch {
+	case strings.Contains(line, fileChangedWarning),
+		strings.Contains(line, "File removed before we read it"),
+		strings.Contains(line, "socket ignored"):
+		return slog.LevelWarn
+	case strings.Contains(line, "Removing leading"):
+		return slog.LevelInfo
+	}
+	return slog.LevelError
+}
+
+// copyFile copies a file from src to dst, preserving permissions
+func copyFile(src, dst string, log *slog.Logger) error {
+	sourceFile, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open source file: %w", err)
+	}
+	defer sourceFile.Close()
+
+	// Get source file info for permissions
+	sourceInfo, err := sourceFile.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat source file: %w", err)
+	}
+
+	// Create destination file with same permissions
+	destFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, sourceInfo.Mode())
+	if err != nil {
+		return fmt.Errorf("failed to create destination file: %w", err)
+	}
+	defer destFile.Close()
+
+	// Copy the file contents
+	_, err = io.Copy(destFile, sourceFile)
+	if err != nil {
+		return fmt.Errorf("failed to copy file contents: %w", err)
+	}
+
+	// Preserve timestamps
+	if err := os.Chtimes(dst, sourceInfo.ModTime(), sourceInfo.ModTime()); err != nil {
+		// Non-fatal, just log a warning
+		log.Warn("Failed to preserve timestamps", "error", err)
+	}
+
+	return nil
+}
@@ -1,7 +1,12 @@
-package main
+package gobackup
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -152,3 +157,69 @@ func TestTarFilePattern(t *testing.T) {
 		t.Errorf("Pattern = %s, want %s", pattern, expectedPattern)
 	}
 }
+
+// fakeTar puts a tar on PATH that writes script's output and exits with code
+func fakeTar(t *testing.T, script string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "tar"), []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatalf("failed to write tar stub: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestTarChangedFilesAreWarnings(t *testing.T) {
+	t.Setenv("SCRATCH", t.TempDir())
+	fakeTar(t, "echo 'tar: ./db: file changed as we read it' >&2\n"+
+		"echo \"tar: Removing leading \\`/' from member names\" >&2\n"+
+		"echo 'tar: ./log: file changed as we read it' >&2\nexit 1\n")
+	backup := Backup{Name: "live", Source: t.TempDir(), Destination: t.TempDir(), ChangeDir: true, Retain: 1}
+
+	var out bytes.Buffer
+	result, err := tar(context.Background(), &backup, slog.New(slog.NewTextHandler(&out, nil)), nil)
+	if err != nil {
+		t.Fatalf("tar() failed on file changed warnings: %v", err)
+	}
+	if result.ChangedFiles != 2 {
+		t.Errorf("ChangedFiles = %d, want 2", result.ChangedFiles)
+	}
+	if !strings.Contains(out.String(), `level=WARN msg="tar: ./db: file changed as we read it"`) ||
+		!strings.Contains(out.String(), "level=INFO msg=\"tar: Removing leading") {
+		t.Errorf("messages not classified:\n%s", out.String())
+	}
+}
+
+func TestTarErrorKeepsOutputTail(t *testing.T) {
+	t.Setenv("SCRATCH", t.TempDir())
+	fakeTar(t, "i=0; while [ $i -lt 5000 ]; do echo \"tar: ./f$i: Cannot open: Permission denied\" >&2; i=$((i+1)); done\n"+
+		"echo 'tar: ./db: file changed as we read it' >&2\nexit 1\n")
+	backup := Backup{Name: "locked", Source: t.TempDir(), Destination: t.TempDir(), ChangeDir: true, Retain: 1}
+
+	_, err := tar(context.Background(), &backup, discardLog, nil)
+	if err == nil {
+		t.Fatal("tar() succeeded despite errors alongside the warnings")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "f4999: Cannot open") || strings.Contains(msg, "./f0:") {
+		t.Errorf("error does not end with the last output lines: %.200s", msg)
+	}
+	if lines := strings.Count(msg, "\n"); lines > outputTailLines+3 {
+		t.Errorf("error has %d lines, want at most the output tail", lines)
+	}
+}
+
+func TestTarMessageLevel(t *testing.T) {
+	tests := map[string]slog.Level{
+		"tar: ./db: file changed as we read it":          slog.LevelWarn,
+		"tar: ./tmp/x: File removed before we read it":   slog.LevelWarn,
+		"tar: ./run/sock: socket ignored":                slog.LevelWarn,
+		"tar: Removing leading `/' from member names":    slog.LevelInfo,
+		"tar: ./secret: Cannot open: Permission denied":  slog.LevelError,
+		"tar: Exiting with failure status due to errors": slog.LevelError,
+	}
+	for line, want := range tests {
+		if got := tarMessageLevel(line); got != want {
+			t.Errorf("tarMessageLevel(%q) = %v, want %v", line, got, want)
+		}
+	}
+}
@@ -7,14 +7,14 @@ echo "Running tests for gobackup..."
 
 # Run all tests
 echo "Running unit tests..."
-go test -v ./cmd/...
+go test -v ./cmd/... ./gobackup/...
 
 echo "Running integration tests..."
 go test -v ./test/...
 
 # Run tests with coverage
 echo "Running tests with coverage..."
-go test -v -coverprofile=coverage.out ./cmd/... ./test/...
+go test -v -coverprofile=coverage.out ./cmd/... ./gobackup/... ./test/...
 
 # Generate coverage report
 echo "Generating coverage report..."
@@ -22,11 +22,11 @@ go tool cover -html=coverage.out -o coverage.html
 
 # Run linting
 echo "Running linting..."
-go vet ./cmd/... ./test/...
+go vet ./cmd/... ./gobackup/... ./test/...
 
 # Check formatting
 echo "Checking formatting..."
-go fmt ./cmd/... ./test/...
+go fmt ./cmd/... ./gobackup/... ./test/...
 
 echo "Tests completed successfully!"
 echo "Coverage report generated: coverage.html"